@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// =======================
+// MODEL
+// =======================
+
+// CategoryACL restricts who may see and change a category. Entries are
+// "user:<name>" or "role:<name>". An empty Read list lets everyone read; an
+// empty Write list lets every reader write. Writers can always read.
+type CategoryACL struct {
+	Read  []string `json:"read,omitempty"`
+	Write []string `json:"write,omitempty"`
+}
+
+// open reports whether the ACL restricts nothing, so it can be dropped.
+func (a *CategoryACL) open() bool {
+	return a == nil || (len(a.Read) == 0 && len(a.Write) == 0)
+}
+
+// Principal is who a request acts for. A nil *Principal is the service
+// itself, e.g. a scheduled job, and is never restricted.
+type Principal struct {
+	User  string   `json:"user,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+	Admin bool     `json:"admin,omitempty"`
+}
+
+// =======================
+// CONFIGURATION
+// =======================
+
+var (
+	// aclRoles gives users roles (ACL_ROLES, e.g.
+	// "alice=editors|buyers,bob=viewers"), on top of those a trusted proxy
+	// passes in X-Forwarded-Groups.
+	aclRoles = map[string][]string{}
+	// aclAdmins are ACL entries that bypass every ACL (ACL_ADMINS, e.g.
+	// "role:admins,user:ops").
+	aclAdmins []string
+)
+
+// configureACL reads ACL_ROLES and ACL_ADMINS.
+func configureACL() {
+	for _, entry := range splitList(os.Getenv("ACL_ROLES")) {
+		user, roles, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(user) == "" {
+			log.Fatalf("invalid ACL_ROLES entry %q: want user=role|role", entry)
+		}
+		for _, role := range strings.Split(roles, "|") {
+			if role = strings.TrimSpace(role); role != "" {
+				aclRoles[strings.TrimSpace(user)] = append(aclRoles[strings.TrimSpace(user)], role)
+			}
+		}
+	}
+	admins, err := normalizeACLEntries(splitList(os.Getenv("ACL_ADMINS")))
+	if err != nil {
+		log.Fatalf("ACL_ADMINS: %v", err)
+	}
+	aclAdmins = admins
+}
+
+// principalKey carries the principal of an async operation into its replay,
+// which has neither the client certificate nor the proxy connection.
+type principalKey struct{}
+
+// requestPrincipal identifies the caller: the mTLS identity, else the
+// X-Forwarded-User of a trusted proxy. Roles come from ACL_ROLES and, from
+// a trusted proxy, X-Forwarded-Groups. A caller with neither is anonymous
+// and only sees open categories.
+func requestPrincipal(r *http.Request) *Principal {
+	if p, ok := r.Context().Value(principalKey{}).(*Principal); ok {
+		return p
+	}
+	p := &Principal{User: requestIdentity(r)}
+	trusted := fromTrustedProxy(r)
+	if p.User == "" && trusted {
+		p.User = strings.TrimSpace(r.Header.Get("X-Forwarded-User"))
+	}
+	if trusted {
+		p.Roles = splitList(strings.Join(r.Header.Values("X-Forwarded-Groups"), ","))
+	}
+	if p.User != "" {
+		p.Roles = append(p.Roles, aclRoles[p.User]...)
+	}
+	p.Admin = p.matches(aclAdmins)
+	return p
+}
+
+// withPrincipal attaches p to a request replayed on its behalf.
+func withPrincipal(r *http.Request, p *Principal) *http.Request {
+	if p == nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+}
+
+// samePrincipal reports whether a and b are the same caller: the same user,
+// or for anonymous callers the same roles.
+func samePrincipal(a, b *Principal) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.User != "" || b.User != "" {
+		return a.User == b.User
+	}
+	if len(a.Roles) != len(b.Roles) {
+		return false
+	}
+	for _, role := range a.Roles {
+		if !containsString(b.Roles, role) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether any ACL entry names p or one of its roles.
+func (p *Principal) matches(entries []string) bool {
+	for _, e := range entries {
+		kind, name, _ := strings.Cut(e, ":")
+		switch {
+		case kind == "user" && p.User != "" && name == p.User:
+			return true
+		case kind == "role" && containsString(p.Roles, name):
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// =======================
+// ENFORCEMENT
+// =======================
+
+func canReadCategory(p *Principal, c *Category) bool {
+	if p == nil || p.Admin || c.ACL.open() || len(c.ACL.Read) == 0 {
+		return true
+	}
+	return p.matches(c.ACL.Read) || p.matches(c.ACL.Write)
+}
+
+func canWriteCategory(p *Principal, c *Category) bool {
+	if p == nil || p.Admin || c.ACL.open() {
+		return true
+	}
+	if len(c.ACL.Write) == 0 {
+		return canReadCategory(p, c)
+	}
+	return p.matches(c.ACL.Write)
+}
+
+// readableCategories filters a list down to what p may read.
+func readableCategories(p *Principal, list []*Category) []*Category {
+	result := []*Category{}
+	for _, c := range list {
+		if canReadCategory(p, c) {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// authorizeCategory enforces the ACL of the category a /categories/{id}/...
+// request addresses. Reads need read access and everything else write
+// access, except cloning, which only reads the source. A category the
+// caller can't read answers 404, so its existence doesn't leak. A deleted
+// category is judged by the ACL it had, kept on the soft-deleted record or
+// in its last revision, since its history still shows its data; one that
+// never existed is left for the handler to report.
+func authorizeCategory(r *http.Request, parts []string) error {
+	if len(parts) < 2 {
+		return nil
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil
+	}
+	c, ok := categoryForACL(id)
+	if !ok {
+		return nil
+	}
+	p := requestPrincipal(r)
+	if !canReadCategory(p, c) {
+		return &statusError{CodeCategoryNotFound, "category not found"}
+	}
+	readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead || (len(parts) == 3 && parts[2] == "clone")
+	if !readOnly && !canWriteCategory(p, c) {
+		return &statusError{CodeAccessDenied, "you may read this category but not change it"}
+	}
+	return nil
+}
+
+// categoryForACL finds the ACL that governs a category ID: the live or
+// soft-deleted record, else the last revision of a purged or hard-deleted
+// one. Callers hold storeMu.
+func categoryForACL(id int) (*Category, bool) {
+	if c, ok := categories[id]; ok {
+		return c, true
+	}
+	if revs := categoryHistory[id]; len(revs) > 0 {
+		last := revs[len(revs)-1].Category
+		return &last, true
+	}
+	return nil, false
+}
+
+// canReadCategoryID is canReadCategory by ID, with a deleted category judged
+// like authorizeCategory does. IDs that never existed hide nothing.
+func canReadCategoryID(p *Principal, id int) bool {
+	c, ok := categoryForACL(id)
+	return !ok || canReadCategory(p, c)
+}
+
+// checkACLLockout rejects an ACL that p couldn't write through, so nobody
+// but an admin can take a category out of their own hands.
+func checkACLLockout(p *Principal, acl *CategoryACL) error {
+	if acl.open() || canWriteCategory(p, &Category{ACL: acl}) {
+		return nil
+	}
+	return &statusError{CodeValidationFailed, "acl must grant you write access; only ACL_ADMINS may hand a category to others"}
+}
+
+// =======================
+// VALIDATION
+// =======================
+
+// equalACLs compares two ACLs; every open one is equal.
+func equalACLs(a, b *CategoryACL) bool {
+	if a.open() || b.open() {
+		return a.open() == b.open()
+	}
+	return equalStrings(a.Read, b.Read) && equalStrings(a.Write, b.Write)
+}
+
+// validateACL trims and deduplicates the entries of an ACL.
+func validateACL(acl *CategoryACL) error {
+	if acl == nil {
+		return nil
+	}
+	var err error
+	if acl.Read, err = normalizeACLEntries(acl.Read); err != nil {
+		return err
+	}
+	acl.Write, err = normalizeACLEntries(acl.Write)
+	return err
+}
+
+func normalizeACLEntries(entries []string) ([]string, error) {
+	result := []string{}
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		kind, name, _ := strings.Cut(e, ":")
+		if (kind != "user" && kind != "role") || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid acl entry %q: want user:<name> or role:<name>", e)
+		}
+		if e = kind + ":" + strings.TrimSpace(name); !containsString(result, e) {
+			result = append(result, e)
+		}
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
+	"time"
+)
+
+// =======================
+// ADMIN LISTENER
+// =======================
+
+// adminAddr is the internal address for operational endpoints (ADMIN_ADDR,
+// e.g. ":9090" or "127.0.0.1:9090"). When set, /metrics and /admin/* are
+// only served there, along with the profiles under /debug/pprof/; the
+// public port answers 404 for them. Probes are served on both, since load
+// balancers check the public port. Empty keeps everything on PORT and
+// leaves profiling off.
+var adminAddr = ""
+
+func configureAdmin() {
+	adminAddr = os.Getenv("ADMIN_ADDR")
+}
+
+// adminOnly reports whether a path belongs on the admin listener.
+func adminOnly(path string) bool {
+	return path == "/metrics" || path == "/admin" || strings.HasPrefix(path, "/admin/") || strings.HasPrefix(path, "/debug/")
+}
+
+// hideAdminRoutes answers 404 on the public port for what the admin
+// listener serves. Importing net/http/pprof registers the profiles on the
+// default mux too, so /debug/ is hidden even without an admin listener.
+func hideAdminRoutes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminOnly(r.URL.Path) && (adminAddr != "" || strings.HasPrefix(r.URL.Path, "/debug/")) {
+			writeAPIError(w, CodeRouteNotFound, "no route for "+r.URL.Path)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminHandler serves the net/http/pprof profiles for go tool pprof and
+// passes the other admin routes and the probes to the API handler; the rest
+// of the API isn't reachable here.
+func adminHandler(api http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/livez", "/readyz", "/startupz":
+		default:
+			if !adminOnly(r.URL.Path) {
+				writeAPIError(w, CodeRouteNotFound, "no route for "+r.URL.Path+" on the admin listener")
+				return
+			}
+		}
+		api.ServeHTTP(w, r)
+	})
+	return mux
+}
+
+// startAdmin serves the admin listener. After a graceful restart the
+// previous process still holds the port until it has drained, so binding is
+// retried until it lets go.
+func startAdmin(api http.Handler) *http.Server {
+	srv := &http.Server{Addr: adminAddr, Handler: adminHandler(api)}
+	go func() {
+		deadline := time.Now().Add(shutdownDelay + shutdownTimeout + shutdownHookTimeout)
+		for {
+			ln, err := net.Listen("tcp", adminAddr)
+			if err == nil {
+				log.Println("admin listener running at", ln.Addr())
+				if err := srv.Serve(ln); err != http.ErrServerClosed {
+					log.Printf("admin listener: %v", err)
+				}
+				return
+			}
+			if !inherited || time.Now().After(deadline) {
+				log.Fatalf("ADMIN_ADDR: %v", err)
+			}
+			time.Sleep(250 * time.Millisecond)
+		}
+	}()
+	return srv
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =======================
+// USAGE ANALYTICS
+// =======================
+
+// UsageRow is the traffic of one route/method/client over the queried range.
+// Errors counts 4xx responses, ServerErrors 5xx ones; ErrorRate covers both.
+type UsageRow struct {
+	Route        string  `json:"route,omitempty"`
+	Method       string  `json:"method,omitempty"`
+	Client       string  `json:"client,omitempty"`
+	Requests     int64   `json:"requests"`
+	Errors       int64   `json:"errors"`
+	ServerErrors int64   `json:"server_errors"`
+	ErrorRate    float64 `json:"error_rate"`
+	BytesIn      int64   `json:"bytes_in"`
+	BytesOut     int64   `json:"bytes_out"`
+	AvgBytesOut  int64   `json:"avg_bytes_out"`
+}
+
+// UsageReport is the answer of GET /admin/analytics.
+type UsageReport struct {
+	From       time.Time  `json:"from"`
+	To         time.Time  `json:"to"`
+	Resolution string     `json:"resolution"`
+	Rows       []UsageRow `json:"rows"`
+}
+
+type usageKey struct {
+	bucket                int64 // start of the bucket, unix seconds
+	route, method, client string
+}
+
+type usageCounts struct {
+	requests, errors, serverErrors, bytesIn, bytesOut int64
+}
+
+var (
+	// analyticsResolution is the bucket size usage is aggregated to
+	// (ANALYTICS_RESOLUTION); it is also the precision of the range filters.
+	analyticsResolution = time.Hour
+	// analyticsRetention is how long buckets are kept (ANALYTICS_RETENTION).
+	analyticsRetention = 30 * 24 * time.Hour
+	// analyticsMaxClients caps distinct clients per bucket
+	// (ANALYTICS_MAX_CLIENTS); the rest are counted as "other" so a client
+	// inventing ids can't grow the store without bound.
+	analyticsMaxClients = 1000
+	// analyticsClientHeader names the header clients identify themselves
+	// with (ANALYTICS_CLIENT_HEADER); without it the remote IP is used.
+	analyticsClientHeader = "X-Client-ID"
+
+	usageMu      sync.Mutex
+	usage        = map[usageKey]*usageCounts{}
+	usageClients = map[int64]map[string]bool{}
+)
+
+const maxClientIDLength = 64
+
+// recordUsage counts every request into the analytics store.
+func recordUsage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		addUsage(time.Now(), routeLabel(r.URL.Path), r.Method, usageClient(r), rec.statusOrOK(), body.n, int64(rec.bytes))
+	})
+}
+
+// usageClient is the client certificate identity, else the client id
+// header, else the client IP.
+func usageClient(r *http.Request) string {
+	if id := requestIdentity(r); id != "" {
+		return id
+	}
+	if id := strings.TrimSpace(r.Header.Get(analyticsClientHeader)); id != "" {
+		if len(id) > maxClientIDLength {
+			id = id[:maxClientIDLength]
+		}
+		return id
+	}
+	return clientIP(r)
+}
+
+func addUsage(now time.Time, route, method, client string, status int, bytesIn, bytesOut int64) {
+	bucket := now.Truncate(analyticsResolution).Unix()
+
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	clients := usageClients[bucket]
+	if clients == nil {
+		clients = map[string]bool{}
+		usageClients[bucket] = clients
+		pruneUsageLocked(now)
+	}
+	if !clients[client] {
+		if len(clients) >= analyticsMaxClients {
+			client = "other"
+		}
+		clients[client] = true
+	}
+
+	key := usageKey{bucket, route, method, client}
+	c := usage[key]
+	if c == nil {
+		c = &usageCounts{}
+		usage[key] = c
+	}
+	c.requests++
+	switch {
+	case status >= 500:
+		c.serverErrors++
+	case status >= 400:
+		c.errors++
+	}
+	c.bytesIn += bytesIn
+	c.bytesOut += bytesOut
+}
+
+// pruneUsageLocked drops buckets older than the retention. It runs once per
+// new bucket, which is often enough.
+func pruneUsageLocked(now time.Time) {
+	cutoff := now.Add(-analyticsRetention).Unix()
+	for bucket := range usageClients {
+		if bucket < cutoff {
+			delete(usageClients, bucket)
+		}
+	}
+	for key := range usage {
+		if key.bucket < cutoff {
+			delete(usage, key)
+		}
+	}
+}
+
+// countingReader counts the request body bytes a handler actually read.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// queryUsage sums the buckets starting in [from, to) that match the filters,
+// grouped by the dimensions listed in groupBy.
+func queryUsage(from, to time.Time, route, client string, groupBy map[string]bool) []UsageRow {
+	type group struct{ route, method, client string }
+	sums := map[group]*usageCounts{}
+
+	usageMu.Lock()
+	for key, c := range usage {
+		if key.bucket < from.Unix() || key.bucket >= to.Unix() {
+			continue
+		}
+		if (route != "" && key.route != route) || (client != "" && key.client != client) {
+			continue
+		}
+		var g group
+		if groupBy["route"] {
+			g.route, g.method = key.route, key.method
+		}
+		if groupBy["client"] {
+			g.client = key.client
+		}
+		s := sums[g]
+		if s == nil {
+			s = &usageCounts{}
+			sums[g] = s
+		}
+		s.requests += c.requests
+		s.errors += c.errors
+		s.serverErrors += c.serverErrors
+		s.bytesIn += c.bytesIn
+		s.bytesOut += c.bytesOut
+	}
+	usageMu.Unlock()
+
+	rows := []UsageRow{}
+	for g, s := range sums {
+		rows = append(rows, UsageRow{
+			Route:        g.route,
+			Method:       g.method,
+			Client:       g.client,
+			Requests:     s.requests,
+			Errors:       s.errors,
+			ServerErrors: s.serverErrors,
+			ErrorRate:    float64(s.errors+s.serverErrors) / float64(s.requests),
+			BytesIn:      s.bytesIn,
+			BytesOut:     s.bytesOut,
+			AvgBytesOut:  s.bytesOut / s.requests,
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Requests != rows[j].Requests {
+			return rows[i].Requests > rows[j].Requests
+		}
+		a, b := rows[i], rows[j]
+		return a.Route+" "+a.Method+" "+a.Client < b.Route+" "+b.Method+" "+b.Client
+	})
+	return rows
+}
+
+// GetAnalytics godoc
+// @Summary API usage analytics
+// @Description Request counts, error rates and payload sizes per route and client. Usage is kept in
+// @Description ANALYTICS_RESOLUTION buckets (default 1h) for ANALYTICS_RETENTION; a bucket is included
+// @Description when it starts inside [from, to). Clients are identified by mTLS certificate, X-Client-ID or remote IP.
+// @Tags Admin
+// @Produce json
+// @Param from query string false "Start (RFC 3339, default 24h before to)"
+// @Param to query string false "End (RFC 3339, default now)"
+// @Param route query string false "Only this route, e.g. /categories/{id}"
+// @Param client query string false "Only this client"
+// @Param group_by query string false "Comma-separated dimensions (default route,client)" Enums(route, client, "route,client")
+// @Success 200 {object} UsageReport
+// @Failure 400 {string} string
+// @Router /admin/analytics [get]
+func GetAnalytics(w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+	to := time.Now().UTC()
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return &statusError{CodeValidationFailed, "to must be an RFC 3339 time"}
+		}
+		to = t.UTC()
+	}
+	from := to.Add(-24 * time.Hour)
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return &statusError{CodeValidationFailed, "from must be an RFC 3339 time"}
+		}
+		from = t.UTC()
+	}
+	if !from.Before(to) {
+		return &statusError{CodeValidationFailed, "from must be before to"}
+	}
+
+	groupBy := map[string]bool{"route": true, "client": true}
+	if v := q.Get("group_by"); v != "" {
+		groupBy = map[string]bool{}
+		for _, dim := range splitList(v) {
+			if dim != "route" && dim != "client" {
+				return &statusError{CodeValidationFailed, "group_by must list route and/or client"}
+			}
+			groupBy[dim] = true
+		}
+	}
+
+	report := UsageReport{
+		From:       from,
+		To:         to,
+		Resolution: analyticsResolution.String(),
+		Rows:       queryUsage(from, to, q.Get("route"), q.Get("client"), groupBy),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, report)
+	return nil
+}
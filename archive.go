@@ -0,0 +1,386 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// =======================
+// MODEL
+// =======================
+
+// archiveFormat and archiveVersion identify a dataset archive; the version
+// goes up when a file changes incompatibly.
+const (
+	archiveFormat  = "simple-crud-archive"
+	archiveVersion = 1
+)
+
+// maxArchiveSize bounds the body of POST /admin/archive.
+const maxArchiveSize = 64 << 20
+
+// ArchiveManifest is manifest.json, the first file of an archive. Files
+// lists every other file with its record count and SHA-256.
+type ArchiveManifest struct {
+	Format    string                 `json:"format"`
+	Version   int                    `json:"version"`
+	CreatedAt time.Time              `json:"created_at"`
+	Files     map[string]ArchiveFile `json:"files"`
+}
+
+type ArchiveFile struct {
+	Records int    `json:"records"`
+	SHA256  string `json:"sha256"`
+}
+
+// ArchiveRelation links a product to a category (relations.json).
+type ArchiveRelation struct {
+	ProductID  int `json:"product_id"`
+	CategoryID int `json:"category_id"`
+}
+
+// ArchiveSummary is the answer of POST /admin/archive: what was restored.
+type ArchiveSummary struct {
+	Categories   int `json:"categories"`
+	Items        int `json:"items"`
+	Products     int `json:"products"`
+	Relations    int `json:"relations"`
+	CustomFields int `json:"custom_fields"`
+}
+
+// archiveData is the dataset held by an archive. Products are stored
+// without category_ids; the links live in relations.json.
+type archiveData struct {
+	Categories   []Category
+	Items        []Item
+	Products     []Product
+	Relations    []ArchiveRelation
+	CustomFields []CustomField
+}
+
+// =======================
+// ARCHIVE
+// =======================
+
+// collectArchive copies the whole store, soft-deleted records included,
+// ordered by ID. Callers must hold storeMu.
+func collectArchive() archiveData {
+	d := archiveData{Categories: []Category{}, Items: []Item{}, Products: []Product{}, Relations: []ArchiveRelation{}, CustomFields: []CustomField{}}
+	for _, c := range categories {
+		d.Categories = append(d.Categories, *c)
+	}
+	sort.Slice(d.Categories, func(i, j int) bool { return d.Categories[i].ID < d.Categories[j].ID })
+	for _, it := range items {
+		d.Items = append(d.Items, *it)
+	}
+	sort.Slice(d.Items, func(i, j int) bool { return d.Items[i].ID < d.Items[j].ID })
+	for _, p := range products {
+		copied := *p
+		copied.CategoryIDs = nil
+		d.Products = append(d.Products, copied)
+		for _, cid := range p.CategoryIDs {
+			d.Relations = append(d.Relations, ArchiveRelation{ProductID: p.ID, CategoryID: cid})
+		}
+	}
+	sort.Slice(d.Products, func(i, j int) bool { return d.Products[i].ID < d.Products[j].ID })
+	sort.Slice(d.Relations, func(i, j int) bool {
+		a, b := d.Relations[i], d.Relations[j]
+		return a.ProductID < b.ProductID || (a.ProductID == b.ProductID && a.CategoryID < b.CategoryID)
+	})
+	for _, f := range customFields {
+		d.CustomFields = append(d.CustomFields, f)
+	}
+	sort.Slice(d.CustomFields, func(i, j int) bool { return d.CustomFields[i].Name < d.CustomFields[j].Name })
+	return d
+}
+
+// files maps each data file of the archive to a pointer to its records.
+func (d *archiveData) files() map[string]interface{} {
+	return map[string]interface{}{
+		"categories.json":    &d.Categories,
+		"items.json":         &d.Items,
+		"products.json":      &d.Products,
+		"relations.json":     &d.Relations,
+		"custom_fields.json": &d.CustomFields,
+	}
+}
+
+func writeArchive(d archiveData, now time.Time) ([]byte, error) {
+	manifest := ArchiveManifest{Format: archiveFormat, Version: archiveVersion, CreatedAt: now, Files: map[string]ArchiveFile{}}
+	contents := map[string][]byte{}
+	names := []string{}
+	for name, records := range d.files() {
+		data, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		count := reflect.ValueOf(records).Elem().Len()
+		manifest.Files[name] = ArchiveFile{Records: count, SHA256: hex.EncodeToString(sum[:])}
+		contents[name] = data
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	header, _ := json.MarshalIndent(manifest, "", "  ")
+	for _, name := range append([]string{"manifest.json"}, names...) {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return nil, err
+		}
+		data := contents[name]
+		if name == "manifest.json" {
+			data = header
+		}
+		if _, err := f.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// readArchive unpacks an archive, checking the manifest and checksums.
+func readArchive(data []byte) (archiveData, error) {
+	var d archiveData
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return d, fmt.Errorf("not a zip archive: %v", err)
+	}
+	contents := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return d, fmt.Errorf("%s: %v", f.Name, err)
+		}
+		contents[f.Name], err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return d, fmt.Errorf("%s: %v", f.Name, err)
+		}
+	}
+
+	var manifest ArchiveManifest
+	if err := json.Unmarshal(contents["manifest.json"], &manifest); err != nil {
+		return d, fmt.Errorf("manifest.json: missing or invalid")
+	}
+	if manifest.Format != archiveFormat {
+		return d, fmt.Errorf("manifest.json: format is %q, want %q", manifest.Format, archiveFormat)
+	}
+	if manifest.Version != archiveVersion {
+		return d, fmt.Errorf("manifest.json: archive version %d is not supported (want %d)", manifest.Version, archiveVersion)
+	}
+	for name, records := range d.files() {
+		file, ok := manifest.Files[name]
+		raw, present := contents[name]
+		if !ok || !present {
+			return d, fmt.Errorf("%s: missing", name)
+		}
+		sum := sha256.Sum256(raw)
+		if hex.EncodeToString(sum[:]) != file.SHA256 {
+			return d, fmt.Errorf("%s: checksum does not match the manifest", name)
+		}
+		if err := json.Unmarshal(raw, records); err != nil {
+			return d, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return d, nil
+}
+
+// checkArchive verifies IDs and references, so a restored dataset has no
+// dangling links. Records are otherwise restored as they were exported.
+func checkArchive(d archiveData) error {
+	cats := map[int]bool{}
+	for _, c := range d.Categories {
+		if c.ID <= 0 || cats[c.ID] {
+			return fmt.Errorf("categories.json: invalid or duplicate id %d", c.ID)
+		}
+		if c.Status != StatusActive && c.Status != StatusArchived {
+			return fmt.Errorf("categories.json: category %d has invalid status %q", c.ID, c.Status)
+		}
+		cats[c.ID] = true
+	}
+	seen := map[int]bool{}
+	for _, it := range d.Items {
+		if it.ID <= 0 || seen[it.ID] {
+			return fmt.Errorf("items.json: invalid or duplicate id %d", it.ID)
+		}
+		if !cats[it.CategoryID] {
+			return fmt.Errorf("items.json: item %d references missing category %d", it.ID, it.CategoryID)
+		}
+		seen[it.ID] = true
+	}
+	prods := map[int]bool{}
+	for _, p := range d.Products {
+		if p.ID <= 0 || prods[p.ID] {
+			return fmt.Errorf("products.json: invalid or duplicate id %d", p.ID)
+		}
+		prods[p.ID] = true
+	}
+	pairs := map[ArchiveRelation]bool{}
+	for _, rel := range d.Relations {
+		if !prods[rel.ProductID] || !cats[rel.CategoryID] {
+			return fmt.Errorf("relations.json: product %d / category %d references a missing record", rel.ProductID, rel.CategoryID)
+		}
+		if pairs[rel] {
+			return fmt.Errorf("relations.json: product %d / category %d is listed twice", rel.ProductID, rel.CategoryID)
+		}
+		pairs[rel] = true
+	}
+	for _, f := range d.CustomFields {
+		if !attributeKeyPattern.MatchString(f.Name) {
+			return fmt.Errorf("custom_fields.json: invalid field name %q", f.Name)
+		}
+	}
+	return nil
+}
+
+// restoreArchive replaces the whole store with the archive. The maps are
+// emptied in place because resources hold on to them. Every category that
+// goes away gets a deleted event and every restored one a created event,
+// so subscribers such as the search index follow. Callers must hold
+// storeMu for writing.
+func restoreArchive(d archiveData) ArchiveSummary {
+	for _, c := range sortedByID(categories) {
+		recordCategoryChange(ChangeDeleted, c)
+	}
+	for id := range categories {
+		delete(categories, id)
+	}
+	for id := range items {
+		delete(items, id)
+	}
+	for id := range products {
+		delete(products, id)
+	}
+	for name := range customFields {
+		delete(customFields, name)
+	}
+	autoID, itemAutoID, productAutoID = 1, 1, 1
+
+	for i := range d.Categories {
+		c := d.Categories[i]
+		categories[c.ID] = &c
+		if c.ID >= autoID {
+			autoID = c.ID + 1
+		}
+		recordCategoryChange(ChangeCreated, &c)
+	}
+	for i := range d.Items {
+		it := d.Items[i]
+		items[it.ID] = &it
+		if it.ID >= itemAutoID {
+			itemAutoID = it.ID + 1
+		}
+	}
+	for i := range d.Products {
+		p := d.Products[i]
+		p.CategoryIDs = []int{}
+		products[p.ID] = &p
+		if p.ID >= productAutoID {
+			productAutoID = p.ID + 1
+		}
+	}
+	for _, rel := range d.Relations {
+		p := products[rel.ProductID]
+		p.CategoryIDs = append(p.CategoryIDs, rel.CategoryID)
+	}
+	for _, f := range d.CustomFields {
+		customFields[f.Name] = f
+	}
+
+	summary := ArchiveSummary{
+		Categories:   len(d.Categories),
+		Items:        len(d.Items),
+		Products:     len(d.Products),
+		Relations:    len(d.Relations),
+		CustomFields: len(d.CustomFields),
+	}
+	recordAudit("archive_restore", 0, map[string]interface{}{
+		"categories": summary.Categories, "items": summary.Items, "products": summary.Products,
+	})
+	return summary
+}
+
+// sortedByID lists every category, soft-deleted ones included, by ID.
+func sortedByID(m map[int]*Category) []*Category {
+	result := make([]*Category, 0, len(m))
+	for _, c := range m {
+		result = append(result, c)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// =======================
+// HANDLER
+// =======================
+
+// ExportArchive godoc
+// @Summary Export the full dataset
+// @Description A zip with manifest.json plus categories, items, products, their relations and the custom field
+// @Description definitions, one JSON file each, IDs and soft-deleted records included. POST it to /admin/archive to
+// @Description restore it elsewhere.
+// @Tags Admin
+// @Produce application/zip
+// @Param Content-Encoding header string false "gzip to send the body compressed" Enums(gzip)
+// @Success 200 {file} file
+// @Router /admin/archive [get]
+func ExportArchive(w http.ResponseWriter, r *http.Request) error {
+	now := time.Now().UTC()
+	data, err := writeArchive(collectArchive(), now)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="simple-crud-%s.zip"`, now.Format("20060102-1504")))
+	w.Write(data)
+	return nil
+}
+
+// ImportArchive godoc
+// @Summary Restore the full dataset
+// @Description Replaces every category, item, product, relation and custom field with the contents of an archive from
+// @Description GET /admin/archive, keeping IDs, so links survive the move between environments. The archive is checked
+// @Description first (manifest, checksums, references); if anything is wrong nothing is changed.
+// @Description Supports dry_run and Prefer: respond-async.
+// @Tags Admin
+// @Accept application/zip
+// @Produce json
+// @Param dry_run query bool false "Validate and return the would-be response without saving anything"
+// @Param Prefer header string false "respond-async answers 202 at once; poll the Location (GET /operations/{id})"
+// @Success 200 {object} ArchiveSummary
+// @Success 202 {object} Operation
+// @Failure 400 {string} string
+// @Router /admin/archive [post]
+func ImportArchive(w http.ResponseWriter, r *http.Request) error {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxArchiveSize))
+	if err != nil {
+		return &statusError{CodeValidationFailed, fmt.Sprintf("archive could not be read (limit %d MB): %v", maxArchiveSize>>20, err)}
+	}
+	d, err := readArchive(body)
+	if err == nil {
+		err = checkArchive(d)
+	}
+	if err != nil {
+		return &statusError{CodeValidationFailed, "invalid archive: " + err.Error()}
+	}
+
+	summary := restoreArchive(d)
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, summary)
+	return nil
+}
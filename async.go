@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// =======================
+// MODEL
+// =======================
+
+// Operation statuses. An operation has failed when it answered with 4xx/5xx.
+const (
+	OperationPending   = "pending"
+	OperationRunning   = "running"
+	OperationSucceeded = "succeeded"
+	OperationFailed    = "failed"
+)
+
+// Operation is a request accepted with "Prefer: respond-async", polled at
+// GET /operations/{id}. Response is set once it has run and holds what the
+// endpoint would have answered synchronously.
+type Operation struct {
+	ID       int                `json:"id"`
+	Status   string             `json:"status" enums:"pending,running,succeeded,failed"`
+	Method   string             `json:"method"`
+	Path     string             `json:"path"`
+	Response *OperationResponse `json:"response,omitempty"`
+	// ReportURL links the rows an import rejected, once it has run.
+	ReportURL string    `json:"report_url,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// OperationResponse is the outcome of an operation. Body is the JSON the
+// endpoint returned, or the error message as a JSON string.
+type OperationResponse struct {
+	Status    int             `json:"status"`
+	ErrorCode ErrorCode       `json:"error_code,omitempty"`
+	Body      json.RawMessage `json:"body,omitempty" swaggertype:"object"`
+}
+
+// operationRequest is the job payload: the request to replay.
+type operationRequest struct {
+	Method string      `json:"method"`
+	Path   string      `json:"path"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body,omitempty"`
+	// Principal is who sent the request, for category ACLs. Header has no
+	// credentials, so the replay takes its identity from here.
+	Principal *Principal `json:"principal,omitempty"`
+}
+
+// =======================
+// ASYNC REQUESTS
+// =======================
+
+var (
+	// asyncRoutes are the routes that may run in the background, keyed like
+	// deprecatedRoutes ("METHOD route" as produced by routeLabel).
+	asyncRoutes = map[string]bool{
+		"POST /categories/import":     true,
+		"PATCH /categories/bulk":      true,
+		"POST /categories/{id}/merge": true,
+		"POST /admin/purge":           true,
+		"POST /admin/archive":         true,
+	}
+
+	// operationResults holds the responses of finished operations by job
+	// id, guarded by jobsMu. They are kept in memory only, so after a
+	// restart a finished operation reports its status without a response.
+	operationResults = map[int]*OperationResponse{}
+)
+
+func init() {
+	registerJobHandler("operation", runOperation)
+}
+
+// respondAsync answers requests to asyncRoutes that carry "Prefer:
+// respond-async" with 202 and a Location to poll, and replays them on the
+// job queue. It runs inside negotiateEncoding, so a body sent as MessagePack
+// or CBOR is queued as the JSON the handlers read.
+func respondAsync(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !preferAsync(r) || !asyncRoutes[r.Method+" "+routeLabel(r.URL.Path)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeAPIError(w, CodeInvalidJSON, "reading body: "+err.Error())
+			return
+		}
+		// The payload is persisted and listed on /admin/jobs, so credentials
+		// stay out of it; the replay acts as Principal instead.
+		header := sanitizeHeader(r.Header)
+		header.Del("Prefer")
+		job, err := enqueueJob("operation", operationRequest{Method: r.Method, Path: r.URL.RequestURI(), Header: header, Body: body, Principal: requestPrincipal(r)})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("/operations/%d", job.ID))
+		w.Header().Set("Preference-Applied", "respond-async")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		encodeJSON(w, operationFromJob(job, nil))
+	})
+}
+
+// preferAsync reports whether a Prefer header asks for respond-async.
+func preferAsync(r *http.Request) bool {
+	for _, v := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(pref), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// runOperation replays the request against the routes, outside the request
+// timeout, and keeps the response. An error response is a result, not a
+// reason to retry.
+func runOperation(job *Job) error {
+	var op operationRequest
+	if err := json.Unmarshal(job.Payload, &op); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(op.Method, op.Path, bytes.NewReader(op.Body))
+	if err != nil {
+		return err
+	}
+	req.Header = op.Header
+	req = withPrincipal(req, op.Principal)
+
+	rec := &bufferedResponse{header: http.Header{}}
+	recoverPanics(rejectWrites(http.DefaultServeMux)).ServeHTTP(rec, req)
+
+	result := &OperationResponse{Status: rec.statusOrOK(), ErrorCode: ErrorCode(rec.header.Get("X-Error-Code"))}
+	switch body := rec.body.Bytes(); {
+	case len(body) == 0:
+	case json.Valid(body):
+		result.Body = body
+	default:
+		result.Body, _ = json.Marshal(strings.TrimSpace(string(body)))
+	}
+	if route, _, _ := strings.Cut(op.Path, "?"); op.Method == http.MethodPost && routeLabel(route) == "/categories/import" && result.Status == http.StatusOK {
+		if err := saveImportReport(job.ID, result.Body); err != nil {
+			log.Printf("operation %d: saving import report: %v", job.ID, err)
+		}
+	}
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for id := range operationResults {
+		if _, ok := jobList[id]; !ok {
+			delete(operationResults, id)
+		}
+	}
+	operationResults[job.ID] = result
+	return nil
+}
+
+// operationFromJob describes an operation job. job is a copy or jobsMu is
+// held.
+func operationFromJob(job *Job, result *OperationResponse) Operation {
+	var op operationRequest
+	json.Unmarshal(job.Payload, &op)
+	o := Operation{
+		ID:        job.ID,
+		Method:    op.Method,
+		Path:      op.Path,
+		Response:  result,
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+	}
+	if job.Status == JobSucceeded && hasImportReport(job.ID) {
+		o.ReportURL = fmt.Sprintf("/operations/%d/report", job.ID)
+	}
+	switch {
+	case job.Status == JobQueued:
+		o.Status = OperationPending
+	case job.Status == JobRunning:
+		o.Status = OperationRunning
+	case job.Status == JobDead || (result != nil && result.Status >= 400):
+		o.Status = OperationFailed
+	default:
+		o.Status = OperationSucceeded
+	}
+	return o
+}
+
+// =======================
+// HANDLER
+// =======================
+
+// GetOperation godoc
+// @Summary Get an async operation
+// @Description Polls a request accepted with "Prefer: respond-async" (import, merge, purge, archive restore). Once it has run, response holds
+// @Description the status and body the endpoint would have answered; status is failed for 4xx/5xx answers. An import
+// @Description also links the rows it rejected in report_url (GET /operations/{id}/report).
+// @Tags Admin
+// @Produce json
+// @Param id path int true "Operation ID"
+// @Success 200 {object} Operation
+// @Failure 404 {string} string
+// @Router /operations/{id} [get]
+func GetOperation(w http.ResponseWriter, r *http.Request) error {
+	jobsMu.Lock()
+	job, ok := jobList[parseIDAt(r.URL.Path, 1)]
+	var op Operation
+	if ok && job.Type == "operation" {
+		op = operationFromJob(job, operationResults[job.ID])
+	}
+	jobsMu.Unlock()
+	if !ok || job.Type != "operation" {
+		return &statusError{CodeJobNotFound, "operation not found"}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, op)
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// =======================
+// ATTRIBUTES
+// =======================
+
+// Category.Attributes is free-form JSON for data the model has no field
+// for. Writes replace the whole map and never modify it in place, so copies
+// of a category may share it.
+
+var (
+	// attributesMaxKeys caps the top-level keys (ATTRIBUTES_MAX_KEYS).
+	attributesMaxKeys = 32
+	// attributesMaxBytes caps the encoded size of the object
+	// (ATTRIBUTES_MAX_BYTES).
+	attributesMaxBytes = 4096
+)
+
+// attributeKeyPattern keeps keys usable as attr.<key> query parameters.
+var attributeKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+const maxAttributeKeyLength = 64
+
+// attrFilterPrefix marks attribute filters in a query, as in ?attr.color=red.
+const attrFilterPrefix = "attr."
+
+// validateAttributes checks keys, limits and custom field definitions. An
+// empty object is stored as nil.
+func validateAttributes(attrs map[string]interface{}) (map[string]interface{}, error) {
+	if len(attrs) == 0 {
+		return nil, checkCustomFields(nil)
+	}
+	if len(attrs) > attributesMaxKeys {
+		return nil, fmt.Errorf("attributes may have at most %d keys", attributesMaxKeys)
+	}
+	for key := range attrs {
+		if len(key) > maxAttributeKeyLength || !attributeKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid attribute key %q: use lowercase letters, digits and underscores, starting with a letter (max %d chars)", key, maxAttributeKeyLength)
+		}
+	}
+	encoded, err := json.Marshal(attrs)
+	if err != nil {
+		return nil, err
+	}
+	if len(encoded) > attributesMaxBytes {
+		return nil, fmt.Errorf("attributes are %d bytes, the limit is %d", len(encoded), attributesMaxBytes)
+	}
+	return attrs, checkCustomFields(attrs)
+}
+
+// equalAttributes compares two attribute objects.
+func equalAttributes(a, b map[string]interface{}) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// attributeFilters collects the attr.<key> parameters of a query.
+func attributeFilters(q url.Values) map[string]string {
+	var filters map[string]string
+	for name, values := range q {
+		if !strings.HasPrefix(name, attrFilterPrefix) {
+			continue
+		}
+		if filters == nil {
+			filters = map[string]string{}
+		}
+		filters[strings.TrimPrefix(name, attrFilterPrefix)] = values[0]
+	}
+	return filters
+}
+
+// matchAttributes reports whether every filter equals the attribute's value.
+// Only strings, numbers and booleans can match; objects and arrays never do.
+func matchAttributes(c *Category, filters map[string]string) bool {
+	for key, want := range filters {
+		var got string
+		switch v := c.Attributes[key].(type) {
+		case string:
+			got = v
+		case float64:
+			got = strconv.FormatFloat(v, 'f', -1, 64)
+		case json.Number:
+			got = v.String()
+		case bool:
+			got = strconv.FormatBool(v)
+		default:
+			return false
+		}
+		if got != want {
+			return false
+		}
+	}
+	return true
+}
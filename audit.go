@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// =======================
+// MODEL
+// =======================
+
+type AuditEntry struct {
+	ID         int                    `json:"id"`
+	Action     string                 `json:"action"`
+	CategoryID int                    `json:"category_id"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
+
+// =======================
+// STORAGE (fake DB)
+// =======================
+
+var (
+	auditLog    = []*AuditEntry{}
+	auditAutoID = 1
+)
+
+func recordAudit(action string, categoryID int, details map[string]interface{}) {
+	auditLog = append(auditLog, &AuditEntry{
+		ID:         auditAutoID,
+		Action:     action,
+		CategoryID: categoryID,
+		Details:    details,
+		CreatedAt:  time.Now().UTC(),
+	})
+	auditAutoID++
+}
+
+// =======================
+// HANDLER
+// =======================
+
+// GetAuditLog godoc
+// @Summary Get audit log
+// @Description Entries about a category the caller's ACLs hide are left out. Oldest first and paged:
+// @Description X-Total-Count has the number of entries and Link the next and prev pages.
+// @Tags Audit
+// @Produce json
+// @Param category_id query int false "Only entries for this category"
+// @Param limit query int false "Page size (default PAGE_SIZE_DEFAULT, 20; at most PAGE_SIZE_MAX, 200)"
+// @Param offset query int false "Entries to skip"
+// @Success 200 {array} AuditEntry
+// @Failure 400 {string} string
+// @Router /audit [get]
+func GetAuditLog(w http.ResponseWriter, r *http.Request) error {
+	categoryID, _ := strconv.Atoi(r.URL.Query().Get("category_id"))
+	page, err := parsePage(r.URL.Query(), defaultPageSize, maxPageSize)
+	if err != nil {
+		return err
+	}
+
+	p := requestPrincipal(r)
+	result := []*AuditEntry{}
+	for _, e := range auditLog {
+		if categoryID != 0 && e.CategoryID != categoryID {
+			continue
+		}
+		if e.CategoryID != 0 && !canReadCategoryID(p, e.CategoryID) {
+			continue
+		}
+		result = append(result, e)
+	}
+	setPageHeaders(w, r, page, len(result))
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, paginate(result, page))
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// =======================
+// CIRCUIT BREAKERS
+// =======================
+
+// The store itself is in memory and can't fail, but the backends around it
+// can: the search cluster, the export bucket and Redis. Each call to one
+// goes through its breaker, which opens after breakerThreshold failures in a
+// row. While open, calls fail at once with errCircuitOpen instead of each
+// waiting out its own timeout; after breakerCooldown one trial call is let
+// through, and its outcome closes or reopens the breaker.
+
+var (
+	// breakerThreshold is how many failures in a row open a breaker
+	// (BREAKER_THRESHOLD); 0 disables breakers.
+	breakerThreshold = 5
+	// breakerCooldown is how long a breaker stays open before a trial call
+	// (BREAKER_COOLDOWN).
+	breakerCooldown = 30 * time.Second
+
+	searchBreaker = newBreaker("search")
+	s3Breaker     = newBreaker("s3")
+	redisBreaker  = newBreaker("redis")
+)
+
+func init() {
+	registerMetric("circuit_breaker_open", "gauge", "1 while the breaker of a backend is open, by backend.")
+	registerMetric("circuit_breaker_rejected_total", "counter", "Calls failed fast by an open breaker, by backend.")
+}
+
+// configureBreakers reads BREAKER_THRESHOLD and BREAKER_COOLDOWN.
+func configureBreakers() {
+	breakerThreshold = envInt("BREAKER_THRESHOLD", breakerThreshold)
+	breakerCooldown = envDuration("BREAKER_COOLDOWN", breakerCooldown)
+}
+
+// errCircuitOpen is wrapped by the errors of calls an open breaker refused.
+var errCircuitOpen = errors.New("circuit open")
+
+// circuitOpenError says which backend is cut off and for how long.
+type circuitOpenError struct {
+	backend    string
+	retryAfter time.Duration
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("%s: %v after repeated failures, retry in %s", e.backend, errCircuitOpen, e.retryAfter.Round(time.Second))
+}
+
+func (e *circuitOpenError) Unwrap() error { return errCircuitOpen }
+
+type circuitBreaker struct {
+	name string
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool // a call after the cooldown is deciding the state
+}
+
+func newBreaker(name string) *circuitBreaker {
+	return &circuitBreaker{name: name}
+}
+
+// call runs fn unless the breaker is open, and counts its outcome.
+func (b *circuitBreaker) call(fn func() error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := fn()
+	b.record(err == nil)
+	return err
+}
+
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	if wait := time.Until(b.openUntil); wait > 0 || b.trial {
+		addMetric("circuit_breaker_rejected_total", 1, "backend", b.name)
+		return &circuitOpenError{backend: b.name, retryAfter: max(wait, time.Second)}
+	}
+	b.trial = true
+	return nil
+}
+
+func (b *circuitBreaker) record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := !b.openUntil.IsZero()
+	b.trial = false
+	if ok {
+		b.failures = 0
+		if wasOpen {
+			b.openUntil = time.Time{}
+			setMetric("circuit_breaker_open", 0, "backend", b.name)
+			log.Printf("breaker: %s recovered, closing", b.name)
+		}
+		return
+	}
+	b.failures++
+	if breakerThreshold > 0 && (wasOpen || b.failures >= breakerThreshold) {
+		b.openUntil = time.Now().Add(breakerCooldown)
+		setMetric("circuit_breaker_open", 1, "backend", b.name)
+		if !wasOpen {
+			log.Printf("breaker: %s failed %d times in a row, failing fast for %s", b.name, b.failures, breakerCooldown)
+		}
+	}
+}
+
+// writeRetryAfter sets Retry-After when err came from an open breaker.
+func writeRetryAfter(w http.ResponseWriter, err error) {
+	var open *circuitOpenError
+	if errors.As(err, &open) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(open.retryAfter.Seconds()))))
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// =======================
+// MODEL
+// =======================
+
+// PatchOperation is one RFC 6902 JSON Patch operation.
+type PatchOperation struct {
+	Op    string      `json:"op" enums:"add,remove,replace,move,copy,test"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty" swaggertype:"object"`
+}
+
+// BulkPatch is one entry of PATCH /categories/bulk: the operations to apply
+// to one category's JSON.
+type BulkPatch struct {
+	ID    int              `json:"id"`
+	Patch []PatchOperation `json:"patch"`
+}
+
+// maxBulkPatches caps the entries of one bulk request.
+const maxBulkPatches = 1000
+
+// patchableFields are the top-level category fields a patch may change;
+// the rest are kept by the server, though test may read them.
+var patchableFields = map[string]bool{"name": true, "description": true, "tags": true, "attributes": true, "acl": true}
+
+// =======================
+// JSON PATCH
+// =======================
+
+// errPatchTest is returned when a test operation doesn't hold.
+var errPatchTest = errors.New("test failed")
+
+// applyPatch applies ops in order to doc, a value decoded from JSON, and
+// returns the result. doc may be changed even when an operation fails.
+func applyPatch(doc interface{}, ops []PatchOperation) (interface{}, error) {
+	for i, op := range ops {
+		var err error
+		switch op.Op {
+		case "add":
+			doc, err = patchAdd(doc, op.Path, op.Value)
+		case "remove":
+			doc, _, err = patchRemove(doc, op.Path)
+		case "replace":
+			if doc, _, err = patchRemove(doc, op.Path); err == nil {
+				doc, err = patchAdd(doc, op.Path, op.Value)
+			}
+		case "move":
+			var v interface{}
+			if strings.HasPrefix(op.Path, op.From+"/") {
+				err = errors.New("from must not be a parent of path")
+			} else if doc, v, err = patchRemove(doc, op.From); err == nil {
+				doc, err = patchAdd(doc, op.Path, v)
+			}
+		case "copy":
+			var v interface{}
+			if v, err = patchGet(doc, op.From); err == nil {
+				doc, err = patchAdd(doc, op.Path, v)
+			}
+		case "test":
+			var v interface{}
+			if v, err = patchGet(doc, op.Path); err == nil && !reflect.DeepEqual(v, normalizeJSON(op.Value)) {
+				err = errPatchTest
+			}
+		default:
+			err = fmt.Errorf("unknown op %q", op.Op)
+		}
+		if err != nil {
+			return doc, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+// splitPointer decodes an RFC 6901 JSON Pointer into its reference tokens.
+func splitPointer(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("path %q must start with /", path)
+	}
+	tokens := strings.Split(path[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses an array index token; "-" is one past the end, which
+// only add allows.
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+	n, err := strconv.Atoi(token)
+	if err != nil || n < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if n > length || (n == length && !allowEnd) {
+		return 0, fmt.Errorf("array index %d out of range", n)
+	}
+	return n, nil
+}
+
+func patchGet(doc interface{}, path string) (interface{}, error) {
+	tokens, err := splitPointer(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tokens {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			v, ok := node[t]
+			if !ok {
+				return nil, fmt.Errorf("%s does not exist", path)
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(t, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("%s does not exist", path)
+		}
+	}
+	return doc, nil
+}
+
+// patchAdd sets path to value, inserting into arrays. value is copied, so
+// a copied subtree doesn't alias its source. Arrays are values, so each
+// parent is written back up the path.
+func patchAdd(doc interface{}, path string, value interface{}) (interface{}, error) {
+	tokens, err := splitPointer(path)
+	if err != nil {
+		return nil, err
+	}
+	return setAt(doc, tokens, path, normalizeJSON(value), true)
+}
+
+// patchRemove deletes path and returns the value it held.
+func patchRemove(doc interface{}, path string) (interface{}, interface{}, error) {
+	tokens, err := splitPointer(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, doc, nil
+	}
+	old, err := patchGet(doc, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, err = setAt(doc, tokens, path, nil, false)
+	return doc, old, err
+}
+
+// setAt adds value at tokens when add is set, else removes what is there.
+func setAt(doc interface{}, tokens []string, path string, value interface{}, add bool) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	t, rest := tokens[0], tokens[1:]
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		if len(rest) == 0 {
+			if add {
+				node[t] = value
+			} else {
+				delete(node, t)
+			}
+			return node, nil
+		}
+		child, ok := node[t]
+		if !ok {
+			return nil, fmt.Errorf("%s does not exist", path)
+		}
+		child, err := setAt(child, rest, path, value, add)
+		if err != nil {
+			return nil, err
+		}
+		node[t] = child
+		return node, nil
+	case []interface{}:
+		i, err := arrayIndex(t, len(node), add && len(rest) == 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) == 0 {
+			if add {
+				node = append(node[:i], append([]interface{}{value}, node[i:]...)...)
+			} else {
+				node = append(node[:i], node[i+1:]...)
+			}
+			return node, nil
+		}
+		child, err := setAt(node[i], rest, path, value, add)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = child
+		return node, nil
+	}
+	return nil, fmt.Errorf("%s does not exist", path)
+}
+
+// normalizeJSON round-trips v through JSON, so values compare and nest like
+// decoded ones (float64 numbers, maps of interface{}).
+func normalizeJSON(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out interface{}
+	json.Unmarshal(b, &out)
+	return out
+}
+
+// patchTouchesReadOnly names the first field an operation would change
+// that clients don't own, or "".
+func patchTouchesReadOnly(ops []PatchOperation) string {
+	for _, op := range ops {
+		if op.Op == "test" {
+			continue
+		}
+		paths := []string{op.Path}
+		if op.Op == "move" {
+			paths = append(paths, op.From)
+		}
+		for _, p := range paths {
+			tokens, err := splitPointer(p)
+			if err != nil || len(tokens) == 0 || !patchableFields[tokens[0]] {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// =======================
+// HANDLER
+// =======================
+
+// PatchCategories godoc
+// @Summary Bulk update categories
+// @Description Applies an RFC 6902 JSON Patch to each listed category, e.g. to rename a prefix across many of them.
+// @Description The patch works on the category's JSON; only name, description, tags, attributes and acl may change,
+// @Description but test may check any field, such as /version for optimistic locking. Every patched category is
+// @Description validated like PUT /categories/{id}. All entries apply or none do: the first failing entry is
+// @Description reported with its index. At most 1000 entries per request.
+// @Tags Category
+// @Accept json
+// @Produce json
+// @Param body body []BulkPatch true "Patches by category ID"
+// @Param dry_run query bool false "Validate and return the would-be response without saving anything"
+// @Param Prefer header string false "respond-async answers 202 at once; poll the Location (GET /operations/{id})"
+// @Param Content-Encoding header string false "gzip to send the body compressed" Enums(gzip)
+// @Success 200 {array} Category
+// @Success 202 {object} Operation
+// @Failure 400 {string} string
+// @Failure 403 {string} string
+// @Failure 404 {string} string
+// @Failure 409 {string} string
+// @Router /categories/bulk [patch]
+func PatchCategories(w http.ResponseWriter, r *http.Request) error {
+	var input []BulkPatch
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return &statusError{CodeInvalidJSON, err.Error()}
+	}
+	if len(input) == 0 {
+		return &statusError{CodeValidationFailed, "at least one entry is required"}
+	}
+	if len(input) > maxBulkPatches {
+		return &statusError{CodeValidationFailed, fmt.Sprintf("at most %d entries per request", maxBulkPatches)}
+	}
+
+	p := requestPrincipal(r)
+	updated := []*Category{}
+	err := withTx(func() error {
+		seen := map[int]bool{}
+		for i, entry := range input {
+			if seen[entry.ID] {
+				return &statusError{CodeValidationFailed, fmt.Sprintf("entry %d: category %d is listed twice", i, entry.ID)}
+			}
+			seen[entry.ID] = true
+			c, err := patchCategory(p, entry)
+			if err != nil {
+				if se, ok := err.(*statusError); ok {
+					return &statusError{se.code, fmt.Sprintf("entry %d (category %d): %s", i, entry.ID, se.msg)}
+				}
+				return err
+			}
+			updated = append(updated, c)
+		}
+		ids := make([]int, len(updated))
+		for i, c := range updated {
+			ids[i] = c.ID
+		}
+		recordAudit("bulk_update", 0, map[string]interface{}{"ids": ids})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, updated)
+	return nil
+}
+
+// patchCategory applies one entry in place, running the update hooks.
+// Callers hold storeMu for writing inside a transaction.
+func patchCategory(p *Principal, entry BulkPatch) (*Category, error) {
+	category, ok := findCategory(entry.ID)
+	if !ok || !canReadCategory(p, category) {
+		return nil, &statusError{CodeCategoryNotFound, "category not found"}
+	}
+	if !canWriteCategory(p, category) {
+		return nil, &statusError{CodeAccessDenied, "you may read this category but not change it"}
+	}
+	if len(entry.Patch) == 0 {
+		return nil, &statusError{CodeValidationFailed, "patch is empty"}
+	}
+	if path := patchTouchesReadOnly(entry.Patch); path != "" {
+		return nil, &statusError{CodeValidationFailed, fmt.Sprintf("%q is not a field a patch may change", path)}
+	}
+
+	doc, err := applyPatch(normalizeJSON(category), entry.Patch)
+	if errors.Is(err, errPatchTest) {
+		return nil, &statusError{CodePatchTestFailed, err.Error()}
+	} else if err != nil {
+		return nil, &statusError{CodeValidationFailed, err.Error()}
+	}
+	b, _ := json.Marshal(doc)
+	var input Category
+	if err := json.Unmarshal(b, &input); err != nil {
+		return nil, &statusError{CodeValidationFailed, "patched category is invalid: " + err.Error()}
+	}
+	if err := validateCategoryInput(&input); err != nil {
+		return nil, err
+	}
+	if err := checkACLLockout(p, input.ACL); err != nil {
+		return nil, err
+	}
+	input.ID = category.ID
+	if err := CategoryHooks.runBefore(hookUpdate, &input); err != nil {
+		return nil, err
+	}
+
+	category.Name = input.Name
+	category.Description = input.Description
+	category.Tags = input.Tags
+	category.Attributes = input.Attributes
+	category.ACL = input.ACL
+	if category.ACL.open() {
+		category.ACL = nil
+	}
+	touchCategory(category)
+	CategoryHooks.runAfter(hookUpdate, category)
+	return category, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =======================
+// HTTP CACHING
+// =======================
+
+var (
+	// cacheMaxAge is the default max-age for cacheable GETs (CACHE_MAX_AGE).
+	// Zero sends "no-cache": clients may store responses but must revalidate.
+	cacheMaxAge = 0 * time.Second
+	// cacheRouteMaxAge overrides cacheMaxAge per route, from
+	// CACHE_MAX_AGE_<ROUTE>, e.g. CACHE_MAX_AGE_TAGS=5m.
+	cacheRouteMaxAge = map[string]time.Duration{}
+
+	// storeModified is when any category last changed; it is the
+	// Last-Modified of responses built from the whole collection.
+	storeModified = time.Now().UTC()
+)
+
+// Cacheable routes, named for their CACHE_MAX_AGE_<ROUTE> setting.
+const (
+	cacheRouteCategories = "categories"
+	cacheRouteCategory   = "category"
+	cacheRouteTags       = "tags"
+)
+
+func init() {
+	registerRuntimeDuration("cache_max_age", "Max-age of cacheable GETs without their own setting; 0 sends no-cache.",
+		func() time.Duration { return cacheMaxAge },
+		func(d time.Duration) { cacheMaxAge = d })
+	for _, route := range []string{cacheRouteCategories, cacheRouteCategory, cacheRouteTags} {
+		registerRuntimeDuration("cache_max_age_"+route, "Max-age of the "+route+" route, overriding cache_max_age.",
+			func() time.Duration {
+				if d, ok := cacheRouteMaxAge[route]; ok {
+					return d
+				}
+				return cacheMaxAge
+			},
+			func(d time.Duration) { cacheRouteMaxAge[route] = d })
+	}
+}
+
+func configureCache() {
+	cacheMaxAge = envDuration("CACHE_MAX_AGE", cacheMaxAge)
+	for _, route := range []string{cacheRouteCategories, cacheRouteCategory, cacheRouteTags} {
+		name := "CACHE_MAX_AGE_" + strings.ToUpper(route)
+		if os.Getenv(name) != "" {
+			cacheRouteMaxAge[route] = envDuration(name, 0)
+		}
+	}
+}
+
+// checkNotModified sets Cache-Control and Last-Modified for a GET response and
+// reports whether it already answered with 304 because the client's
+// If-Modified-Since is still current.
+func checkNotModified(w http.ResponseWriter, r *http.Request, route string, modified time.Time) bool {
+	maxAge, ok := cacheRouteMaxAge[route]
+	if !ok {
+		maxAge = cacheMaxAge
+	}
+	if maxAge > 0 {
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(maxAge.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	modified = modified.UTC().Truncate(time.Second)
+	w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// =======================
+// PRECONDITIONS
+// =======================
+
+// requirePreconditions makes writes to a category without If-Match or
+// If-Unmodified-Since fail with 428, so a client can't overwrite a change
+// it never saw (REQUIRE_PRECONDITIONS).
+var requirePreconditions = false
+
+// categoryETag is a strong validator for a category; it changes with every
+// write because the version does.
+func categoryETag(c *Category) string {
+	return `"v` + strconv.Itoa(c.Version) + `"`
+}
+
+// checkPreconditions evaluates If-Match, or failing that If-Unmodified-Since,
+// against the current state of a resource. A non-nil error means the request
+// must not proceed.
+func checkPreconditions(r *http.Request, etag string, modified time.Time) error {
+	if match := r.Header.Get("If-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			if candidate = strings.TrimSpace(candidate); candidate == "*" || candidate == etag {
+				return nil
+			}
+		}
+		return &statusError{CodePreconditionFailed, "resource has changed (If-Match)"}
+	}
+
+	// An unparseable date is ignored, as RFC 9110 asks.
+	if since, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil {
+		if modified.UTC().Truncate(time.Second).After(since) {
+			return &statusError{CodePreconditionFailed, "resource has changed (If-Unmodified-Since)"}
+		}
+		return nil
+	}
+
+	if requirePreconditions {
+		return &statusError{CodePreconditionRequired, "If-Match or If-Unmodified-Since is required"}
+	}
+	return nil
+}
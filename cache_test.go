@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckPreconditions(t *testing.T) {
+	modified := time.Date(2026, 1, 2, 3, 4, 5, 600, time.UTC)
+	before := modified.Add(-time.Hour).Format(http.TimeFormat)
+	after := modified.Add(time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		name     string
+		header   map[string]string
+		required bool
+		want     ErrorCode
+	}{
+		{"no preconditions", nil, false, ""},
+		{"no preconditions, required", nil, true, CodePreconditionRequired},
+		{"If-Match current", map[string]string{"If-Match": `"v3"`}, true, ""},
+		{"If-Match in list", map[string]string{"If-Match": `"v2", "v3"`}, false, ""},
+		{"If-Match any", map[string]string{"If-Match": "*"}, false, ""},
+		{"If-Match stale", map[string]string{"If-Match": `"v2"`}, false, CodePreconditionFailed},
+		{"If-Match wins over date", map[string]string{"If-Match": `"v2"`, "If-Unmodified-Since": after}, false, CodePreconditionFailed},
+		{"unmodified since later", map[string]string{"If-Unmodified-Since": after}, true, ""},
+		{"unmodified since same second", map[string]string{"If-Unmodified-Since": modified.Format(http.TimeFormat)}, false, ""},
+		{"modified since", map[string]string{"If-Unmodified-Since": before}, false, CodePreconditionFailed},
+		{"bad date ignored", map[string]string{"If-Unmodified-Since": "yesterday"}, false, ""},
+		{"bad date, required", map[string]string{"If-Unmodified-Since": "yesterday"}, true, CodePreconditionRequired},
+	}
+	saved := requirePreconditions
+	t.Cleanup(func() { requirePreconditions = saved })
+	for _, tt := range tests {
+		requirePreconditions = tt.required
+		r := httptest.NewRequest("PUT", "/categories/1", nil)
+		for k, v := range tt.header {
+			r.Header.Set(k, v)
+		}
+		var got ErrorCode
+		if err := checkPreconditions(r, `"v3"`, modified); err != nil {
+			got = err.(*statusError).code
+		}
+		if got != tt.want {
+			t.Errorf("%s: checkPreconditions = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
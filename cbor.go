@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+)
+
+// =======================
+// CBOR
+// =======================
+
+// CBOR (RFC 8949) is offered on every endpoint, like MessagePack, with the
+// JSON field names as map keys. Output uses the smallest integer and length
+// heads and sorts map keys length-first, so equal values encode to equal
+// bytes. Input may use indefinite lengths; tags are dropped and their
+// content kept (a tag 0 date stays a string).
+
+func init() {
+	wireCodecs["application/cbor"] = wireCodec{
+		Encode: func(route string, v interface{}) ([]byte, error) {
+			var buf bytes.Buffer
+			err := encodeCBOR(&buf, v)
+			return buf.Bytes(), err
+		},
+		Decode: func(route string, data []byte) (interface{}, error) {
+			d := &cborDecoder{data: data}
+			v, err := d.decode(0)
+			if err == nil && d.pos != len(data) {
+				err = errors.New("trailing data")
+			}
+			if err == errCBORBreak {
+				err = errors.New("unexpected break")
+			}
+			return v, err
+		},
+	}
+}
+
+const (
+	cborUint   = 0
+	cborNegint = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+func writeCBORHead(buf *bytes.Buffer, major byte, n uint64) {
+	major <<= 5
+	switch {
+	case n < 24:
+		buf.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(major | 24)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(major | 25)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		buf.WriteByte(major | 26)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(major | 27)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+func encodeCBOR(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xf6)
+	case bool:
+		if v {
+			buf.WriteByte(0xf5)
+		} else {
+			buf.WriteByte(0xf4)
+		}
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			if n >= 0 {
+				writeCBORHead(buf, cborUint, uint64(n))
+			} else {
+				writeCBORHead(buf, cborNegint, uint64(-1-n))
+			}
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xfb)
+		binary.Write(buf, binary.BigEndian, f)
+	case string:
+		writeCBORHead(buf, cborText, uint64(len(v)))
+		buf.WriteString(v)
+	case []interface{}:
+		writeCBORHead(buf, cborArray, uint64(len(v)))
+		for _, item := range v {
+			if err := encodeCBOR(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return keys[i] < keys[j]
+		})
+		writeCBORHead(buf, cborMap, uint64(len(v)))
+		for _, k := range keys {
+			encodeCBOR(buf, k)
+			if err := encodeCBOR(buf, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cbor: unsupported type %T", v)
+	}
+	return nil
+}
+
+// cborDecoder reads CBOR into the same values encoding/json produces with
+// UseNumber. Byte strings become strings; map keys must be text.
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+const maxCBORDepth = 64
+
+var (
+	errCBORShort = errors.New("unexpected end of data")
+	errCBORBreak = errors.New("break")
+)
+
+// indefinite is the additional-info value for indefinite-length items.
+const cborIndefinite = 31
+
+func (d *cborDecoder) take(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errCBORShort
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// head reads an item head, returning the major type, the additional info
+// and the argument it encodes.
+func (d *cborDecoder) head() (major, info byte, arg uint64, err error) {
+	b, err := d.take(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info = b[0]>>5, b[0]&0x1f
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info <= 27:
+		raw, err := d.take(1 << (info - 24))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		for _, c := range raw {
+			arg = arg<<8 | uint64(c)
+		}
+		return major, info, arg, nil
+	case info == cborIndefinite && major >= cborBytes && major <= cborMap, info == cborIndefinite && major == cborSimple:
+		return major, info, 0, nil
+	}
+	return 0, 0, 0, fmt.Errorf("reserved additional info %d", info)
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > maxCBORDepth {
+		return nil, errors.New("nested too deeply")
+	}
+	major, info, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case cborUint:
+		return json.Number(strconv.FormatUint(arg, 10)), nil
+	case cborNegint:
+		n := new(big.Int).SetUint64(arg)
+		return json.Number(n.Neg(n.Add(n, big.NewInt(1))).String()), nil
+	case cborBytes, cborText:
+		if info != cborIndefinite {
+			b, err := d.take(arg)
+			return string(b), err
+		}
+		var s []byte
+		for {
+			chunk, err := d.decode(depth + 1)
+			if err == errCBORBreak {
+				return string(s), nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			text, ok := chunk.(string)
+			if !ok {
+				return nil, errors.New("bad string chunk")
+			}
+			s = append(s, text...)
+		}
+	case cborArray:
+		result := []interface{}{}
+		for i := uint64(0); info == cborIndefinite || i < arg; i++ {
+			if info != cborIndefinite && arg-i > uint64(len(d.data)-d.pos) {
+				return nil, errCBORShort
+			}
+			v, err := d.decode(depth + 1)
+			if err == errCBORBreak && info == cborIndefinite {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, v)
+		}
+		return result, nil
+	case cborMap:
+		result := map[string]interface{}{}
+		for i := uint64(0); info == cborIndefinite || i < arg; i++ {
+			if info != cborIndefinite && arg-i > uint64(len(d.data)-d.pos) {
+				return nil, errCBORShort
+			}
+			k, err := d.decode(depth + 1)
+			if err == errCBORBreak && info == cborIndefinite {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, errors.New("map keys must be strings")
+			}
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = v
+		}
+		return result, nil
+	case cborTag:
+		return d.decode(depth + 1)
+	}
+
+	switch {
+	case info == 20:
+		return false, nil
+	case info == 21:
+		return true, nil
+	case info == 22, info == 23:
+		return nil, nil
+	case info == 25:
+		return cborFloat(float64(halfToFloat32(uint16(arg))))
+	case info == 26:
+		return cborFloat(float64(math.Float32frombits(uint32(arg))))
+	case info == 27:
+		return cborFloat(math.Float64frombits(arg))
+	case info == cborIndefinite:
+		return nil, errCBORBreak
+	}
+	return nil, fmt.Errorf("unsupported simple value %d", arg)
+}
+
+func cborFloat(f float64) (interface{}, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, errors.New("NaN and infinity have no JSON form")
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+// halfToFloat32 widens an IEEE 754 half-precision float.
+func halfToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h) & 0x3ff
+	switch exp {
+	case 0:
+		f := float32(frac) / 1024 * float32(math.Pow(2, -14))
+		if sign != 0 {
+			return -f
+		}
+		return f
+	case 0x1f:
+		return math.Float32frombits(sign | 0xff<<23 | frac<<13)
+	}
+	return math.Float32frombits(sign | (exp+112)<<23 | frac<<13)
+}
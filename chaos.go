@@ -0,0 +1,126 @@
+package main
+
+import (
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =======================
+// FAULT INJECTION
+// =======================
+
+// chaosFault is what is injected into the requests of a route.
+type chaosFault struct {
+	Latency   time.Duration // added before the request is handled
+	ErrorRate float64       // share of requests answered 503 instead, 0 to 1
+}
+
+var (
+	// chaosEnabled turns fault injection on (CHAOS_ENABLED). It is meant for
+	// staging and test environments, so client teams can exercise their
+	// retries and timeouts; never set it in production.
+	chaosEnabled = false
+	// chaosDefault applies to every route without its own entry
+	// (CHAOS_LATENCY, CHAOS_ERROR_RATE).
+	chaosDefault chaosFault
+	// chaosRoutes are per-route faults, keyed like routeTimeouts.
+	chaosRoutes = map[string]chaosFault{}
+)
+
+func init() {
+	registerMetric("chaos_injected_total", "counter", "Faults injected by CHAOS_ENABLED, by route and fault (latency, error).")
+}
+
+// configureChaos reads CHAOS_ENABLED, CHAOS_LATENCY, CHAOS_ERROR_RATE and
+// CHAOS_ROUTES, a comma-separated list of [METHOD ]route=fault|fault with
+// faults written as a duration or a rate, e.g.
+// "GET /categories=250ms,/categories/{id}=0.2|1s".
+func configureChaos() {
+	chaosEnabled = envBool("CHAOS_ENABLED", chaosEnabled)
+	if !chaosEnabled {
+		return
+	}
+	chaosDefault.Latency = envDuration("CHAOS_LATENCY", 0)
+	chaosDefault.ErrorRate = envFloat("CHAOS_ERROR_RATE", 0)
+	if chaosDefault.ErrorRate < 0 || chaosDefault.ErrorRate > 1 {
+		log.Fatalf("CHAOS_ERROR_RATE must be between 0 and 1, got %v", chaosDefault.ErrorRate)
+	}
+	for _, entry := range splitList(os.Getenv("CHAOS_ROUTES")) {
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			log.Fatalf("invalid CHAOS_ROUTES entry %q: want [METHOD ]route=latency|error_rate", entry)
+		}
+		route := strings.TrimSpace(entry[:i])
+		var fault chaosFault
+		for _, v := range strings.Split(entry[i+1:], "|") {
+			v = strings.TrimSpace(v)
+			if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+				fault.Latency = d
+			} else if rate, err := strconv.ParseFloat(v, 64); err == nil && rate >= 0 && rate <= 1 {
+				fault.ErrorRate = rate
+			} else {
+				log.Fatalf("invalid CHAOS_ROUTES entry %q: %q is neither a duration nor a rate between 0 and 1", entry, v)
+			}
+		}
+		if !strings.Contains(route, " ") {
+			route = "* " + route
+		}
+		chaosRoutes[route] = fault
+	}
+	log.Printf("chaos: injecting faults (default latency %s, error rate %v, %d route overrides); not for production",
+		chaosDefault.Latency, chaosDefault.ErrorRate, len(chaosRoutes))
+}
+
+// chaosFor is the fault that applies to a request. Probes and admin routes
+// are spared, so injected errors don't get the instance restarted.
+func chaosFor(r *http.Request) chaosFault {
+	route := routeLabel(r.URL.Path)
+	switch {
+	case route == "/livez" || route == "/readyz" || route == "/startupz" || adminOnly(r.URL.Path):
+		return chaosFault{}
+	}
+	if f, ok := chaosRoutes[r.Method+" "+route]; ok {
+		return f
+	}
+	if f, ok := chaosRoutes["* "+route]; ok {
+		return f
+	}
+	return chaosDefault
+}
+
+// injectFaults delays requests and fails a share of them with 503
+// FAULT_INJECTED and Retry-After, as configured by configureChaos. Injected
+// responses carry X-Chaos-Fault, so they can be told apart from real
+// failures. It runs outside trackServerErrors, so the errors it injects
+// don't raise alerts.
+func injectFaults(next http.Handler) http.Handler {
+	if !chaosEnabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fault := chaosFor(r)
+		route := r.Method + " " + routeLabel(r.URL.Path)
+		if fault.Latency > 0 {
+			w.Header().Add("X-Chaos-Fault", "latency="+fault.Latency.String())
+			addMetric("chaos_injected_total", 1, "route", route, "fault", "latency")
+			select {
+			case <-time.After(fault.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if fault.ErrorRate > 0 && rand.Float64() < fault.ErrorRate {
+			w.Header().Add("X-Chaos-Fault", "error")
+			w.Header().Set("Retry-After", "1")
+			addMetric("chaos_injected_total", 1, "route", route, "fault", "error")
+			writeAPIError(w, CodeFaultInjected, "fault injected for resilience testing (CHAOS_ENABLED)")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =======================
+// CHAT WEBHOOKS
+// =======================
+
+// ChatMessage is the payload of a "chat" job.
+type ChatMessage struct {
+	Text string `json:"text"`
+}
+
+// chatNotifier posts admin events to a Slack or Discord incoming webhook
+// (CHAT_WEBHOOK_URL). At most chatRateLimit messages go out per minute; the
+// rest are counted and mentioned in the next message that gets through.
+type chatNotifier struct {
+	events map[string]bool
+
+	mu          sync.Mutex
+	windowStart time.Time
+	sent        int
+	suppressed  int
+}
+
+var (
+	chatWebhookURL string
+	// chatKind is "slack" or "discord" (CHAT_WEBHOOK_KIND, guessed from the URL if unset).
+	chatKind string
+	// chatRateLimit is the maximum number of chat messages per minute
+	// (CHAT_RATE_LIMIT). Guarded by runtimeMu.
+	chatRateLimit = 10
+)
+
+func init() {
+	registerJobHandler("chat", sendChatJob)
+	registerMetric("chat_notifications_total", "counter", "Chat notifications by result (queued, suppressed).")
+	registerRuntimeInt("chat_rate_limit", "Chat notifications sent per minute; the rest are summarized in the next one.", 1,
+		func() int { return chatRateLimit },
+		func(n int) { chatRateLimit = n })
+}
+
+// configureChat enables the chat notifier when CHAT_WEBHOOK_URL is set.
+func configureChat() {
+	chatWebhookURL = os.Getenv("CHAT_WEBHOOK_URL")
+	if chatWebhookURL == "" {
+		return
+	}
+
+	chatKind = os.Getenv("CHAT_WEBHOOK_KIND")
+	switch {
+	case chatKind == "" && strings.Contains(chatWebhookURL, "discord"):
+		chatKind = "discord"
+	case chatKind == "":
+		chatKind = "slack"
+	case chatKind != "slack" && chatKind != "discord":
+		log.Fatalf("invalid CHAT_WEBHOOK_KIND %q: want slack or discord", chatKind)
+	}
+	chatRateLimit = envInt("CHAT_RATE_LIMIT", chatRateLimit)
+
+	events := map[string]bool{}
+	names := splitList(os.Getenv("CHAT_NOTIFY_EVENTS"))
+	if len(names) == 0 {
+		names = []string{EventCategoryMerged, EventPurgeCompleted, EventServerErrors}
+	}
+	for _, e := range names {
+		if _, ok := eventTemplates[e]; !ok {
+			log.Fatalf("invalid CHAT_NOTIFY_EVENTS: unknown event %q", e)
+		}
+		events[e] = true
+	}
+
+	notifiers = append(notifiers, &chatNotifier{events: events})
+}
+
+func (n *chatNotifier) Notify(event string, data map[string]interface{}) {
+	if !n.events[event] {
+		return
+	}
+
+	n.mu.Lock()
+	now := time.Now()
+	if now.Sub(n.windowStart) >= time.Minute {
+		n.windowStart = now
+		n.sent = 0
+	}
+	runtimeMu.RLock()
+	limit := chatRateLimit
+	runtimeMu.RUnlock()
+	if n.sent >= limit {
+		n.suppressed++
+		n.mu.Unlock()
+		addMetric("chat_notifications_total", 1, "result", "suppressed")
+		return
+	}
+	n.sent++
+	suppressed := n.suppressed
+	n.suppressed = 0
+	n.mu.Unlock()
+
+	summary, _, err := renderEvent(event, data)
+	if err != nil {
+		log.Printf("chat: %v", err)
+		return
+	}
+	if suppressed > 0 {
+		summary += fmt.Sprintf(" (%d earlier notifications suppressed)", suppressed)
+	}
+
+	if _, err := enqueueJob("chat", ChatMessage{Text: summary}); err != nil {
+		log.Printf("chat: %v", err)
+		return
+	}
+	addMetric("chat_notifications_total", 1, "result", "queued")
+}
+
+func sendChatJob(job *Job) error {
+	var msg ChatMessage
+	if err := json.Unmarshal(job.Payload, &msg); err != nil {
+		return err
+	}
+
+	body := map[string]string{"text": msg.Text}
+	if chatKind == "discord" {
+		body = map[string]string{"content": msg.Text}
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(chatWebhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("chat webhook returned %s", resp.Status)
+	}
+	return nil
+}
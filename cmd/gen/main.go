@@ -0,0 +1,278 @@
+// Command gen scaffolds a new in-memory CRUD resource following the
+// project's conventions.
+//
+//	go run ./cmd/gen resource Widget -fields "name:string,price:float64,sku:string"
+//
+// It writes <plural>.go (snake case) at the repository root with the model, storage, a
+// Resource[T] and the five annotated handlers, then prints the lines still
+// to add by hand (route registration, transaction snapshot).
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+type field struct {
+	Name string // Go name, e.g. UnitPrice
+	Type string
+	JSON string // e.g. unit_price
+}
+
+type resource struct {
+	Name   string // e.g. Widget
+	Plural string // e.g. Widgets
+	Lower  string // e.g. widget
+	Store  string // e.g. widgets
+	Words  string // e.g. supply category, for messages
+	File   string // e.g. supply_categories
+	Path   string // e.g. widgets
+	Upper  string // e.g. WIDGET
+	Fields []field
+}
+
+func main() {
+	if len(os.Args) < 3 || os.Args[1] != "resource" {
+		fmt.Fprintln(os.Stderr, "usage: gen resource <Name> [-fields name:type,...] [-plural Names] [-dir .]")
+		os.Exit(2)
+	}
+	name := os.Args[2]
+	flags := flag.NewFlagSet("resource", flag.ExitOnError)
+	fields := flags.String("fields", "name:string,description:string", "comma-separated name:type pairs")
+	plural := flags.String("plural", "", "plural name (default: Name + s)")
+	dir := flags.String("dir", ".", "directory of the main package")
+	flags.Parse(os.Args[3:])
+
+	res, err := newResource(name, *plural, *fields)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gen:", err)
+		os.Exit(2)
+	}
+
+	path := filepath.Join(*dir, res.File+".go")
+	if _, err := os.Stat(path); err == nil {
+		fmt.Fprintf(os.Stderr, "gen: %s already exists\n", path)
+		os.Exit(1)
+	}
+
+	var buf bytes.Buffer
+	if err := resourceTemplate.Execute(&buf, res); err != nil {
+		fmt.Fprintln(os.Stderr, "gen:", err)
+		os.Exit(1)
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gen: generated code does not parse:", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(path, src, 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, "gen:", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("wrote %s\n\nNext steps:\n", path)
+	fmt.Printf("  1. In main(), register the routes:\n")
+	fmt.Printf("       registerResourceRoutes(storeRoutes, \"/%s\", Get%s, Create%s, Get%s, Update%s, Delete%s)\n",
+		res.Path, res.Plural, res.Name, res.Name, res.Name, res.Name)
+	fmt.Printf("  2. Add \"%s\" to the known prefixes in routeLabel (middleware.go).\n", res.Path)
+	fmt.Printf("  3. If %s change inside withTx, add them to storeSnapshot (tx.go).\n", res.Path)
+	fmt.Printf("  4. Run swag init to regenerate the API docs.\n")
+}
+
+func newResource(name, plural, fieldSpec string) (*resource, error) {
+	if name == "" || !unicode.IsUpper(rune(name[0])) {
+		return nil, fmt.Errorf("resource name %q must start with an upper-case letter", name)
+	}
+	if plural == "" {
+		plural = pluralize(name)
+	}
+	res := &resource{
+		Name:   name,
+		Plural: plural,
+		Lower:  strings.ToLower(name[:1]) + name[1:],
+		Store:  strings.ToLower(plural[:1]) + plural[1:],
+		Words:  strings.ReplaceAll(snake(name), "_", " "),
+		File:   snake(plural),
+		Path:   strings.ReplaceAll(snake(plural), "_", "-"),
+		Upper:  strings.ToUpper(snake(name)),
+	}
+
+	for _, spec := range strings.Split(fieldSpec, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("field %q: want name:type", spec)
+		}
+		goName := camel(parts[0])
+		if goName == "ID" {
+			return nil, fmt.Errorf("field %q: id is added automatically", spec)
+		}
+		res.Fields = append(res.Fields, field{Name: goName, Type: parts[1], JSON: snake(goName)})
+	}
+	return res, nil
+}
+
+func pluralize(name string) string {
+	switch {
+	case strings.HasSuffix(name, "y") && !strings.ContainsAny(name[len(name)-2:len(name)-1], "aeiou"):
+		return name[:len(name)-1] + "ies"
+	case strings.HasSuffix(name, "s"), strings.HasSuffix(name, "x"), strings.HasSuffix(name, "ch"), strings.HasSuffix(name, "sh"):
+		return name + "es"
+	}
+	return name + "s"
+}
+
+// camel turns "unit_price" or "unitPrice" into "UnitPrice", keeping the
+// common initialisms upper-case.
+func camel(s string) string {
+	out := ""
+	for _, word := range strings.FieldsFunc(s, func(r rune) bool { return r == '_' || r == '-' }) {
+		switch strings.ToLower(word) {
+		case "id", "url", "sku", "api", "http":
+			out += strings.ToUpper(word)
+		default:
+			out += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return out
+}
+
+// snake turns "UnitPrice" into "unit_price" and "SKU" into "sku".
+func snake(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+var resourceTemplate = template.Must(template.New("resource").Parse(`package main
+
+import (
+	"net/http"
+)
+
+// =======================
+// MODEL
+// =======================
+
+type {{.Name}} struct {
+	ID int ` + "`json:\"id\"`" + `
+{{- range .Fields}}
+	{{.Name}} {{.Type}} ` + "`json:\"{{.JSON}}\"`" + `
+{{- end}}
+}
+
+// =======================
+// STORAGE (fake DB)
+// =======================
+
+var (
+	{{.Store}}      = map[int]*{{.Name}}{}
+	{{.Lower}}AutoID = 1
+)
+
+// Code{{.Name}}NotFound is returned for unknown {{.Words}} ids.
+const Code{{.Name}}NotFound ErrorCode = "{{.Upper}}_NOT_FOUND"
+
+func init() {
+	registerErrorCode(ErrorInfo{Code{{.Name}}NotFound, http.StatusNotFound, "The {{.Words}} does not exist."})
+}
+
+// {{.Name}}Hooks run around creates, updates and deletes of {{.Words}} records.
+var {{.Name}}Hooks = &Hooks[{{.Name}}]{}
+
+// =======================
+// HANDLER
+// =======================
+
+var {{.Lower}}Resource = &Resource[{{.Name}}]{
+	Name:     "{{.Words}}",
+	NotFound: Code{{.Name}}NotFound,
+	Store:    {{.Store}},
+	NextID:   &{{.Lower}}AutoID,
+	ID:       func(v *{{.Name}}) int { return v.ID },
+	SetID:    func(v *{{.Name}}, id int) { v.ID = id },
+	Apply: func(dst, src *{{.Name}}) {
+{{- range .Fields}}
+		dst.{{.Name}} = src.{{.Name}}
+{{- end}}
+	},
+	Hooks: {{.Name}}Hooks,
+}
+
+// Get{{.Plural}} godoc
+// @Summary Get all {{.Words}} records
+// @Tags {{.Name}}
+// @Produce json
+// @Success 200 {array} {{.Name}}
+// @Router /{{.Path}} [get]
+func Get{{.Plural}}(w http.ResponseWriter, r *http.Request) error {
+	return {{.Lower}}Resource.List(w, r)
+}
+
+// Create{{.Name}} godoc
+// @Summary Create {{.Words}}
+// @Tags {{.Name}}
+// @Accept json
+// @Produce json
+// @Param body body {{.Name}} true "{{.Name}}"
+// @Success 201 {object} {{.Name}}
+// @Failure 400 {string} string
+// @Router /{{.Path}} [post]
+func Create{{.Name}}(w http.ResponseWriter, r *http.Request) error {
+	return {{.Lower}}Resource.Create(w, r)
+}
+
+// Get{{.Name}} godoc
+// @Summary Get {{.Words}} detail
+// @Tags {{.Name}}
+// @Produce json
+// @Param id path int true "{{.Name}} ID"
+// @Success 200 {object} {{.Name}}
+// @Failure 404 {string} string
+// @Router /{{.Path}}/{id} [get]
+func Get{{.Name}}(w http.ResponseWriter, r *http.Request) error {
+	return {{.Lower}}Resource.Get(w, r)
+}
+
+// Update{{.Name}} godoc
+// @Summary Update {{.Words}}
+// @Tags {{.Name}}
+// @Accept json
+// @Produce json
+// @Param id path int true "{{.Name}} ID"
+// @Param body body {{.Name}} true "{{.Name}}"
+// @Success 200 {object} {{.Name}}
+// @Failure 400 {string} string
+// @Failure 404 {string} string
+// @Router /{{.Path}}/{id} [put]
+func Update{{.Name}}(w http.ResponseWriter, r *http.Request) error {
+	return {{.Lower}}Resource.Update(w, r)
+}
+
+// Delete{{.Name}} godoc
+// @Summary Delete {{.Words}}
+// @Tags {{.Name}}
+// @Param id path int true "{{.Name}} ID"
+// @Success 204
+// @Failure 404 {string} string
+// @Router /{{.Path}}/{id} [delete]
+func Delete{{.Name}}(w http.ResponseWriter, r *http.Request) error {
+	return {{.Lower}}Resource.Delete(w, r)
+}
+`))
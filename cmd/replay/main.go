@@ -0,0 +1,214 @@
+// Command replay sends recorded requests to a server again and reports
+// where the responses differ from the recorded ones, to reproduce a bug a
+// client reported while RECORDING_SIZE or RECORDING_FILE was set.
+//
+//	go run ./cmd/replay -from http://prod:8080/admin/recordings?path=/categories -target http://localhost:8080
+//	go run ./cmd/replay -from recordings.jsonl -target http://localhost:8080 -id 42 -v
+//
+// -from is a GET /admin/recordings URL, whose pages are all fetched, or a
+// RECORDING_FILE. Requests are sent oldest first. Recorded bodies marked truncated are sent as recorded, so
+// their responses usually differ.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// recording mirrors Recording in the main package.
+type recording struct {
+	ID             int         `json:"id"`
+	Method         string      `json:"method"`
+	Path           string      `json:"path"`
+	RequestHeader  http.Header `json:"request_header"`
+	RequestBody    string      `json:"request_body"`
+	Status         int         `json:"status"`
+	ResponseHeader http.Header `json:"response_header"`
+	ResponseBody   string      `json:"response_body"`
+	Truncated      bool        `json:"truncated"`
+}
+
+func main() {
+	from := flag.String("from", "", "GET /admin/recordings URL or RECORDING_FILE path")
+	target := flag.String("target", "http://localhost:8080", "base URL to replay against")
+	id := flag.Int("id", 0, "replay only this recording")
+	verbose := flag.Bool("v", false, "print both bodies when responses differ")
+	ignore := flag.String("ignore", "created_at,updated_at", "JSON keys left out when comparing bodies")
+	flag.Parse()
+	for _, k := range strings.Split(*ignore, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			ignoredKeys[k] = true
+		}
+	}
+	if *from == "" {
+		fmt.Fprintln(os.Stderr, "usage: replay -from <url|file> [-target http://localhost:8080] [-id N] [-v]")
+		os.Exit(2)
+	}
+
+	recs, err := load(*from)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "replay:", err)
+		os.Exit(1)
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].ID < recs[j].ID })
+
+	differ := 0
+	for _, rec := range recs {
+		if *id != 0 && rec.ID != *id {
+			continue
+		}
+		status, body, err := send(*target, rec)
+		if err != nil {
+			fmt.Printf("#%d %s %s: %v\n", rec.ID, rec.Method, rec.Path, err)
+			differ++
+			continue
+		}
+		same := status == rec.Status && sameBody(body, decode(rec.ResponseBody))
+		mark := "same"
+		if !same {
+			mark = "DIFFERS"
+			differ++
+		}
+		fmt.Printf("#%d %s %s: recorded %d, got %d, body %s\n", rec.ID, rec.Method, rec.Path, rec.Status, status, mark)
+		if !same && *verbose {
+			fmt.Printf("  recorded: %s\n  got:      %s\n", rec.ResponseBody, body)
+		}
+	}
+	if differ > 0 {
+		os.Exit(1)
+	}
+}
+
+func load(from string) ([]recording, error) {
+	if strings.HasPrefix(from, "http://") || strings.HasPrefix(from, "https://") {
+		var recs []recording
+		for next := from; next != ""; {
+			page, link, err := fetchPage(next)
+			if err != nil {
+				return nil, err
+			}
+			recs = append(recs, page...)
+			next = link
+		}
+		return recs, nil
+	}
+
+	f, err := os.Open(from)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var recs []recording
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 16<<20)
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var rec recording
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s: %v", from, err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs, sc.Err()
+}
+
+// fetchPage gets one page of recordings and the URL of the next page from
+// its Link header, if there is one.
+func fetchPage(pageURL string) ([]recording, string, error) {
+	resp, err := http.Get(pageURL)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("%s: %s", pageURL, resp.Status)
+	}
+	var recs []recording
+	if err := json.NewDecoder(resp.Body).Decode(&recs); err != nil {
+		return nil, "", err
+	}
+	for _, link := range resp.Header.Values("Link") {
+		ref, params, _ := strings.Cut(link, ";")
+		if strings.TrimSpace(params) != `rel="next"` {
+			continue
+		}
+		next, err := resp.Request.URL.Parse(strings.Trim(strings.TrimSpace(ref), "<>"))
+		if err != nil {
+			return nil, "", err
+		}
+		return recs, next.String(), nil
+	}
+	return recs, "", nil
+}
+
+func send(target string, rec recording) (int, []byte, error) {
+	req, err := http.NewRequest(rec.Method, strings.TrimSuffix(target, "/")+rec.Path, bytes.NewReader(decode(rec.RequestBody)))
+	if err != nil {
+		return 0, nil, err
+	}
+	for k, v := range rec.RequestHeader {
+		req.Header[k] = v
+	}
+	req.Header.Del("Content-Length")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, body, err
+}
+
+// decode undoes the "base64:" marking of binary bodies.
+func decode(body string) []byte {
+	if b64, ok := strings.CutPrefix(body, "base64:"); ok {
+		if b, err := base64.StdEncoding.DecodeString(b64); err == nil {
+			return b
+		}
+	}
+	return []byte(body)
+}
+
+// ignoredKeys hold values that change on every run, such as timestamps.
+var ignoredKeys = map[string]bool{}
+
+// sameBody compares JSON bodies by value, without ignoredKeys, so key order
+// and spacing don't count, and other bodies byte for byte.
+func sameBody(a, b []byte) bool {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) == nil && json.Unmarshal(b, &vb) == nil {
+		ja, _ := json.Marshal(withoutIgnored(va))
+		jb, _ := json.Marshal(withoutIgnored(vb))
+		return bytes.Equal(ja, jb)
+	}
+	return bytes.Equal(a, b)
+}
+
+func withoutIgnored(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if ignoredKeys[k] {
+				delete(v, k)
+			} else {
+				v[k] = withoutIgnored(child)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = withoutIgnored(v[i])
+		}
+	}
+	return v
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// =======================
+// REQUEST DECOMPRESSION
+// =======================
+
+var (
+	// gzipRoutes accept "Content-Encoding: gzip" bodies, keyed like
+	// asyncRoutes. Other routes refuse encoded bodies with 415.
+	gzipRoutes = map[string]bool{
+		"POST /categories/import": true,
+		"PATCH /categories/bulk":  true,
+		"POST /admin/archive":     true,
+	}
+
+	// maxDecompressedBody caps what a gzip body may inflate to
+	// (MAX_DECOMPRESSED_BODY, bytes), so a small upload can't expand into
+	// gigabytes.
+	maxDecompressedBody = 64 << 20
+)
+
+// decompressRequests inflates gzip request bodies on gzipRoutes before any
+// handler sees them; the request then looks as if it was sent plain.
+func decompressRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		if encoding == "" || encoding == "identity" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		allowed := gzipRoutes[r.Method+" "+routeLabel(r.URL.Path)]
+		if (encoding != "gzip" && encoding != "x-gzip") || !allowed {
+			if allowed {
+				w.Header().Set("Accept-Encoding", "gzip")
+			} else {
+				w.Header().Set("Accept-Encoding", "identity")
+			}
+			writeAPIError(w, CodeUnsupportedMediaType, "Content-Encoding "+encoding+" is not accepted here")
+			return
+		}
+
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			writeAPIError(w, CodeInvalidJSON, "body is not valid gzip: "+err.Error())
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(zr, int64(maxDecompressedBody)+1))
+		if err != nil {
+			writeAPIError(w, CodeInvalidJSON, "body is not valid gzip: "+err.Error())
+			return
+		}
+		if len(body) > maxDecompressedBody {
+			writeAPIError(w, CodePayloadTooLarge, fmt.Sprintf("body inflates to more than %d bytes", maxDecompressedBody))
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.Header.Del("Content-Encoding")
+		r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		next.ServeHTTP(w, r)
+	})
+}
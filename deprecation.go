@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// =======================
+// DEPRECATION
+// =======================
+
+// routeDeprecation marks a route as on its way out. Responses carry
+// Deprecation and, when set, Sunset and a successor-version Link so clients
+// can detect it without reading the changelog.
+type routeDeprecation struct {
+	Since     time.Time // when it was deprecated
+	Sunset    time.Time // when it stops working; zero if not yet decided
+	Successor string    // path of the replacement, if any
+}
+
+// deprecatedRoutes is keyed by "METHOD route", with route as produced by
+// routeLabel (e.g. "GET /categories/{id}/products"). A "*" method matches
+// every method.
+var deprecatedRoutes = map[string]routeDeprecation{}
+
+func init() {
+	registerMetric("deprecated_requests_total", "counter", "Requests to deprecated routes, by route.")
+}
+
+// deprecateRoute registers a deprecation. Call it before the server starts.
+func deprecateRoute(method, route string, d routeDeprecation) {
+	deprecatedRoutes[method+" "+route] = d
+}
+
+// deprecations adds the deprecation headers (RFC 9745, RFC 8594) to
+// responses from deprecated routes.
+func deprecations(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(deprecatedRoutes) > 0 {
+			route := routeLabel(r.URL.Path)
+			d, ok := deprecatedRoutes[r.Method+" "+route]
+			if !ok {
+				d, ok = deprecatedRoutes["* "+route]
+			}
+			if ok {
+				h := w.Header()
+				h.Set("Deprecation", "@"+strconv.FormatInt(d.Since.Unix(), 10))
+				if !d.Sunset.IsZero() {
+					h.Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+				}
+				if d.Successor != "" {
+					h.Add("Link", "<"+d.Successor+`>; rel="successor-version"`)
+				}
+				addMetric("deprecated_requests_total", 1, "route", r.Method+" "+route)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -15,28 +15,194 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/categories": {
+        "/admin/analytics": {
             "get": {
+                "description": "Request counts, error rates and payload sizes per route and client. Usage is kept in\nANALYTICS_RESOLUTION buckets (default 1h) for ANALYTICS_RETENTION; a bucket is included\nwhen it starts inside [from, to). Clients are identified by mTLS certificate, X-Client-ID or remote IP.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Category"
+                    "Admin"
+                ],
+                "summary": "API usage analytics",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Start (RFC 3339, default 24h before to)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "End (RFC 3339, default now)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only this route, e.g. /categories/{id}",
+                        "name": "route",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only this client",
+                        "name": "client",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "route",
+                            "client",
+                            "\"route",
+                            "client\""
+                        ],
+                        "type": "string",
+                        "description": "Comma-separated dimensions (default route,client)",
+                        "name": "group_by",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.UsageReport"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/archive": {
+            "get": {
+                "description": "A zip with manifest.json plus categories, items, products, their relations and the custom field\ndefinitions, one JSON file each, IDs and soft-deleted records included. POST it to /admin/archive to\nrestore it elsewhere.",
+                "produces": [
+                    "application/zip"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Export the full dataset",
+                "parameters": [
+                    {
+                        "enum": [
+                            "gzip"
+                        ],
+                        "type": "string",
+                        "description": "gzip to send the body compressed",
+                        "name": "Content-Encoding",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Replaces every category, item, product, relation and custom field with the contents of an archive from\nGET /admin/archive, keeping IDs, so links survive the move between environments. The archive is checked\nfirst (manifest, checksums, references); if anything is wrong nothing is changed.\nSupports dry_run and Prefer: respond-async.",
+                "consumes": [
+                    "application/zip"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Restore the full dataset",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Validate and return the would-be response without saving anything",
+                        "name": "dry_run",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "respond-async answers 202 at once; poll the Location (GET /operations/{id})",
+                        "name": "Prefer",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ArchiveSummary"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/main.Operation"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/fields": {
+            "get": {
+                "description": "The attribute definitions every category write is validated against, by name and paged:\nX-Total-Count has the number of fields and Link the next and prev pages.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "List custom fields",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Page size (default PAGE_SIZE_DEFAULT, 20; at most PAGE_SIZE_MAX, 200)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Fields to skip",
+                        "name": "offset",
+                        "in": "query"
+                    }
                 ],
-                "summary": "Get all categories",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/main.Category"
+                                "$ref": "#/definitions/main.CustomField"
                             }
                         }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
                     }
                 }
-            },
-            "post": {
+            }
+        },
+        "/admin/fields/{name}": {
+            "put": {
+                "description": "Creates or replaces the definition of attributes.\u003cname\u003e. From then on category creates and updates\nmust satisfy it; existing categories are not rechecked until they are next written.",
                 "consumes": [
                     "application/json"
                 ],
@@ -44,43 +210,139 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Category"
+                    "Admin"
                 ],
-                "summary": "Create category",
+                "summary": "Define a custom field",
                 "parameters": [
                     {
-                        "description": "Category",
+                        "type": "string",
+                        "description": "Attribute name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Definition (name is taken from the path)",
                         "name": "body",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.Category"
+                            "$ref": "#/definitions/main.CustomField"
                         }
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Created",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.Category"
+                            "$ref": "#/definitions/main.CustomField"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "The attribute becomes free-form again; values already stored are kept.",
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Remove a custom field",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Attribute name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
                         }
                     }
                 }
             }
         },
-        "/categories/{id}": {
+        "/admin/jobs": {
             "get": {
+                "description": "Ordered by ID and paged: X-Total-Count has the number of jobs and Link the next and prev pages.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Category"
+                    "Admin"
                 ],
-                "summary": "Get category detail",
+                "summary": "List background jobs",
                 "parameters": [
+                    {
+                        "enum": [
+                            "queued",
+                            "running",
+                            "succeeded",
+                            "dead"
+                        ],
+                        "type": "string",
+                        "description": "Only jobs with this status (dead = dead-letter list)",
+                        "name": "status",
+                        "in": "query"
+                    },
                     {
                         "type": "integer",
-                        "description": "Category ID",
+                        "description": "Page size (default PAGE_SIZE_DEFAULT, 20; at most PAGE_SIZE_MAX, 200)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Jobs to skip",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Job"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/jobs/{id}/retry": {
+            "post": {
+                "description": "Moves a job off the dead-letter list and gives it a fresh set of attempts.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Retry a dead job",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Job ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -90,7 +352,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.Category"
+                            "$ref": "#/definitions/main.Job"
                         }
                     },
                     "404": {
@@ -98,47 +360,98 @@ const docTemplate = `{
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "string"
+                        }
                     }
                 }
-            },
-            "put": {
-                "consumes": [
+            }
+        },
+        "/admin/purge": {
+            "post": {
+                "description": "Permanently removes categories and items soft deleted longer ago than the retention period.",
+                "produces": [
                     "application/json"
                 ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Purge soft-deleted data",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Validate and return the would-be response without saving anything",
+                        "name": "dry_run",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "respond-async answers 202 at once; poll the Location (GET /operations/{id})",
+                        "name": "Prefer",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.PurgeResult"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/main.Operation"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/recordings": {
+            "get": {
+                "description": "The newest requests kept by the recording mode (RECORDING_SIZE), newest first. Authorization, cookies\nand RECORDING_REDACT_HEADERS are never recorded. Feed the result to cmd/replay to send them again.\nPaged: X-Total-Count has the number of matches and Link the next and prev pages.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Category"
+                    "Admin"
                 ],
-                "summary": "Update category",
+                "summary": "List recorded requests",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only requests whose path starts with this",
+                        "name": "path",
+                        "in": "query"
+                    },
                     {
                         "type": "integer",
-                        "description": "Category ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Page size (default PAGE_SIZE_DEFAULT, 20; at most PAGE_SIZE_MAX, 200)",
+                        "name": "limit",
+                        "in": "query"
                     },
                     {
-                        "description": "Category",
-                        "name": "body",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/main.Category"
-                        }
+                        "type": "integer",
+                        "description": "Recordings to skip",
+                        "name": "offset",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.Category"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Recording"
+                            }
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
                             "type": "string"
                         }
@@ -146,22 +459,42 @@ const docTemplate = `{
                 }
             },
             "delete": {
+                "description": "Empties the in-memory buffer; RECORDING_FILE is left alone.",
                 "tags": [
-                    "Category"
+                    "Admin"
                 ],
-                "summary": "Delete category",
+                "summary": "Clear recorded requests",
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/admin/recordings/{id}": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Get a recorded request",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Category ID",
+                        "description": "Recording ID",
                         "name": "id",
                         "in": "path",
                         "required": true
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content"
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Recording"
+                        }
                     },
                     "404": {
                         "description": "Not Found",
@@ -171,20 +504,3296 @@ const docTemplate = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "main.Category": {
-            "type": "object",
-            "properties": {
+        },
+        "/admin/runtime": {
+            "get": {
+                "description": "The knobs PUT /admin/runtime can change, with their current values.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "List runtime settings",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.RuntimeSetting"
+                            }
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Applies the given settings at once, without a restart, and records the change in the audit log.\nAll values are checked first: an unknown name or a bad value changes nothing. Changes last until\nthe process restarts; make them permanent in the environment.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Change runtime settings",
+                "parameters": [
+                    {
+                        "description": "Settings by name, e.g. {\\",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.RuntimeSetting"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/search/reindex": {
+            "post": {
+                "description": "Queues a search_reindex job that drops the index and writes every live category again.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Rebuild the search index",
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/main.Job"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/audit": {
+            "get": {
+                "description": "Entries about a category the caller's ACLs hide are left out. Oldest first and paged:\nX-Total-Count has the number of entries and Link the next and prev pages.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Audit"
+                ],
+                "summary": "Get audit log",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Only entries for this category",
+                        "name": "category_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size (default PAGE_SIZE_DEFAULT, 20; at most PAGE_SIZE_MAX, 200)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Entries to skip",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.AuditEntry"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/categories": {
+            "get": {
+                "description": "Categories are returned in display order (see PUT /categories/reorder).\nattr.\u003ckey\u003e=\u003cvalue\u003e filters on an attribute, e.g. ?attr.color=red; repeat for several keys.\nCategories whose acl the caller isn't in are left out.\nResults are paged: X-Total-Count has the number of matches and Link the next and prev pages.",
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/cbor",
+                    "application/x-protobuf"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Get all categories",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Page size (default PAGE_SIZE_DEFAULT, 20; at most PAGE_SIZE_MAX, 200)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Matches to skip",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only categories with this tag",
+                        "name": "tag",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "active",
+                            "archived"
+                        ],
+                        "type": "string",
+                        "description": "Only categories with this status",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "html"
+                        ],
+                        "type": "string",
+                        "description": "html adds description_html, the description rendered from Markdown",
+                        "name": "render",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Answer 304 if nothing changed since this HTTP date",
+                        "name": "If-Modified-Since",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.RenderedCategory"
+                            }
+                        }
+                    },
+                    "304": {
+                        "description": "Not Modified"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "acl restricts who may read and change the category: entries are user:\u003cname\u003e or role:\u003cname\u003e, where\nthe user is the mTLS identity or a trusted proxy's X-Forwarded-User and roles come from ACL_ROLES or\nX-Forwarded-Groups. The acl must keep write access for the caller unless they are in ACL_ADMINS.",
+                "consumes": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/cbor",
+                    "application/x-protobuf"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/cbor",
+                    "application/x-protobuf"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Create category",
+                "parameters": [
+                    {
+                        "description": "Category",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.Category"
+                        }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Validate and return the would-be response without saving anything",
+                        "name": "dry_run",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.Category"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/categories/bulk": {
+            "patch": {
+                "description": "Applies an RFC 6902 JSON Patch to each listed category, e.g. to rename a prefix across many of them.\nThe patch works on the category's JSON; only name, description, tags, attributes and acl may change,\nbut test may check any field, such as /version for optimistic locking. Every patched category is\nvalidated like PUT /categories/{id}. All entries apply or none do: the first failing entry is\nreported with its index. At most 1000 entries per request.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Bulk update categories",
+                "parameters": [
+                    {
+                        "description": "Patches by category ID",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.BulkPatch"
+                            }
+                        }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Validate and return the would-be response without saving anything",
+                        "name": "dry_run",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "respond-async answers 202 at once; poll the Location (GET /operations/{id})",
+                        "name": "Prefer",
+                        "in": "header"
+                    },
+                    {
+                        "enum": [
+                            "gzip"
+                        ],
+                        "type": "string",
+                        "description": "gzip to send the body compressed",
+                        "name": "Content-Encoding",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Category"
+                            }
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/main.Operation"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/categories/changes": {
+            "get": {
+                "description": "Returns create/update/delete operations after since, oldest first, for incremental sync.\nsince is either a sequence number (last_seq of the previous page) or an RFC 3339 timestamp.\n410 means since is older than the retained changelog and the client must re-fetch everything.\nwait long-polls: with nothing new after since, the request is held until a change arrives or the\nwait is over, then answers as usual (an empty page after a timeout). It is cut short to stay\nwithin the request timeout.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Get category changes",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Sequence number or RFC 3339 timestamp (default: from the beginning)",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of changes (default 100, max 1000)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Hold the request up to this long for new changes, e.g. 30s (max 1m)",
+                        "name": "wait",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ChangesPage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "410": {
+                        "description": "Gone",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/categories/exists": {
+            "post": {
+                "description": "Answers, for each ID and name, whether a live category has it, without fetching the categories. Names\nmatch the way import finds duplicates, ignoring case and punctuation. Categories the caller's ACLs hide\ncount as missing. At most 1000 IDs and names per request.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Check which categories exist",
+                "parameters": [
+                    {
+                        "description": "IDs and names to look up",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.ExistsQuery"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ExistsResult"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/categories/export": {
+            "get": {
+                "description": "Downloads every live category in display order as CSV (default) or as an Excel workbook\nwith a styled header row and fitted column widths. Dates are RFC 3339 in UTC unless Accept-Language or\nX-Timezone ask otherwise.",
+                "produces": [
+                    "text/csv",
+                    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Export categories",
+                "parameters": [
+                    {
+                        "enum": [
+                            "csv",
+                            "xlsx"
+                        ],
+                        "type": "string",
+                        "description": "File format",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Rows (default and at most EXPORT_MAX_ROWS, 10000); see X-Total-Count and Link",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Rows to skip",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Writes dates in this language's layout (en, en-GB, de, fr, id); default ISO",
+                        "name": "Accept-Language",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "IANA time zone for dates, e.g. Asia/Jakarta (default UTC)",
+                        "name": "X-Timezone",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/categories/import": {
+            "post": {
+                "description": "Creates categories from a JSON array or a CSV in the export layout (Name, Description, Tags, Attributes).\nA row is a duplicate when its name matches an existing category or an earlier row, ignoring case and\npunctuation; on_duplicate decides what happens to it. Each row is validated like POST /categories and\nreported on its own: a failing row doesn't stop the rest. Supports dry_run and Prefer: respond-async.",
+                "consumes": [
+                    "application/json",
+                    "text/csv"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Import categories",
+                "parameters": [
+                    {
+                        "enum": [
+                            "skip",
+                            "overwrite",
+                            "suffix"
+                        ],
+                        "type": "string",
+                        "description": "What to do with duplicates (default skip)",
+                        "name": "on_duplicate",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Validate and return the would-be response without saving anything",
+                        "name": "dry_run",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "respond-async answers 202 at once; poll the Location (GET /operations/{id})",
+                        "name": "Prefer",
+                        "in": "header"
+                    },
+                    {
+                        "enum": [
+                            "gzip"
+                        ],
+                        "type": "string",
+                        "description": "gzip to send the body compressed",
+                        "name": "Content-Encoding",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Categories",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Category"
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ImportSummary"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/main.Operation"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/categories/reorder": {
+            "put": {
+                "description": "Sets the display order; the listed IDs get positions 1..n in the order given.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Reorder categories",
+                "parameters": [
+                    {
+                        "description": "Ordered category IDs",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.ReorderRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Category"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/categories/search": {
+            "get": {
+                "description": "Full-text search over name, description and tags. Served by Elasticsearch/OpenSearch when\nSEARCH_URL is set (most relevant first), otherwise by a substring match in display order.\nCategories the caller's ACLs hide are neither returned nor counted in X-Total-Count.\nAfter BREAKER_THRESHOLD cluster failures in a row, searches fail fast with 503 and Retry-After.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Search categories",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Search text",
+                        "name": "q",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size (default PAGE_SIZE_DEFAULT, 20; at most PAGE_SIZE_MAX, 200)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Results to skip",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Category"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/categories/{id}": {
+            "get": {
+                "description": "Descriptions may be Markdown; render=html adds description_html, rendered and safe to display.",
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/cbor",
+                    "application/x-protobuf"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Get category detail",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Category ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "html"
+                        ],
+                        "type": "string",
+                        "description": "html adds description_html",
+                        "name": "render",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Answer 304 if unchanged since this HTTP date",
+                        "name": "If-Modified-Since",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.RenderedCategory"
+                        }
+                    },
+                    "304": {
+                        "description": "Not Modified"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Send the version you edited to get per-field merging: fields you didn't change keep the\nserver's value, and fields that both sides changed differently are rejected with 409.\nWithout a version the update simply overwrites.\nLeaving out acl keeps the current one; \"acl\": {} removes it. Callers who may only read get 403.\nIf-Match (the ETag from GET) or If-Unmodified-Since reject the update with 412 when the category\nchanged since; with REQUIRE_PRECONDITIONS=true one of them must be sent.",
+                "consumes": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/cbor",
+                    "application/x-protobuf"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/cbor",
+                    "application/x-protobuf"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Update category",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Category ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag the client last saw",
+                        "name": "If-Match",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last-Modified the client last saw",
+                        "name": "If-Unmodified-Since",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Category",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.Category"
+                        }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Validate and return the would-be response without saving anything",
+                        "name": "dry_run",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Category"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.UpdateConflict"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "428": {
+                        "description": "Precondition Required",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Items are deleted along with the category (soft deleted when SOFT_DELETE=true).\nLinked products are unlinked, or the delete is refused with 409 when CATEGORY_DELETE_MODE=block.\nHonours If-Match and If-Unmodified-Since like PUT.",
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Delete category",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Category ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag the client last saw",
+                        "name": "If-Match",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last-Modified the client last saw",
+                        "name": "If-Unmodified-Since",
+                        "in": "header"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Validate and return the would-be response without saving anything",
+                        "name": "dry_run",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "428": {
+                        "description": "Precondition Required",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/categories/{id}/archive": {
+            "post": {
+                "description": "Archived categories stay readable but reject new products.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Archive category",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Category ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Category"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/categories/{id}/clone": {
+            "post": {
+                "description": "Creates a copy named \"Copy of \u003cname\u003e\", with the same description, tags, attributes and acl, validated\nlike POST /categories. Pass items=true to copy its items as well.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Clone category",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Category ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Also copy the category's items",
+                        "name": "items",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.Category"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/categories/{id}/history": {
+            "get": {
+                "description": "Every revision of the category, oldest first, each with the fields that changed since the previous one.\nDeleted categories keep their history until they are purged. Only the newest HISTORY_LIMIT revisions are kept.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Get category history",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Category ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.CategoryHistory"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/categories/{id}/history/{revision}": {
+            "get": {
+                "description": "The category as it was after the given revision, with the fields that changed in it.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Get category revision",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Category ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Revision number",
+                        "name": "revision",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.CategoryRevision"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/categories/{id}/items": {
+            "get": {
+                "description": "Ordered by ID and paged: X-Total-Count has the number of items and Link the next and prev pages.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Item"
+                ],
+                "summary": "Get items in category",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Category ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size (default PAGE_SIZE_DEFAULT, 20; at most PAGE_SIZE_MAX, 200)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Items to skip",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Item"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Item"
+                ],
+                "summary": "Create item in category",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Category ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Item",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.Item"
+                        }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Validate and return the would-be response without saving anything",
+                        "name": "dry_run",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.Item"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/categories/{id}/items/{itemId}": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Item"
+                ],
+                "summary": "Get item detail",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Category ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Item ID",
+                        "name": "itemId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Item"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Item"
+                ],
+                "summary": "Update item",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Category ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Item ID",
+                        "name": "itemId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Item",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.Item"
+                        }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Validate and return the would-be response without saving anything",
+                        "name": "dry_run",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Item"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "tags": [
+                    "Item"
+                ],
+                "summary": "Delete item",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Category ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Item ID",
+                        "name": "itemId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Validate and return the would-be response without saving anything",
+                        "name": "dry_run",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/categories/{id}/merge": {
+            "post": {
+                "description": "Moves the items, products and tags of every source category into the target and removes the sources.\nThis runs as one transaction: if any source is invalid nothing is changed.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Merge categories",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Target category ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Source categories",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.MergeRequest"
+                        }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Validate and return the would-be response without saving anything",
+                        "name": "dry_run",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "respond-async answers 202 at once; poll the Location (GET /operations/{id})",
+                        "name": "Prefer",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Category"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/main.Operation"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/categories/{id}/products": {
+            "get": {
+                "description": "Ordered by ID and paged: X-Total-Count has the number of products and Link the next and prev pages.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Get products in category",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Category ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size (default PAGE_SIZE_DEFAULT, 20; at most PAGE_SIZE_MAX, 200)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Products to skip",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Product"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/categories/{id}/products/{pid}": {
+            "post": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Add product to category",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Category ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Product ID",
+                        "name": "pid",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Product"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Remove product from category",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Category ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Product ID",
+                        "name": "pid",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/categories/{id}/revert": {
+            "post": {
+                "description": "Restores the name, description, tags and attributes the category had at the given revision. This is recorded as a new\nrevision, so a revert can itself be reverted. Status is left alone; use archive/unarchive for it.\nHonours If-Match and If-Unmodified-Since like PUT.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Revert category",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Category ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Revision to restore",
+                        "name": "revision",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag the client last saw",
+                        "name": "If-Match",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last-Modified the client last saw",
+                        "name": "If-Unmodified-Since",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Category"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "428": {
+                        "description": "Precondition Required",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/categories/{id}/unarchive": {
+            "post": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Unarchive category",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Category ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Category"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/downloads/{token}": {
+            "get": {
+                "description": "Serves the file behind a signed link from GET /exports/{id}. Supports Range requests, so an interrupted\ndownload can resume: send Range: bytes=\u003creceived\u003e- with If-Range set to the ETag of the first response.\nAn expired link can be replaced by calling GET /exports/{id} again; it points at the same file.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Download an export",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Signed token",
+                        "name": "token",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Byte range(s) to fetch, e.g. bytes=1048576-",
+                        "name": "Range",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag or Last-Modified of the partial copy; a changed file is sent whole",
+                        "name": "If-Range",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "206": {
+                        "description": "Partial Content",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "410": {
+                        "description": "Gone",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "416": {
+                        "description": "Requested Range Not Satisfiable",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/errors": {
+            "get": {
+                "description": "Every error response carries one of these codes in its X-Error-Code header.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Errors"
+                ],
+                "summary": "Error code catalog",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.ErrorInfo"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/exports": {
+            "post": {
+                "description": "Queues an export job and answers at once; poll GET /exports/{id} for a download link.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Start an export",
+                "parameters": [
+                    {
+                        "enum": [
+                            "csv",
+                            "xlsx"
+                        ],
+                        "type": "string",
+                        "description": "File format (default csv)",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Rows (default and at most EXPORT_MAX_ROWS, 10000)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Rows to skip",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Writes dates in this language's layout (en, en-GB, de, fr, id); default ISO",
+                        "name": "Accept-Language",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "IANA time zone for dates, e.g. Asia/Jakarta (default UTC)",
+                        "name": "X-Timezone",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/main.ExportStatus"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/exports/{id}": {
+            "get": {
+                "description": "Returns the export job. Once it has succeeded the answer carries a signed download_url,\nvalid for DOWNLOAD_URL_TTL; each call mints a new one. Only the caller who created the export\ncan see it; anyone else gets 404.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Category"
+                ],
+                "summary": "Get an export",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Export (job) ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ExportStatus"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/livez": {
+            "get": {
+                "description": "200 while the process is up; it stays up during a graceful shutdown.",
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "Health"
+                ],
+                "summary": "Liveness probe",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/metrics": {
+            "get": {
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Prometheus metrics",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/operations/{id}": {
+            "get": {
+                "description": "Polls a request accepted with \"Prefer: respond-async\" (import, merge, purge, archive restore). Once it has run, response holds\nthe status and body the endpoint would have answered; status is failed for 4xx/5xx answers. An import\nalso links the rows it rejected in report_url (GET /operations/{id}/report).",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Get an async operation",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Operation ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Operation"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/operations/{id}/report": {
+            "get": {
+                "description": "Lists the rows an import run with \"Prefer: respond-async\" rejected: row number (the line for CSV), name,\nthe field at fault when the error is about one, and the error. Fix the rows and import them again.\nReports are kept for DOWNLOAD_RETENTION; report_url on the operation links here while one exists.",
+                "produces": [
+                    "application/json",
+                    "text/csv"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Get the validation report of an import",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Operation ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "json",
+                            "csv"
+                        ],
+                        "type": "string",
+                        "description": "json (default) or csv",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.ImportRowResult"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/products": {
+            "get": {
+                "description": "Ordered by ID and paged: X-Total-Count has the number of products and Link the next and prev pages.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Product"
+                ],
+                "summary": "Get all products",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Page size (default PAGE_SIZE_DEFAULT, 20; at most PAGE_SIZE_MAX, 200)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Products to skip",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Product"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "category_ids is ignored; use the category link endpoints instead.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Product"
+                ],
+                "summary": "Create product",
+                "parameters": [
+                    {
+                        "description": "Product",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.Product"
+                        }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Validate and return the would-be response without saving anything",
+                        "name": "dry_run",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.Product"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/{id}": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Product"
+                ],
+                "summary": "Get product detail",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Product ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Product"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Product"
+                ],
+                "summary": "Update product",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Product ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Product",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.Product"
+                        }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Validate and return the would-be response without saving anything",
+                        "name": "dry_run",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Product"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "tags": [
+                    "Product"
+                ],
+                "summary": "Delete product",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Product ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Validate and return the would-be response without saving anything",
+                        "name": "dry_run",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/readyz": {
+            "get": {
+                "description": "200 once the server accepts traffic and every registered check (Redis, event bus, disk space) passes,\n503 before that, after SIGTERM or while a check fails. verbose=1 answers JSON with each check's status\nand latency.",
+                "produces": [
+                    "text/plain",
+                    "application/json"
+                ],
+                "tags": [
+                    "Health"
+                ],
+                "summary": "Readiness probe",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Report per-check status and latency",
+                        "name": "verbose",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.HealthReport"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.HealthReport"
+                        }
+                    }
+                }
+            }
+        },
+        "/reports/categories.pdf": {
+            "get": {
+                "description": "A printable summary: counts, the most recent changes and the full category listing, limited to the\ncategories the caller's ACLs let them read.\nThe same report can be written to REPORT_DIR on a schedule (SCHEDULE_REPORT).",
+                "produces": [
+                    "application/pdf"
+                ],
+                "tags": [
+                    "Reports"
+                ],
+                "summary": "Category report (PDF)",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Writes dates and counts in this language's format (en, en-GB, de, fr, id)",
+                        "name": "Accept-Language",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "IANA time zone for dates, e.g. Asia/Jakarta (default UTC)",
+                        "name": "X-Timezone",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/startupz": {
+            "get": {
+                "description": "200 once configuration is loaded and background workers are running.",
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "Health"
+                ],
+                "summary": "Startup probe",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/tags": {
+            "get": {
+                "description": "Lists every distinct tag with the number of categories using it, by name and paged:\nX-Total-Count has the number of tags and Link the next and prev pages.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Tag"
+                ],
+                "summary": "Get all tags",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Page size (default PAGE_SIZE_DEFAULT, 20; at most PAGE_SIZE_MAX, 200)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Tags to skip",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Answer 304 if nothing changed since this HTTP date",
+                        "name": "If-Modified-Since",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.TagCount"
+                            }
+                        }
+                    },
+                    "304": {
+                        "description": "Not Modified"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/openapi.json": {
+            "get": {
+                "description": "The Swagger 2.0 document of one API version as generated at build time, e.g. /v1/openapi.json, for\npinning client generators and contract tests to the version they call. It only changes with a new build\nand is served with an ETag.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Health"
+                ],
+                "summary": "OpenAPI document of an API version",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/version": {
+            "get": {
+                "description": "The version, commit and build date the binary was built with, for checking what a deploy rolled out.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Health"
+                ],
+                "summary": "Build information",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.VersionInfo"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "main.ArchiveSummary": {
+            "type": "object",
+            "properties": {
+                "categories": {
+                    "type": "integer"
+                },
+                "custom_fields": {
+                    "type": "integer"
+                },
+                "items": {
+                    "type": "integer"
+                },
+                "products": {
+                    "type": "integer"
+                },
+                "relations": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.AuditEntry": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "category_id": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "details": {
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.BulkPatch": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "integer"
+                },
+                "patch": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.PatchOperation"
+                    }
+                }
+            }
+        },
+        "main.Category": {
+            "type": "object",
+            "properties": {
+                "acl": {
+                    "$ref": "#/definitions/main.CategoryACL"
+                },
+                "attributes": {
+                    "type": "object"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "deleted_at": {
+                    "type": "string"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "position": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "active",
+                        "archived"
+                    ]
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.CategoryACL": {
+            "type": "object",
+            "properties": {
+                "read": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "write": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.CategoryHistory": {
+            "type": "object",
+            "properties": {
+                "category_id": {
+                    "type": "integer"
+                },
+                "revisions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.CategoryRevision"
+                    }
+                },
+                "truncated": {
+                    "description": "Truncated is set when older revisions were dropped (HISTORY_LIMIT).",
+                    "type": "boolean"
+                }
+            }
+        },
+        "main.CategoryRevision": {
+            "type": "object",
+            "properties": {
+                "category": {
+                    "$ref": "#/definitions/main.Category"
+                },
+                "changed_at": {
+                    "type": "string"
+                },
+                "changes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.FieldChange"
+                    }
+                },
+                "op": {
+                    "type": "string",
+                    "enum": [
+                        "created",
+                        "updated",
+                        "deleted"
+                    ]
+                },
+                "revision": {
+                    "type": "integer"
+                },
+                "seq": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.ChangeEvent": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "data": {},
+                "op": {
+                    "type": "string",
+                    "enum": [
+                        "created",
+                        "updated",
+                        "deleted"
+                    ]
+                },
+                "resource": {
+                    "type": "string"
+                },
+                "resource_id": {
+                    "type": "integer"
+                },
+                "seq": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.ChangesPage": {
+            "type": "object",
+            "properties": {
+                "changes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.ChangeEvent"
+                    }
+                },
+                "has_more": {
+                    "type": "boolean"
+                },
+                "last_seq": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.CustomField": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "required": {
+                    "type": "boolean"
+                },
+                "type": {
+                    "type": "string",
+                    "enum": [
+                        "string",
+                        "number",
+                        "integer",
+                        "boolean",
+                        "date",
+                        "enum"
+                    ]
+                },
+                "values": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.ErrorCode": {
+            "type": "string",
+            "enum": [
+                "INVALID_JSON",
+                "VALIDATION_FAILED",
+                "ROUTE_NOT_FOUND",
+                "METHOD_NOT_ALLOWED",
+                "NOT_ACCEPTABLE",
+                "UNSUPPORTED_MEDIA_TYPE",
+                "PAYLOAD_TOO_LARGE",
+                "RECORDING_NOT_FOUND",
+                "RATE_LIMITED",
+                "CATEGORY_NOT_FOUND",
+                "ITEM_NOT_FOUND",
+                "PRODUCT_NOT_FOUND",
+                "PRODUCT_NOT_LINKED",
+                "JOB_NOT_FOUND",
+                "IMPORT_REPORT_NOT_FOUND",
+                "REVISION_NOT_FOUND",
+                "FIELD_NOT_FOUND",
+                "CATEGORY_HAS_PRODUCTS",
+                "CATEGORY_ARCHIVED",
+                "INVALID_TRANSITION",
+                "VERSION_CONFLICT",
+                "PATCH_TEST_FAILED",
+                "JOB_NOT_RETRYABLE",
+                "CHANGES_EXPIRED",
+                "PRECONDITION_FAILED",
+                "PRECONDITION_REQUIRED",
+                "NOT_READY",
+                "REQUEST_TIMEOUT",
+                "DOWNLOAD_LINK_INVALID",
+                "CLIENT_NOT_ALLOWED",
+                "ACCESS_DENIED",
+                "DOWNLOAD_GONE",
+                "SEARCH_UNAVAILABLE",
+                "BACKEND_UNAVAILABLE",
+                "READ_ONLY",
+                "FAULT_INJECTED",
+                "SEARCH_NOT_CONFIGURED",
+                "INTERNAL"
+            ],
+            "x-enum-varnames": [
+                "CodeInvalidJSON",
+                "CodeValidationFailed",
+                "CodeRouteNotFound",
+                "CodeMethodNotAllowed",
+                "CodeNotAcceptable",
+                "CodeUnsupportedMediaType",
+                "CodePayloadTooLarge",
+                "CodeRecordingNotFound",
+                "CodeRateLimited",
+                "CodeCategoryNotFound",
+                "CodeItemNotFound",
+                "CodeProductNotFound",
+                "CodeProductNotLinked",
+                "CodeJobNotFound",
+                "CodeImportReportNotFound",
+                "CodeRevisionNotFound",
+                "CodeFieldNotFound",
+                "CodeCategoryHasProducts",
+                "CodeCategoryArchived",
+                "CodeInvalidTransition",
+                "CodeVersionConflict",
+                "CodePatchTestFailed",
+                "CodeJobNotRetryable",
+                "CodeChangesExpired",
+                "CodePreconditionFailed",
+                "CodePreconditionRequired",
+                "CodeNotReady",
+                "CodeRequestTimeout",
+                "CodeDownloadInvalid",
+                "CodeClientNotAllowed",
+                "CodeAccessDenied",
+                "CodeDownloadGone",
+                "CodeSearchUnavailable",
+                "CodeBackendUnavailable",
+                "CodeReadOnly",
+                "CodeFaultInjected",
+                "CodeSearchNotConfigured",
+                "CodeInternal"
+            ]
+        },
+        "main.ErrorInfo": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "$ref": "#/definitions/main.ErrorCode"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.ExistsQuery": {
+            "type": "object",
+            "properties": {
+                "ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "names": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.ExistsResult": {
+            "type": "object",
+            "properties": {
+                "ids": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "boolean"
+                    }
+                },
+                "names": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "boolean"
+                    }
+                }
+            }
+        },
+        "main.ExportStatus": {
+            "type": "object",
+            "properties": {
+                "download_url": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "job": {
+                    "$ref": "#/definitions/main.Job"
+                }
+            }
+        },
+        "main.FieldChange": {
+            "type": "object",
+            "properties": {
+                "field": {
+                    "type": "string"
+                },
+                "from": {},
+                "to": {}
+            }
+        },
+        "main.HealthCheckResult": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "latency_ms": {
+                    "type": "number"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "ok",
+                        "failing"
+                    ]
+                }
+            }
+        },
+        "main.HealthReport": {
+            "type": "object",
+            "properties": {
+                "checks": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.HealthCheckResult"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "ok",
+                        "failing"
+                    ]
+                }
+            }
+        },
+        "main.ImportRowResult": {
+            "type": "object",
+            "properties": {
+                "duplicate_of": {
+                    "type": "integer"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "field": {
+                    "description": "Field is the field a failed row was rejected for, when it is about one.",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "row": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "created",
+                        "updated",
+                        "skipped",
+                        "failed"
+                    ]
+                }
+            }
+        },
+        "main.ImportSummary": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "integer"
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "on_duplicate": {
+                    "type": "string"
+                },
+                "rows": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.ImportRowResult"
+                    }
+                },
+                "skipped": {
+                    "type": "integer"
+                },
+                "updated": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.Item": {
+            "type": "object",
+            "properties": {
+                "category_id": {
+                    "type": "integer"
+                },
+                "deleted_at": {
+                    "type": "string"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.Job": {
+            "type": "object",
+            "properties": {
+                "attempts": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "last_error": {
+                    "type": "string"
+                },
+                "max_attempts": {
+                    "type": "integer"
+                },
+                "payload": {
+                    "type": "object"
+                },
+                "run_at": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "queued",
+                        "running",
+                        "succeeded",
+                        "dead"
+                    ]
+                },
+                "type": {
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.MergeRequest": {
+            "type": "object",
+            "properties": {
+                "source_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "main.Operation": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "method": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "report_url": {
+                    "description": "ReportURL links the rows an import rejected, once it has run.",
+                    "type": "string"
+                },
+                "response": {
+                    "$ref": "#/definitions/main.OperationResponse"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "pending",
+                        "running",
+                        "succeeded",
+                        "failed"
+                    ]
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.OperationResponse": {
+            "type": "object",
+            "properties": {
+                "body": {
+                    "type": "object"
+                },
+                "error_code": {
+                    "$ref": "#/definitions/main.ErrorCode"
+                },
+                "status": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.PatchOperation": {
+            "type": "object",
+            "properties": {
+                "from": {
+                    "type": "string"
+                },
+                "op": {
+                    "type": "string",
+                    "enum": [
+                        "add",
+                        "remove",
+                        "replace",
+                        "move",
+                        "copy",
+                        "test"
+                    ]
+                },
+                "path": {
+                    "type": "string"
+                },
+                "value": {
+                    "type": "object"
+                }
+            }
+        },
+        "main.Product": {
+            "type": "object",
+            "properties": {
+                "category_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "description": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.PurgeResult": {
+            "type": "object",
+            "properties": {
+                "categories": {
+                    "type": "integer"
+                },
+                "items": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.Recording": {
+            "type": "object",
+            "properties": {
+                "duration_ms": {
+                    "type": "number"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "method": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "recorded_at": {
+                    "type": "string"
+                },
+                "request_body": {
+                    "type": "string"
+                },
+                "request_header": {
+                    "type": "object"
+                },
+                "response_body": {
+                    "type": "string"
+                },
+                "response_header": {
+                    "type": "object"
+                },
+                "status": {
+                    "type": "integer"
+                },
+                "truncated": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "main.RenderedCategory": {
+            "type": "object",
+            "properties": {
+                "acl": {
+                    "$ref": "#/definitions/main.CategoryACL"
+                },
+                "attributes": {
+                    "type": "object"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "deleted_at": {
+                    "type": "string"
+                },
                 "description": {
                     "type": "string"
                 },
+                "description_html": {
+                    "type": "string"
+                },
                 "id": {
                     "type": "integer"
                 },
                 "name": {
                     "type": "string"
+                },
+                "position": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "active",
+                        "archived"
+                    ]
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.ReorderRequest": {
+            "type": "object",
+            "properties": {
+                "ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "main.RuntimeSetting": {
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "value": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.TagCount": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "type": "integer"
+                },
+                "tag": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.UpdateConflict": {
+            "type": "object",
+            "properties": {
+                "base_version": {
+                    "type": "integer"
+                },
+                "client": {
+                    "$ref": "#/definitions/main.Category"
+                },
+                "fields": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "message": {
+                    "type": "string"
+                },
+                "server": {
+                    "$ref": "#/definitions/main.Category"
+                }
+            }
+        },
+        "main.UsageReport": {
+            "type": "object",
+            "properties": {
+                "from": {
+                    "type": "string"
+                },
+                "resolution": {
+                    "type": "string"
+                },
+                "rows": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.UsageRow"
+                    }
+                },
+                "to": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.UsageRow": {
+            "type": "object",
+            "properties": {
+                "avg_bytes_out": {
+                    "type": "integer"
+                },
+                "bytes_in": {
+                    "type": "integer"
+                },
+                "bytes_out": {
+                    "type": "integer"
+                },
+                "client": {
+                    "type": "string"
+                },
+                "error_rate": {
+                    "type": "number"
+                },
+                "errors": {
+                    "type": "integer"
+                },
+                "method": {
+                    "type": "string"
+                },
+                "requests": {
+                    "type": "integer"
+                },
+                "route": {
+                    "type": "string"
+                },
+                "server_errors": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.VersionInfo": {
+            "type": "object",
+            "properties": {
+                "build_date": {
+                    "type": "string"
+                },
+                "commit": {
+                    "type": "string"
+                },
+                "go_version": {
+                    "type": "string"
+                },
+                "modified": {
+                    "type": "boolean"
+                },
+                "platform": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "string"
                 }
             }
         }
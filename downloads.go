@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =======================
+// ASYNC EXPORTS & SIGNED DOWNLOADS
+// =======================
+
+// ExportTask is the payload of an "export" job.
+type ExportTask struct {
+	Format   string `json:"format"`
+	Language string `json:"language,omitempty"` // Accept-Language of the request
+	Timezone string `json:"timezone,omitempty"`
+	// Principal limits the file to the categories its ACLs let them read.
+	Principal *Principal `json:"principal,omitempty"`
+	// Limit and Offset select the rows, like on GET /categories/export.
+	Limit  int `json:"limit,omitempty"`
+	Offset int `json:"offset,omitempty"`
+}
+
+// ExportStatus is an export job and, once it has succeeded, a signed link
+// to fetch the file.
+type ExportStatus struct {
+	Job         Job        `json:"job"`
+	DownloadURL string     `json:"download_url,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+var (
+	// downloadDir holds generated export files (DOWNLOAD_DIR). With several
+	// replicas it must be shared storage, since any replica may serve a link.
+	downloadDir = filepath.Join(os.TempDir(), "simple-crud-downloads")
+	// downloadURLTTL is how long a signed link stays valid (DOWNLOAD_URL_TTL);
+	// asking for the export again mints a fresh one.
+	downloadURLTTL = 15 * time.Minute
+	// downloadRetention is how long export files are kept (DOWNLOAD_RETENTION).
+	downloadRetention = 24 * time.Hour
+	// downloadSigningKey signs links (DOWNLOAD_SIGNING_KEY). Without it a
+	// random key is used, so links don't survive a restart or work across
+	// replicas.
+	downloadSigningKey []byte
+	// downloadMinFreeDisk is the free space, in bytes, below which the disk
+	// check fails readiness (DOWNLOAD_MIN_FREE_DISK); 0 disables the check.
+	downloadMinFreeDisk = 100 << 20
+)
+
+func init() {
+	registerJobHandler("export", exportJob)
+	registerScheduledTask("downloads_cleanup", "@hourly", cleanupDownloads)
+}
+
+// configureDownloads reads DOWNLOAD_SIGNING_KEY, or generates a key.
+func configureDownloads() {
+	downloadMinFreeDisk = envInt("DOWNLOAD_MIN_FREE_DISK", downloadMinFreeDisk)
+	if _, err := diskFree(os.TempDir()); downloadMinFreeDisk > 0 && err == nil {
+		registerHealthCheck("disk", checkDownloadDisk)
+	}
+
+	if key := os.Getenv("DOWNLOAD_SIGNING_KEY"); key != "" {
+		downloadSigningKey = []byte(key)
+		return
+	}
+	downloadSigningKey = make([]byte, 32)
+	if _, err := rand.Read(downloadSigningKey); err != nil {
+		log.Fatalf("download signing key: %v", err)
+	}
+}
+
+// checkDownloadDisk fails once exports could no longer be written.
+func checkDownloadDisk(ctx context.Context) error {
+	if err := os.MkdirAll(downloadDir, 0o755); err != nil {
+		return err
+	}
+	free, err := diskFree(downloadDir)
+	if err != nil {
+		return err
+	}
+	if free < uint64(downloadMinFreeDisk) {
+		return fmt.Errorf("%d bytes free in %s, want at least %d", free, downloadDir, downloadMinFreeDisk)
+	}
+	return nil
+}
+
+func exportFileName(jobID int, format exportFormat) string {
+	return fmt.Sprintf("export-%d.%s", jobID, format.Ext)
+}
+
+func exportJob(job *Job) error {
+	var task ExportTask
+	if err := json.Unmarshal(job.Payload, &task); err != nil {
+		return err
+	}
+	format, ok := exportFormats[task.Format]
+	if !ok {
+		return fmt.Errorf("unknown export format %q", task.Format)
+	}
+
+	l, err := lookupLocale(task.Language, task.Timezone)
+	if err != nil {
+		return err
+	}
+
+	storeMu.RLock()
+	page := Page{Limit: task.Limit, Offset: task.Offset}
+	if page.Limit == 0 {
+		page.Limit = maxExportRows
+	}
+	data, err := format.Write(paginate(readableCategories(task.Principal, sortedCategories()), page), l)
+	storeMu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(downloadDir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(downloadDir, exportFileName(job.ID, format))
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// cleanupDownloads removes export files and import reports older than
+// downloadRetention.
+func cleanupDownloads() error {
+	entries, err := os.ReadDir(downloadDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-downloadRetention)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !(strings.HasPrefix(e.Name(), "export-") || strings.HasPrefix(e.Name(), "import-report-")) || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(downloadDir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signDownload returns a token naming file and its expiry, with an HMAC over
+// both so neither can be altered.
+func signDownload(file string, expires time.Time) string {
+	payload := file + ":" + strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, downloadSigningKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyDownload checks a token and returns the file it names.
+func verifyDownload(token string, now time.Time) (string, bool) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return "", false
+	}
+	mac := hmac.New(sha256.New, downloadSigningKey)
+	mac.Write(payload)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return "", false
+	}
+
+	file, expiry, ok := strings.Cut(string(payload), ":")
+	if !ok || file != filepath.Base(file) {
+		return "", false
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || !now.Before(time.Unix(unix, 0)) {
+		return "", false
+	}
+	return file, true
+}
+
+// CreateExport godoc
+// @Summary Start an export
+// @Description Queues an export job and answers at once; poll GET /exports/{id} for a download link.
+// @Tags Category
+// @Produce json
+// @Param format query string false "File format (default csv)" Enums(csv, xlsx)
+// @Param limit query int false "Rows (default and at most EXPORT_MAX_ROWS, 10000)"
+// @Param offset query int false "Rows to skip"
+// @Param Accept-Language header string false "Writes dates in this language's layout (en, en-GB, de, fr, id); default ISO"
+// @Param X-Timezone header string false "IANA time zone for dates, e.g. Asia/Jakarta (default UTC)"
+// @Success 202 {object} ExportStatus
+// @Failure 400 {string} string
+// @Router /exports [post]
+func CreateExport(w http.ResponseWriter, r *http.Request) error {
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		name = "csv"
+	}
+	if _, ok := exportFormats[name]; !ok {
+		return &statusError{CodeValidationFailed, "format must be csv or xlsx"}
+	}
+	page, err := parsePage(r.URL.Query(), maxExportRows, maxExportRows)
+	if err != nil {
+		return err
+	}
+	task := ExportTask{
+		Format:    name,
+		Language:  r.Header.Get("Accept-Language"),
+		Timezone:  strings.TrimSpace(r.Header.Get("X-Timezone")),
+		Principal: requestPrincipal(r),
+		Limit:     page.Limit,
+		Offset:    page.Offset,
+	}
+	job, err := enqueueJob("export", task)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/exports/%d", job.ID))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	encodeJSON(w, ExportStatus{Job: *job})
+	return nil
+}
+
+// GetExport godoc
+// @Summary Get an export
+// @Description Returns the export job. Once it has succeeded the answer carries a signed download_url,
+// @Description valid for DOWNLOAD_URL_TTL; each call mints a new one. Only the caller who created the export
+// @Description can see it; anyone else gets 404.
+// @Tags Category
+// @Produce json
+// @Param id path int true "Export (job) ID"
+// @Success 200 {object} ExportStatus
+// @Failure 404 {string} string
+// @Router /exports/{id} [get]
+func GetExport(w http.ResponseWriter, r *http.Request) error {
+	jobsMu.Lock()
+	job, ok := jobList[parseIDAt(r.URL.Path, 1)]
+	var status ExportStatus
+	if ok {
+		status.Job = *job
+	}
+	jobsMu.Unlock()
+	if !ok || status.Job.Type != "export" {
+		return &statusError{CodeJobNotFound, "export not found"}
+	}
+	// The rows were filtered for whoever asked, and job IDs are sequential,
+	// so anyone else is told the export doesn't exist.
+	var task ExportTask
+	json.Unmarshal(status.Job.Payload, &task)
+	if !samePrincipal(task.Principal, requestPrincipal(r)) {
+		return &statusError{CodeJobNotFound, "export not found"}
+	}
+
+	if status.Job.Status == JobSucceeded {
+		expires := time.Now().Add(downloadURLTTL).UTC().Truncate(time.Second)
+		status.DownloadURL = "/downloads/" + signDownload(exportFileName(status.Job.ID, exportFormats[task.Format]), expires)
+		status.ExpiresAt = &expires
+	}
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, status)
+	return nil
+}
+
+// Download godoc
+// @Summary Download an export
+// @Description Serves the file behind a signed link from GET /exports/{id}. Supports Range requests, so an interrupted
+// @Description download can resume: send Range: bytes=<received>- with If-Range set to the ETag of the first response.
+// @Description An expired link can be replaced by calling GET /exports/{id} again; it points at the same file.
+// @Tags Category
+// @Produce octet-stream
+// @Param token path string true "Signed token"
+// @Param Range header string false "Byte range(s) to fetch, e.g. bytes=1048576-"
+// @Param If-Range header string false "ETag or Last-Modified of the partial copy; a changed file is sent whole"
+// @Success 200 {file} file
+// @Success 206 {file} file
+// @Failure 403 {string} string
+// @Failure 410 {string} string
+// @Failure 416 {string} string
+// @Router /downloads/{token} [get]
+func Download(w http.ResponseWriter, r *http.Request) error {
+	file, ok := verifyDownload(strings.TrimPrefix(r.URL.Path, "/downloads/"), time.Now())
+	if !ok {
+		return &statusError{CodeDownloadInvalid, "download link is invalid or has expired"}
+	}
+	f, err := os.Open(filepath.Join(downloadDir, file))
+	if os.IsNotExist(err) {
+		return &statusError{CodeDownloadGone, "export file has been removed"}
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	for _, format := range exportFormats {
+		if strings.HasSuffix(file, "."+format.Ext) {
+			w.Header().Set("Content-Type", format.ContentType)
+		}
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="categories-%s%s"`, info.ModTime().UTC().Format("20060102"), filepath.Ext(file)))
+	w.Header().Set("Cache-Control", "private, no-store")
+	// Export files never change once written, but a strong validator lets
+	// If-Range tell a resumed download apart from a re-run that reused the
+	// name, which Last-Modified's one-second resolution can't.
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	http.ServeContent(w, r, file, info.ModTime(), f)
+	return nil
+}
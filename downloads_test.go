@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyDownload(t *testing.T) {
+	downloadSigningKey = []byte("test-key")
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	valid := signDownload("export-1.csv", now.Add(time.Minute))
+	encoded, sig, _ := strings.Cut(valid, ".")
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", valid, true},
+		{"expired", signDownload("export-1.csv", now), false},
+		{"no signature", encoded, false},
+		{"other payload", signDownload("export-2.csv", now.Add(time.Minute))[:len(encoded)] + "." + sig, false},
+		{"bad signature", encoded + "." + strings.Repeat("A", len(sig)), false},
+		{"path in file", signDownload("../export-1.csv", now.Add(time.Minute)), false},
+	}
+	for _, tt := range tests {
+		file, ok := verifyDownload(tt.token, now)
+		if ok != tt.ok {
+			t.Errorf("%s: verifyDownload ok = %v, want %v", tt.name, ok, tt.ok)
+		}
+		if ok && file != "export-1.csv" {
+			t.Errorf("%s: verifyDownload file = %q, want export-1.csv", tt.name, file)
+		}
+	}
+
+	downloadSigningKey = []byte("other-key")
+	if _, ok := verifyDownload(valid, now); ok {
+		t.Error("verifyDownload accepted a token signed with another key")
+	}
+}
+
+func TestGetExportOnlyForCreator(t *testing.T) {
+	downloadSigningKey = []byte("test-key")
+	payload, _ := json.Marshal(ExportTask{Format: "csv", Principal: &Principal{User: "alice"}})
+	jobsMu.Lock()
+	saved := jobList
+	jobList = map[int]*Job{1: {ID: 1, Type: "export", Payload: payload, Status: JobSucceeded}}
+	jobsMu.Unlock()
+	t.Cleanup(func() {
+		jobsMu.Lock()
+		jobList = saved
+		jobsMu.Unlock()
+	})
+
+	tests := []struct {
+		caller *Principal
+		found  bool
+	}{
+		{&Principal{User: "alice"}, true},
+		{&Principal{User: "alice", Roles: []string{"ops"}}, true},
+		{&Principal{User: "bob"}, false},
+		{&Principal{Roles: []string{"ops"}}, false},
+		{&Principal{}, false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := withPrincipal(httptest.NewRequest("GET", "/exports/1", nil), tt.caller)
+		err := GetExport(w, r)
+		if !tt.found {
+			if se, ok := err.(*statusError); !ok || se.code != CodeJobNotFound {
+				t.Errorf("caller %+v: GetExport error = %v, want %s", *tt.caller, err, CodeJobNotFound)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("caller %+v: GetExport error = %v", *tt.caller, err)
+			continue
+		}
+		var status ExportStatus
+		json.Unmarshal(w.Body.Bytes(), &status)
+		if !strings.HasPrefix(status.DownloadURL, "/downloads/") {
+			t.Errorf("caller %+v: download_url = %q, want a signed link", *tt.caller, status.DownloadURL)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// =======================
+// EMAIL
+// =======================
+
+// EmailMessage is the payload of an "email" job.
+type EmailMessage struct {
+	To      []string `json:"to"`
+	Subject string   `json:"subject"`
+	Body    string   `json:"body"`
+}
+
+// smtpConfig is read from SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD
+// and SMTP_FROM.
+type smtpConfig struct {
+	host, port, username, password, from string
+}
+
+// emailNotifier emails NOTIFY_EMAILS about the events listed in
+// NOTIFY_EMAIL_EVENTS.
+type emailNotifier struct {
+	to     []string
+	events map[string]bool
+}
+
+var smtpSettings smtpConfig
+
+func init() {
+	registerJobHandler("email", sendEmailJob)
+}
+
+// configureEmail enables the email notifier when SMTP and recipients are set.
+func configureEmail() {
+	smtpSettings = smtpConfig{
+		host:     os.Getenv("SMTP_HOST"),
+		port:     os.Getenv("SMTP_PORT"),
+		username: os.Getenv("SMTP_USERNAME"),
+		password: os.Getenv("SMTP_PASSWORD"),
+		from:     os.Getenv("SMTP_FROM"),
+	}
+	if smtpSettings.port == "" {
+		smtpSettings.port = "587"
+	}
+	to := splitList(os.Getenv("NOTIFY_EMAILS"))
+	if smtpSettings.host == "" || len(to) == 0 {
+		return
+	}
+	if smtpSettings.from == "" {
+		log.Fatal("SMTP_FROM is required when SMTP_HOST and NOTIFY_EMAILS are set")
+	}
+
+	events := map[string]bool{}
+	names := splitList(os.Getenv("NOTIFY_EMAIL_EVENTS"))
+	if len(names) == 0 {
+		names = []string{EventCategoryDeleted, EventPurgeCompleted}
+	}
+	for _, e := range names {
+		if _, ok := eventTemplates[e]; !ok {
+			log.Fatalf("invalid NOTIFY_EMAIL_EVENTS: unknown event %q", e)
+		}
+		events[e] = true
+	}
+
+	notifiers = append(notifiers, emailNotifier{to: to, events: events})
+}
+
+func (n emailNotifier) Notify(event string, data map[string]interface{}) {
+	if !n.events[event] {
+		return
+	}
+	subject, body, err := renderEvent(event, data)
+	if err != nil {
+		log.Printf("email: %v", err)
+		return
+	}
+
+	msg := EmailMessage{To: n.to, Subject: subject, Body: body}
+	if _, err := enqueueJob("email", msg); err != nil {
+		log.Printf("email: %v", err)
+	}
+}
+
+func sendEmailJob(job *Job) error {
+	var msg EmailMessage
+	if err := json.Unmarshal(job.Payload, &msg); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", smtpSettings.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if smtpSettings.username != "" {
+		auth = smtp.PlainAuth("", smtpSettings.username, smtpSettings.password, smtpSettings.host)
+	}
+	addr := net.JoinHostPort(smtpSettings.host, smtpSettings.port)
+	return smtp.SendMail(addr, auth, smtpSettings.from, msg.To, buf.Bytes())
+}
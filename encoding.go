@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// =======================
+// CONTENT NEGOTIATION
+// =======================
+
+// wireCodec converts between JSON values (as decoded with UseNumber) and a
+// binary encoding. Handlers keep speaking JSON; negotiateEncoding transcodes
+// request and response bodies whose media type has a codec.
+type wireCodec struct {
+	Encode func(route string, v interface{}) ([]byte, error)
+	Decode func(route string, data []byte) (interface{}, error)
+	// Omits are the JSON fields the encoding can't carry. A body decoded
+	// from it leaves them out, which handlers must not read as a request
+	// to clear them.
+	Omits []string
+	// Schema is set when field names come from a schema rather than the
+	// JSON keys, so renaming the keys would lose the fields.
+	Schema bool
+}
+
+// omittedFieldsKey carries the Omits of the codec a request body came in.
+type omittedFieldsKey struct{}
+
+// bodyOmits reports whether the encoding of the request body can't carry
+// field, so its absence says nothing.
+func bodyOmits(r *http.Request, field string) bool {
+	omits, _ := r.Context().Value(omittedFieldsKey{}).([]string)
+	return containsString(omits, field)
+}
+
+// errCodecRoute is returned by a codec that has no schema for a route.
+var errCodecRoute = errors.New("encoding not available for this endpoint")
+
+// wireCodecs maps a media type to its codec.
+var wireCodecs = map[string]wireCodec{}
+
+// maxTranscodedBody bounds request bodies read for transcoding.
+const maxTranscodedBody = 8 << 20
+
+// negotiateEncoding lets clients send and receive a binary encoding instead
+// of JSON: a request body whose Content-Type has a codec is turned into JSON
+// before the handler sees it, and a JSON response is re-encoded when the
+// Accept header lists a codec before any JSON or wildcard entry.
+func negotiateEncoding(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeLabel(r.URL.Path)
+		w.Header().Add("Vary", "Accept")
+
+		if mediaType, codec, ok := requestCodec(r); ok {
+			data, err := io.ReadAll(io.LimitReader(r.Body, maxTranscodedBody))
+			if err != nil {
+				writeAPIError(w, CodeInvalidJSON, err.Error())
+				return
+			}
+			v, err := codec.Decode(route, data)
+			if errors.Is(err, errCodecRoute) {
+				writeAPIError(w, CodeUnsupportedMediaType, mediaType+" is not accepted by this endpoint")
+				return
+			}
+			if err != nil {
+				writeAPIError(w, CodeInvalidJSON, "invalid "+mediaType+" body: "+err.Error())
+				return
+			}
+			body, _ := json.Marshal(v)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			r.Header.Set("Content-Type", "application/json")
+			if len(codec.Omits) > 0 {
+				r = r.WithContext(context.WithValue(r.Context(), omittedFieldsKey{}, codec.Omits))
+			}
+		}
+
+		mediaType, codec, fallback := responseCodec(r.Header.Get("Accept"))
+		if mediaType == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &bufferedResponse{header: w.Header().Clone()}
+		next.ServeHTTP(rec, r)
+
+		status := rec.statusOrOK()
+		if status < 200 || status >= 300 || !strings.HasPrefix(rec.header.Get("Content-Type"), "application/json") || rec.body.Len() == 0 {
+			rec.copyTo(w, rec.body.Bytes())
+			return
+		}
+		raw := rec.body.Bytes()
+		var v interface{}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		err := dec.Decode(&v)
+		var out []byte
+		if err == nil {
+			out, err = codec.Encode(route, v)
+		}
+		switch {
+		case errors.Is(err, errCodecRoute) && fallback:
+			rec.copyTo(w, raw)
+		case errors.Is(err, errCodecRoute):
+			writeAPIError(w, CodeNotAcceptable, mediaType+" is not available for this endpoint")
+		case err != nil:
+			writeError(w, err)
+		default:
+			rec.header.Set("Content-Type", mediaType)
+			rec.copyTo(w, out)
+		}
+	})
+}
+
+func requestCodec(r *http.Request) (string, wireCodec, bool) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return "", wireCodec{}, false
+	}
+	codec, ok := wireCodecs[mediaType]
+	return mediaType, codec, ok
+}
+
+// responseCodec picks the first codec listed in accept, ignoring q-values
+// other than q=0. fallback reports whether JSON is acceptable as well.
+func responseCodec(accept string) (mediaType string, codec wireCodec, fallback bool) {
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		switch mt {
+		case "application/json", "application/*", "*/*":
+			if mediaType == "" {
+				return "", wireCodec{}, true
+			}
+			fallback = true
+			continue
+		}
+		if c, ok := wireCodecs[mt]; ok && mediaType == "" {
+			mediaType, codec = mt, c
+		}
+	}
+	return mediaType, codec, fallback
+}
+
+// bufferedResponse holds a handler's response so it can be re-encoded.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) statusOrOK() int {
+	if b.status == 0 {
+		return http.StatusOK
+	}
+	return b.status
+}
+
+// copyTo sends the buffered headers and status with body.
+func (b *bufferedResponse) copyTo(w http.ResponseWriter, body []byte) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	if w.Header().Get("Content-Length") != "" {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	w.WriteHeader(b.statusOrOK())
+	w.Write(body)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net/http"
+)
+
+// =======================
+// ERROR CODES
+// =======================
+
+// ErrorCode is a stable, machine-readable name for a kind of failure. It is
+// sent in the X-Error-Code header of every error response, so clients can
+// branch on it rather than on the message text, which may change.
+type ErrorCode string
+
+const (
+	CodeInvalidJSON          ErrorCode = "INVALID_JSON"
+	CodeValidationFailed     ErrorCode = "VALIDATION_FAILED"
+	CodeRouteNotFound        ErrorCode = "ROUTE_NOT_FOUND"
+	CodeMethodNotAllowed     ErrorCode = "METHOD_NOT_ALLOWED"
+	CodeNotAcceptable        ErrorCode = "NOT_ACCEPTABLE"
+	CodeUnsupportedMediaType ErrorCode = "UNSUPPORTED_MEDIA_TYPE"
+	CodePayloadTooLarge      ErrorCode = "PAYLOAD_TOO_LARGE"
+	CodeRecordingNotFound    ErrorCode = "RECORDING_NOT_FOUND"
+	CodeRateLimited          ErrorCode = "RATE_LIMITED"
+	CodeCategoryNotFound     ErrorCode = "CATEGORY_NOT_FOUND"
+	CodeItemNotFound         ErrorCode = "ITEM_NOT_FOUND"
+	CodeProductNotFound      ErrorCode = "PRODUCT_NOT_FOUND"
+	CodeProductNotLinked     ErrorCode = "PRODUCT_NOT_LINKED"
+	CodeJobNotFound          ErrorCode = "JOB_NOT_FOUND"
+	CodeImportReportNotFound ErrorCode = "IMPORT_REPORT_NOT_FOUND"
+	CodeRevisionNotFound     ErrorCode = "REVISION_NOT_FOUND"
+	CodeFieldNotFound        ErrorCode = "FIELD_NOT_FOUND"
+	CodeCategoryHasProducts  ErrorCode = "CATEGORY_HAS_PRODUCTS"
+	CodeCategoryArchived     ErrorCode = "CATEGORY_ARCHIVED"
+	CodeInvalidTransition    ErrorCode = "INVALID_TRANSITION"
+	CodeVersionConflict      ErrorCode = "VERSION_CONFLICT"
+	CodePatchTestFailed      ErrorCode = "PATCH_TEST_FAILED"
+	CodeJobNotRetryable      ErrorCode = "JOB_NOT_RETRYABLE"
+	CodeChangesExpired       ErrorCode = "CHANGES_EXPIRED"
+	CodePreconditionFailed   ErrorCode = "PRECONDITION_FAILED"
+	CodePreconditionRequired ErrorCode = "PRECONDITION_REQUIRED"
+	CodeNotReady             ErrorCode = "NOT_READY"
+	CodeRequestTimeout       ErrorCode = "REQUEST_TIMEOUT"
+	CodeDownloadInvalid      ErrorCode = "DOWNLOAD_LINK_INVALID"
+	CodeClientNotAllowed     ErrorCode = "CLIENT_NOT_ALLOWED"
+	CodeAccessDenied         ErrorCode = "ACCESS_DENIED"
+	CodeDownloadGone         ErrorCode = "DOWNLOAD_GONE"
+	CodeSearchUnavailable    ErrorCode = "SEARCH_UNAVAILABLE"
+	CodeBackendUnavailable   ErrorCode = "BACKEND_UNAVAILABLE"
+	CodeReadOnly             ErrorCode = "READ_ONLY"
+	CodeFaultInjected        ErrorCode = "FAULT_INJECTED"
+	CodeSearchNotConfigured  ErrorCode = "SEARCH_NOT_CONFIGURED"
+	CodeInternal             ErrorCode = "INTERNAL"
+)
+
+// ErrorInfo describes one error code in the catalog.
+type ErrorInfo struct {
+	Code        ErrorCode `json:"code"`
+	Status      int       `json:"status"`
+	Description string    `json:"description"`
+}
+
+// errorCatalog lists every code the API can return. Codes are never renamed
+// or reused; retired ones stay listed.
+var errorCatalog = []ErrorInfo{
+	{CodeInvalidJSON, http.StatusBadRequest, "The request body is not valid JSON (or the binary encoding it was sent in) for this endpoint."},
+	{CodeValidationFailed, http.StatusBadRequest, "A field or query parameter has an invalid value."},
+	{CodeRouteNotFound, http.StatusNotFound, "No such endpoint."},
+	{CodeMethodNotAllowed, http.StatusMethodNotAllowed, "The endpoint does not support this method."},
+	{CodeNotAcceptable, http.StatusNotAcceptable, "None of the media types in Accept can be produced by this endpoint."},
+	{CodeUnsupportedMediaType, http.StatusUnsupportedMediaType, "The endpoint can't read a body of this Content-Type or Content-Encoding."},
+	{CodePayloadTooLarge, http.StatusRequestEntityTooLarge, "The request body, once decompressed, exceeds MAX_DECOMPRESSED_BODY."},
+	{CodeCategoryNotFound, http.StatusNotFound, "The category does not exist or was deleted."},
+	{CodeItemNotFound, http.StatusNotFound, "The item does not exist or was deleted."},
+	{CodeProductNotFound, http.StatusNotFound, "The product does not exist."},
+	{CodeProductNotLinked, http.StatusNotFound, "The product is not linked to this category."},
+	{CodeJobNotFound, http.StatusNotFound, "The background job does not exist."},
+	{CodeImportReportNotFound, http.StatusNotFound, "The operation is not a finished import, or its report was removed after DOWNLOAD_RETENTION."},
+	{CodeRevisionNotFound, http.StatusNotFound, "The category has no such revision, or it is older than HISTORY_LIMIT."},
+	{CodeFieldNotFound, http.StatusNotFound, "No custom field is defined with this name."},
+	{CodeRateLimited, http.StatusTooManyRequests, "The client sent more requests than RATE_LIMIT allows; retry after Retry-After seconds."},
+	{CodeRecordingNotFound, http.StatusNotFound, "No recording with this ID is kept; it may have been pushed out of the buffer."},
+	{CodeCategoryHasProducts, http.StatusConflict, "The category still has products and CATEGORY_DELETE_MODE=block."},
+	{CodeCategoryArchived, http.StatusConflict, "The category is archived and can't be changed this way."},
+	{CodeInvalidTransition, http.StatusConflict, "The category is already in the requested status."},
+	{CodeVersionConflict, http.StatusConflict, "The update conflicts with changes made since the submitted version."},
+	{CodePatchTestFailed, http.StatusConflict, "A JSON Patch test operation found a different value; the category changed since it was read."},
+	{CodeJobNotRetryable, http.StatusConflict, "Only dead jobs can be retried."},
+	{CodeChangesExpired, http.StatusGone, "The requested changes are older than the retained changelog."},
+	{CodePreconditionFailed, http.StatusPreconditionFailed, "If-Match or If-Unmodified-Since no longer holds."},
+	{CodePreconditionRequired, http.StatusPreconditionRequired, "A precondition header is required (REQUIRE_PRECONDITIONS=true)."},
+	{CodeNotReady, http.StatusServiceUnavailable, "The instance is starting up or shutting down."},
+	{CodeRequestTimeout, http.StatusServiceUnavailable, "The request ran past REQUEST_TIMEOUT (or its route's override) and was abandoned; retry later."},
+	{CodeDownloadInvalid, http.StatusForbidden, "The download link was altered or has expired; ask GET /exports/{id} for a new one."},
+	{CodeClientNotAllowed, http.StatusForbidden, "The client certificate is valid but not in MTLS_ALLOWED_IDENTITIES."},
+	{CodeAccessDenied, http.StatusForbidden, "The category's ACL lets the caller read it but not change it."},
+	{CodeDownloadGone, http.StatusGone, "The export file was removed after DOWNLOAD_RETENTION; start a new export."},
+	{CodeSearchUnavailable, http.StatusBadGateway, "The search cluster could not be reached or returned an error."},
+	{CodeBackendUnavailable, http.StatusServiceUnavailable, "A backend failed repeatedly and is not being called for now; retry after Retry-After seconds."},
+	{CodeReadOnly, http.StatusServiceUnavailable, "The service is in read-only mode (READ_ONLY) and rejects changes; reads still work."},
+	{CodeFaultInjected, http.StatusServiceUnavailable, "Fault injection (CHAOS_ENABLED) failed the request on purpose; retry after Retry-After seconds."},
+	{CodeSearchNotConfigured, http.StatusNotImplemented, "Search indexing is not enabled (SEARCH_URL)."},
+	{CodeInternal, http.StatusInternalServerError, "Unexpected server error."},
+}
+
+// errorStatus is filled by variable initialization rather than init so
+// registerErrorCode can be called from init in any file.
+var errorStatus = func() map[ErrorCode]int {
+	m := map[ErrorCode]int{}
+	for _, info := range errorCatalog {
+		m[info.Code] = info.Status
+	}
+	return m
+}()
+
+// registerErrorCode adds a code to the catalog, for resources defined
+// outside this file.
+func registerErrorCode(info ErrorInfo) {
+	errorCatalog = append(errorCatalog, info)
+	errorStatus[info.Code] = info.Status
+}
+
+// writeAPIError answers with the status registered for code and msg as a
+// plain-text body.
+func writeAPIError(w http.ResponseWriter, code ErrorCode, msg string) {
+	status, ok := errorStatus[code]
+	if !ok {
+		code, status = CodeInternal, http.StatusInternalServerError
+	}
+	w.Header().Set("X-Error-Code", string(code))
+	http.Error(w, msg, status)
+}
+
+// errRouteNotFound is what routers return for paths and methods they don't serve.
+var errRouteNotFound = &statusError{CodeRouteNotFound, "404 page not found"}
+
+// apiHandler is a handler that reports failure by returning an error rather
+// than writing the response itself.
+type apiHandler func(w http.ResponseWriter, r *http.Request) error
+
+// handle adapts an apiHandler for http.HandleFunc, answering any returned
+// error through writeError. A handler must not write a response and then
+// return an error.
+func handle(h apiHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h(w, r); err != nil {
+			writeError(w, err)
+		}
+	}
+}
+
+// GetErrors godoc
+// @Summary Error code catalog
+// @Description Every error response carries one of these codes in its X-Error-Code header.
+// @Tags Errors
+// @Produce json
+// @Success 200 {array} ErrorInfo
+// @Router /errors [get]
+func GetErrors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, errorCatalog)
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// =======================
+// MODEL
+// =======================
+
+// Change event types.
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted"
+)
+
+// ChangeEvent describes one committed mutation. Seq is strictly increasing,
+// so consumers can dedupe and resume.
+type ChangeEvent struct {
+	Seq        int64       `json:"seq"`
+	Op         string      `json:"op" enums:"created,updated,deleted"`
+	Resource   string      `json:"resource"`
+	ResourceID int         `json:"resource_id"`
+	Data       interface{} `json:"data,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+}
+
+// ChangesPage is one page of the changelog. Pass LastSeq back as since to
+// get the next page.
+type ChangesPage struct {
+	Changes []ChangeEvent `json:"changes"`
+	LastSeq int64         `json:"last_seq"`
+	HasMore bool          `json:"has_more"`
+}
+
+// =======================
+// OUTBOX
+// =======================
+
+// The outbox lives in the store and is only written while storeMu is held,
+// i.e. in the same critical section as the mutation it describes. An event
+// therefore exists if and only if its change does, and the relay below
+// delivers it afterwards.
+var (
+	outbox    = []ChangeEvent{}
+	changeSeq int64
+
+	eventSubscribersMu sync.Mutex
+	eventSubscribers   = []func(ChangeEvent){}
+	outboxSignal       = make(chan struct{}, 1)
+
+	// outboxPollInterval bounds delivery latency if a signal is missed.
+	outboxPollInterval = time.Second
+	// outboxMaxPending is the backlog at which the event bus check fails
+	// readiness (OUTBOX_MAX_PENDING): subscribers aren't keeping up.
+	outboxMaxPending = 1000
+
+	// changelog keeps the newest changelogSize events for delta sync
+	// (CHANGELOG_SIZE). It is written together with the outbox.
+	changelog     = []ChangeEvent{}
+	changelogSize = 10000
+)
+
+func init() {
+	registerMetric("outbox_pending", "gauge", "Change events written but not yet relayed.")
+	registerMetric("events_relayed_total", "counter", "Change events delivered to subscribers.")
+	registerShutdownHook("events", nil, flushOutbox)
+	registerHealthCheck("event_bus", checkOutbox)
+}
+
+// recordCategoryChange appends a category event to the outbox and a
+// revision to the category's history. Callers must hold storeMu for writing.
+func recordCategoryChange(op string, c *Category) {
+	snapshot := *c
+	snapshot.Tags = append([]string{}, c.Tags...)
+	recordRevision(recordChange(op, "category", c.ID, snapshot), snapshot)
+}
+
+// recordChange appends an event to the outbox. Callers must hold storeMu
+// for writing.
+func recordChange(op, resource string, id int, data interface{}) ChangeEvent {
+	changeSeq++
+	event := ChangeEvent{
+		Seq:        changeSeq,
+		Op:         op,
+		Resource:   resource,
+		ResourceID: id,
+		Data:       data,
+		CreatedAt:  time.Now().UTC(),
+	}
+	outbox = append(outbox, event)
+	changelog = append(changelog, event)
+	if len(changelog) > changelogSize {
+		changelog = changelog[len(changelog)-changelogSize:]
+	}
+	// Validators and long-polls only learn of the change once it commits.
+	afterCommit(func() {
+		storeModified = event.CreatedAt
+		select {
+		case outboxSignal <- struct{}{}:
+		default:
+		}
+		close(changesWake)
+		changesWake = make(chan struct{})
+	})
+	return event
+}
+
+// =======================
+// EVENT BUS
+// =======================
+
+// subscribeEvents registers fn to receive every relayed change event in
+// order. fn runs on the relay goroutine and should return quickly.
+func subscribeEvents(fn func(ChangeEvent)) {
+	eventSubscribersMu.Lock()
+	defer eventSubscribersMu.Unlock()
+	eventSubscribers = append(eventSubscribers, fn)
+}
+
+// runOutboxRelay drains the outbox to subscribers. Events are removed only
+// after delivery, so a subscriber panic leaves them queued for the next pass.
+func runOutboxRelay() {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-outboxSignal:
+		case <-ticker.C:
+		}
+		relayOutbox()
+	}
+}
+
+// flushOutbox relays what the last writes left in the outbox, so subscribers
+// don't miss the final changes of a shutting-down instance.
+func flushOutbox(ctx context.Context) error {
+	relayOutbox()
+	storeMu.RLock()
+	pending := len(outbox)
+	storeMu.RUnlock()
+	if pending > 0 {
+		return fmt.Errorf("%d events not relayed", pending)
+	}
+	return nil
+}
+
+func checkOutbox(ctx context.Context) error {
+	storeMu.RLock()
+	pending := len(outbox)
+	storeMu.RUnlock()
+	if pending > outboxMaxPending {
+		return fmt.Errorf("%d events waiting to be relayed", pending)
+	}
+	return nil
+}
+
+func relayOutbox() {
+	storeMu.RLock()
+	pending := append([]ChangeEvent{}, outbox...)
+	storeMu.RUnlock()
+	if len(pending) == 0 {
+		setMetric("outbox_pending", 0)
+		return
+	}
+
+	eventSubscribersMu.Lock()
+	subs := append([]func(ChangeEvent){}, eventSubscribers...)
+	eventSubscribersMu.Unlock()
+
+	delivered := 0
+	func() {
+		defer func() {
+			if err := recover(); err != nil {
+				log.Printf("outbox: subscriber panic at seq %d: %v", pending[delivered].Seq, err)
+			}
+		}()
+		for _, e := range pending {
+			for _, fn := range subs {
+				fn(e)
+			}
+			delivered++
+		}
+	}()
+
+	storeMu.Lock()
+	outbox = outbox[delivered:]
+	setMetric("outbox_pending", float64(len(outbox)))
+	storeMu.Unlock()
+	addMetric("events_relayed_total", float64(delivered))
+}
+
+// =======================
+// HANDLER
+// =======================
+
+const (
+	defaultChangesLimit = 100
+	maxChangesLimit     = 1000
+	// maxChangesWait caps how long ?wait= holds a request open.
+	maxChangesWait = time.Minute
+)
+
+var (
+	// changesWake is closed, and replaced, by every change, waking the
+	// long-polling requests of GET /categories/changes. Guarded by storeMu.
+	changesWake = make(chan struct{})
+	// longPollsEnded is closed when the server starts shutting down, so
+	// waiting requests answer at once instead of holding up the drain.
+	longPollsEnded = make(chan struct{})
+	endLongPolls   = sync.OnceFunc(func() { close(longPollsEnded) })
+)
+
+// GetCategoryChanges godoc
+// @Summary Get category changes
+// @Description Returns create/update/delete operations after since, oldest first, for incremental sync.
+// @Description since is either a sequence number (last_seq of the previous page) or an RFC 3339 timestamp.
+// @Description 410 means since is older than the retained changelog and the client must re-fetch everything.
+// @Description wait long-polls: with nothing new after since, the request is held until a change arrives or the
+// @Description wait is over, then answers as usual (an empty page after a timeout). It is cut short to stay
+// @Description within the request timeout.
+// @Tags Category
+// @Produce json
+// @Param since query string false "Sequence number or RFC 3339 timestamp (default: from the beginning)"
+// @Param limit query int false "Maximum number of changes (default 100, max 1000)"
+// @Param wait query string false "Hold the request up to this long for new changes, e.g. 30s (max 1m)"
+// @Success 200 {object} ChangesPage
+// @Failure 400 {string} string
+// @Failure 410 {string} string
+// @Router /categories/changes [get]
+func GetCategoryChanges(w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+
+	limit := defaultChangesLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxChangesLimit {
+			return &statusError{CodeValidationFailed, fmt.Sprintf("limit must be between 1 and %d", maxChangesLimit)}
+		}
+		limit = n
+	}
+
+	var sinceSeq int64
+	var sinceTime time.Time
+	if v := q.Get("since"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			sinceSeq = n
+		} else if t, err := time.Parse(time.RFC3339, v); err == nil {
+			sinceTime = t
+		} else {
+			return &statusError{CodeValidationFailed, "since must be a sequence number or an RFC 3339 timestamp"}
+		}
+	}
+
+	var wait time.Duration
+	if v := q.Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 || d > maxChangesWait {
+			return &statusError{CodeValidationFailed, fmt.Sprintf("wait must be a duration such as 30s, at most %s", maxChangesWait)}
+		}
+		wait = d
+	}
+	// Answer a second before the request timeout would.
+	ctx := r.Context()
+	if deadline, ok := ctx.Deadline(); ok {
+		wait = max(0, min(wait, time.Until(deadline)-time.Second))
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	p := requestPrincipal(r)
+	waiting := wait > 0
+	for {
+		storeMu.RLock()
+		page, err := changesSince(sinceSeq, sinceTime, limit, p)
+		wake := changesWake
+		storeMu.RUnlock()
+		if err != nil {
+			return err
+		}
+		if len(page.Changes) > 0 || !waiting {
+			w.Header().Set("Content-Type", "application/json")
+			encodeJSON(w, page)
+			return nil
+		}
+
+		select {
+		case <-wake:
+		case <-timer.C:
+			waiting = false
+		case <-longPollsEnded:
+			waiting = false
+		case <-ctx.Done():
+			return nil // the client went away
+		}
+	}
+}
+
+// changesSince builds the page of changes after sinceSeq or sinceTime that
+// p may see. Callers hold storeMu.
+func changesSince(sinceSeq int64, sinceTime time.Time, limit int, p *Principal) (ChangesPage, error) {
+	if len(changelog) > 0 && changelog[0].Seq > 1 {
+		oldest := changelog[0]
+		if (sinceTime.IsZero() && sinceSeq < oldest.Seq-1) || (!sinceTime.IsZero() && sinceTime.Before(oldest.CreatedAt)) {
+			return ChangesPage{}, &statusError{CodeChangesExpired, "changes before that point are no longer available; re-fetch all categories"}
+		}
+	}
+
+	page := ChangesPage{Changes: []ChangeEvent{}, LastSeq: sinceSeq}
+	for _, e := range changelog {
+		if e.Seq <= sinceSeq || (!sinceTime.IsZero() && !e.CreatedAt.After(sinceTime)) {
+			continue
+		}
+		if !canReadChange(p, e) {
+			page.LastSeq = e.Seq
+			continue
+		}
+		if len(page.Changes) == limit {
+			page.HasMore = true
+			break
+		}
+		page.Changes = append(page.Changes, e)
+		page.LastSeq = e.Seq
+	}
+	if len(page.Changes) == 0 && !sinceTime.IsZero() {
+		page.LastSeq = changeSeq
+	}
+	return page, nil
+}
+
+// canReadChange checks a category event against the ACL the category had
+// then, or, for an event without a snapshot, the one it has now.
+func canReadChange(p *Principal, e ChangeEvent) bool {
+	if snapshot, ok := e.Data.(Category); ok {
+		return canReadCategory(p, &snapshot)
+	}
+	return e.Resource != "category" || canReadCategoryID(p, e.ResourceID)
+}
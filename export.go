@@ -0,0 +1,378 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// =======================
+// EXPORT
+// =======================
+
+// exportColumn is one column of an export, shared by every format so CSV
+// and Excel files always have the same layout.
+type exportColumn struct {
+	Header  string
+	Numeric bool
+	Value   func(c *Category, l *Locale) string
+}
+
+var exportColumns = []exportColumn{
+	{"ID", true, func(c *Category, _ *Locale) string { return strconv.Itoa(c.ID) }},
+	{"Name", false, func(c *Category, _ *Locale) string { return c.Name }},
+	{"Description", false, func(c *Category, _ *Locale) string { return c.Description }},
+	{"Tags", false, func(c *Category, _ *Locale) string { return strings.Join(c.Tags, ", ") }},
+	{"Attributes", false, func(c *Category, _ *Locale) string {
+		if len(c.Attributes) == 0 {
+			return ""
+		}
+		b, _ := json.Marshal(c.Attributes)
+		return string(b)
+	}},
+	{"Position", true, func(c *Category, _ *Locale) string { return strconv.Itoa(c.Position) }},
+	{"Status", false, func(c *Category, _ *Locale) string { return c.Status }},
+	{"Version", true, func(c *Category, _ *Locale) string { return strconv.Itoa(c.Version) }},
+	{"Created at", false, func(c *Category, l *Locale) string { return l.exportTime(c.CreatedAt) }},
+	{"Updated at", false, func(c *Category, l *Locale) string { return l.exportTime(c.UpdatedAt) }},
+}
+
+// exportFormat renders a list of categories into one file type.
+type exportFormat struct {
+	ContentType string
+	Ext         string
+	Write       func(cats []*Category, l *Locale) ([]byte, error)
+}
+
+var exportFormats = map[string]exportFormat{
+	"csv":  {"text/csv; charset=utf-8", "csv", exportCSV},
+	"xlsx": {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "xlsx", exportXLSX},
+}
+
+func exportCSV(cats []*Category, l *Locale) ([]byte, error) {
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	row := make([]string, len(exportColumns))
+	for i, col := range exportColumns {
+		row[i] = col.Header
+	}
+	cw.Write(row)
+	for _, c := range cats {
+		for i, col := range exportColumns {
+			row[i] = col.Value(c, l)
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return buf.Bytes(), cw.Error()
+}
+
+// Column widths are in characters, sized to the longest value and clamped
+// so one long description doesn't make the sheet unreadable.
+const (
+	xlsxMinWidth = 8
+	xlsxMaxWidth = 60
+)
+
+// exportXLSX writes a single-sheet workbook by hand: inline strings, a bold
+// shaded header row that stays frozen with an autofilter, and fitted column
+// widths. That is all the operations team needs and it keeps us off a
+// spreadsheet library.
+func exportXLSX(cats []*Category, l *Locale) ([]byte, error) {
+	widths := make([]int, len(exportColumns))
+	for i, col := range exportColumns {
+		widths[i] = utf8.RuneCountInString(col.Header)
+	}
+	rows := make([][]string, len(cats))
+	for r, c := range cats {
+		rows[r] = make([]string, len(exportColumns))
+		for i, col := range exportColumns {
+			v := col.Value(c, l)
+			rows[r][i] = v
+			if n := utf8.RuneCountInString(v); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	var sheet bytes.Buffer
+	sheet.WriteString(xml.Header)
+	sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	sheet.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	sheet.WriteString(`<cols>`)
+	for i, w := range widths {
+		if w < xlsxMinWidth {
+			w = xlsxMinWidth
+		}
+		if w > xlsxMaxWidth {
+			w = xlsxMaxWidth
+		}
+		fmt.Fprintf(&sheet, `<col min="%d" max="%d" width="%d" customWidth="1"/>`, i+1, i+1, w+2)
+	}
+	sheet.WriteString(`</cols><sheetData><row r="1">`)
+	for i, col := range exportColumns {
+		writeXLSXCell(&sheet, xlsxCellRef(i, 1), col.Header, false, 1)
+	}
+	sheet.WriteString(`</row>`)
+	for r, row := range rows {
+		fmt.Fprintf(&sheet, `<row r="%d">`, r+2)
+		for i, v := range row {
+			writeXLSXCell(&sheet, xlsxCellRef(i, r+2), v, exportColumns[i].Numeric, 0)
+		}
+		sheet.WriteString(`</row>`)
+	}
+	sheet.WriteString(`</sheetData>`)
+	fmt.Fprintf(&sheet, `<autoFilter ref="A1:%s"/>`, xlsxCellRef(len(exportColumns)-1, len(rows)+1))
+	sheet.WriteString(`</worksheet>`)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, part := range []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/styles.xml", xlsxStyles},
+		{"xl/worksheets/sheet1.xml", sheet.String()},
+	} {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeXLSXCell(buf *bytes.Buffer, ref, v string, numeric bool, style int) {
+	if numeric {
+		fmt.Fprintf(buf, `<c r="%s" s="%d"><v>%s</v></c>`, ref, style, v)
+		return
+	}
+	fmt.Fprintf(buf, `<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">`, ref, style)
+	xml.EscapeText(buf, []byte(v))
+	buf.WriteString(`</t></is></c>`)
+}
+
+// xlsxCellRef turns a zero-based column and one-based row into "A1" form.
+func xlsxCellRef(col, row int) string {
+	name := ""
+	for col++; col > 0; col = (col - 1) / 26 {
+		name = string(rune('A'+(col-1)%26)) + name
+	}
+	return name + strconv.Itoa(row)
+}
+
+const xlsxContentTypes = xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+	`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+	`<Default Extension="xml" ContentType="application/xml"/>` +
+	`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+	`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+	`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+	`</Types>`
+
+const xlsxRootRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`</Relationships>`
+
+const xlsxWorkbook = xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+	`<sheets><sheet name="Categories" sheetId="1" r:id="rId1"/></sheets>` +
+	`</workbook>`
+
+const xlsxWorkbookRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+	`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+	`</Relationships>`
+
+// xlsxStyles defines two cell formats: 0 for data and 1 for the header
+// (bold, light grey fill, bottom border).
+const xlsxStyles = xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="3"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill>` +
+	`<fill><patternFill patternType="solid"><fgColor rgb="FFD9D9D9"/><bgColor indexed="64"/></patternFill></fill></fills>` +
+	`<borders count="2"><border><left/><right/><top/><bottom/><diagonal/></border>` +
+	`<border><left/><right/><top/><bottom style="thin"><color auto="1"/></bottom><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="2" borderId="1" xfId="0" applyFont="1" applyFill="1" applyBorder="1"/></cellXfs>` +
+	`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+	`</styleSheet>`
+
+// =======================
+// SCHEDULED EXPORT
+// =======================
+
+var (
+	// exportStore is the bucket nightly exports go to; nil unless
+	// EXPORT_S3_BUCKET is set.
+	exportStore *s3Client
+	// exportPrefix is prepended to every key (EXPORT_S3_PREFIX).
+	exportPrefix = "exports/"
+	// exportFormatNames are the formats uploaded per run (EXPORT_FORMATS).
+	exportFormatNames = []string{"csv"}
+	// exportRetention is how long uploaded exports are kept (EXPORT_RETENTION);
+	// 0 keeps them forever.
+	exportRetention = 30 * 24 * time.Hour
+)
+
+func init() {
+	registerMetric("export_uploads_total", "counter", "Scheduled export uploads by format and result.")
+	registerMetric("export_objects_expired_total", "counter", "Exported objects deleted by the retention policy.")
+}
+
+// configureExport enables the "export" scheduled task (SCHEDULE_EXPORT,
+// nightly by default) when EXPORT_S3_BUCKET is set. Like the rest of the
+// settings these can live in .env.
+func configureExport() {
+	bucket := os.Getenv("EXPORT_S3_BUCKET")
+	if bucket == "" {
+		return
+	}
+	exportStore = &s3Client{
+		endpoint:  strings.TrimRight(os.Getenv("EXPORT_S3_ENDPOINT"), "/"),
+		region:    os.Getenv("EXPORT_S3_REGION"),
+		bucket:    bucket,
+		accessKey: os.Getenv("EXPORT_S3_ACCESS_KEY"),
+		secretKey: os.Getenv("EXPORT_S3_SECRET_KEY"),
+		http:      &http.Client{Timeout: time.Minute},
+	}
+	if exportStore.region == "" {
+		exportStore.region = "us-east-1"
+	}
+	if exportStore.endpoint == "" {
+		exportStore.endpoint = "https://s3." + exportStore.region + ".amazonaws.com"
+	}
+	if exportStore.accessKey == "" || exportStore.secretKey == "" {
+		log.Fatal("EXPORT_S3_ACCESS_KEY and EXPORT_S3_SECRET_KEY are required when EXPORT_S3_BUCKET is set")
+	}
+	if v, ok := os.LookupEnv("EXPORT_S3_PREFIX"); ok {
+		exportPrefix = v
+	}
+	if names := splitList(os.Getenv("EXPORT_FORMATS")); len(names) > 0 {
+		for _, name := range names {
+			if _, ok := exportFormats[name]; !ok {
+				log.Fatalf("invalid EXPORT_FORMATS: unknown format %q", name)
+			}
+		}
+		exportFormatNames = names
+	}
+	exportRetention = envDuration("EXPORT_RETENTION", exportRetention)
+
+	registerScheduledTask("export", "@nightly", runScheduledExport)
+}
+
+// runScheduledExport uploads one date-stamped file per format, e.g.
+// exports/categories-20261014-0000.xlsx, then applies the retention policy.
+func runScheduledExport() error {
+	now := time.Now().UTC()
+	storeMu.RLock()
+	files := map[string][]byte{}
+	var err error
+	for _, name := range exportFormatNames {
+		if files[name], err = exportFormats[name].Write(sortedCategories(), &defaultLocale); err != nil {
+			break
+		}
+	}
+	storeMu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	stamp := now.Format("20060102-1504")
+	for _, name := range exportFormatNames {
+		format := exportFormats[name]
+		key := exportPrefix + "categories-" + stamp + "." + format.Ext
+		if err := exportStore.PutObject(key, format.ContentType, files[name]); err != nil {
+			addMetric("export_uploads_total", 1, "format", name, "result", "error")
+			return err
+		}
+		addMetric("export_uploads_total", 1, "format", name, "result", "ok")
+		log.Printf("export: uploaded %s (%d bytes)", key, len(files[name]))
+	}
+	return expireExports(now)
+}
+
+// expireExports deletes exports older than exportRetention, judged by the
+// bucket's LastModified so renamed or hand-uploaded files are treated alike.
+func expireExports(now time.Time) error {
+	if exportRetention <= 0 {
+		return nil
+	}
+	objects, err := exportStore.ListObjects(exportPrefix + "categories-")
+	if err != nil {
+		return err
+	}
+	cutoff := now.Add(-exportRetention)
+	for _, obj := range objects {
+		if !obj.LastModified.Before(cutoff) {
+			continue
+		}
+		if err := exportStore.DeleteObject(obj.Key); err != nil {
+			return err
+		}
+		addMetric("export_objects_expired_total", 1)
+		log.Printf("export: deleted expired %s", obj.Key)
+	}
+	return nil
+}
+
+// ExportCategories godoc
+// @Summary Export categories
+// @Description Downloads every live category in display order as CSV (default) or as an Excel workbook
+// @Description with a styled header row and fitted column widths. Dates are RFC 3339 in UTC unless Accept-Language or
+// @Description X-Timezone ask otherwise.
+// @Tags Category
+// @Produce text/csv
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Param format query string false "File format" Enums(csv, xlsx)
+// @Param limit query int false "Rows (default and at most EXPORT_MAX_ROWS, 10000); see X-Total-Count and Link"
+// @Param offset query int false "Rows to skip"
+// @Param Accept-Language header string false "Writes dates in this language's layout (en, en-GB, de, fr, id); default ISO"
+// @Param X-Timezone header string false "IANA time zone for dates, e.g. Asia/Jakarta (default UTC)"
+// @Success 200 {file} file
+// @Failure 400 {string} string
+// @Router /categories/export [get]
+func ExportCategories(w http.ResponseWriter, r *http.Request) error {
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		name = "csv"
+	}
+	format, ok := exportFormats[name]
+	if !ok {
+		return &statusError{CodeValidationFailed, "format must be csv or xlsx"}
+	}
+
+	page, err := parsePage(r.URL.Query(), maxExportRows, maxExportRows)
+	if err != nil {
+		return err
+	}
+
+	l := requestLocale(r)
+	list := readableCategories(requestPrincipal(r), sortedCategories())
+	setPageHeaders(w, r, page, len(list))
+	data, err := format.Write(paginate(list, page), l)
+	if err != nil {
+		return err
+	}
+	setLocaleHeaders(w, l)
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="categories-%s.%s"`, time.Now().UTC().Format("20060102"), format.Ext))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+	return nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// =======================
+// FIELD NAMING
+// =======================
+
+// Field cases of JSON bodies (FIELD_CASE, X-Field-Case).
+const (
+	FieldCaseSnake = "snake" // created_at, as the handlers write them
+	FieldCaseCamel = "camel" // createdAt
+)
+
+var (
+	// fieldCase is the naming of JSON field names when a request doesn't ask
+	// for one with X-Field-Case (FIELD_CASE).
+	fieldCase = FieldCaseSnake
+
+	// verbatimFields hold client data, such as attribute names, and are left
+	// alone along with everything below them.
+	verbatimFields = map[string]bool{"attributes": true, "details": true, "value": true}
+	// dataKeyedFields are maps keyed by data, such as the names of
+	// POST /categories/exists; their keys are kept, their values converted.
+	dataKeyedFields = map[string]bool{"ids": true, "names": true, "files": true}
+)
+
+// configureFieldCase reads FIELD_CASE.
+func configureFieldCase() {
+	switch v := os.Getenv("FIELD_CASE"); v {
+	case "":
+	case FieldCaseSnake, FieldCaseCamel:
+		fieldCase = v
+	default:
+		log.Fatalf("invalid FIELD_CASE %q: want %q or %q", v, FieldCaseSnake, FieldCaseCamel)
+	}
+}
+
+// convertFieldCase renames JSON fields for clients that want camelCase:
+// request bodies are turned back into snake_case before the handler reads
+// them, and JSON responses renamed on the way out. It sits inside
+// negotiateEncoding, so MessagePack and CBOR are renamed as well, and
+// outside respondAsync, so operations are queued in snake_case and their
+// results come out in the case of whoever polls them. Encodings with a
+// schema, such as protobuf, and the API specs keep the names they document.
+func convertFieldCase(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want := fieldCase
+		if v := r.Header.Get("X-Field-Case"); v != "" {
+			want = strings.ToLower(v)
+			if want != FieldCaseSnake && want != FieldCaseCamel {
+				writeAPIError(w, CodeValidationFailed, `X-Field-Case must be "snake" or "camel"`)
+				return
+			}
+		}
+		w.Header().Add("Vary", "X-Field-Case")
+		route := routeLabel(r.URL.Path)
+		if want == FieldCaseSnake || route == "/swagger/*" || route == "/downloads/*" || isSpecPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "text/csv" && r.Body != nil {
+			data, err := io.ReadAll(io.LimitReader(r.Body, maxTranscodedBody))
+			if err != nil {
+				writeAPIError(w, CodeInvalidJSON, err.Error())
+				return
+			}
+			if out, ok := renameJSON(data, camelToSnake); ok {
+				data = out
+			}
+			r.Body = io.NopCloser(bytes.NewReader(data))
+			r.ContentLength = int64(len(data))
+		}
+
+		if _, codec, _ := responseCodec(r.Header.Get("Accept")); codec.Schema {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &bufferedResponse{header: w.Header().Clone()}
+		next.ServeHTTP(rec, r)
+		body := rec.body.Bytes()
+		if isJSONBody(rec.header.Get("Content-Type")) && rec.header.Get("Content-Encoding") == "" {
+			if out, ok := renameJSON(body, snakeToCamel); ok {
+				body = out
+			}
+		}
+		rec.copyTo(w, body)
+	})
+}
+
+// isJSONBody reports whether a Content-Type is JSON or a +json type; no
+// Content-Type counts, since the handlers decode JSON regardless.
+func isJSONBody(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// renameJSON renames the field names of a JSON document; ok is false when
+// data isn't one JSON value, which is then left for the handler to reject.
+// Handlers decode JSON whatever the Content-Type, so request bodies are
+// tried regardless of it.
+func renameJSON(data []byte, rename func(string) string) ([]byte, bool) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, false
+	}
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return nil, false
+	}
+	var buf bytes.Buffer
+	if err := encodeJSON(&buf, renameFields(v, rename)); err != nil {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+func renameFields(v interface{}, rename func(string) string) interface{} {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(node))
+		for k, child := range node {
+			switch name := rename(k); {
+			case verbatimFields[name], verbatimFields[k]:
+				out[name] = child
+			case dataKeyedFields[name], dataKeyedFields[k]:
+				if m, ok := child.(map[string]interface{}); ok {
+					kept := make(map[string]interface{}, len(m))
+					for mk, mv := range m {
+						kept[mk] = renameFields(mv, rename)
+					}
+					out[name] = kept
+				} else {
+					out[name] = renameFields(child, rename)
+				}
+			default:
+				out[name] = renameFields(child, rename)
+			}
+		}
+		// JSON Patch operations name fields in their pointers.
+		if _, ok := out["op"].(string); ok {
+			for _, key := range []string{"path", "from"} {
+				if p, ok := out[key].(string); ok {
+					out[key] = renamePointer(p, rename)
+				}
+			}
+		}
+		return out
+	case []interface{}:
+		for i, child := range node {
+			node[i] = renameFields(child, rename)
+		}
+		return node
+	}
+	return v
+}
+
+// renamePointer renames the field names in a JSON Pointer, up to the first
+// verbatim field; a name right below a data-keyed field is kept too.
+func renamePointer(pointer string, rename func(string) string) string {
+	if !strings.HasPrefix(pointer, "/") {
+		return pointer
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i := 0; i < len(tokens); i++ {
+		tokens[i] = rename(tokens[i])
+		if verbatimFields[tokens[i]] {
+			break
+		}
+		if dataKeyedFields[tokens[i]] {
+			i++
+		}
+	}
+	return "/" + strings.Join(tokens, "/")
+}
+
+// snakeToCamel turns created_at into createdAt.
+func snakeToCamel(s string) string {
+	if !strings.Contains(s, "_") {
+		return s
+	}
+	var b strings.Builder
+	upper := false
+	for _, c := range s {
+		switch {
+		case c == '_':
+			upper = b.Len() > 0
+		case upper:
+			b.WriteRune(unicode.ToUpper(c))
+			upper = false
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// camelToSnake turns createdAt into created_at; snake_case is kept.
+func camelToSnake(s string) string {
+	var b strings.Builder
+	for i, c := range s {
+		if unicode.IsUpper(c) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			c = unicode.ToLower(c)
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRenamePointer(t *testing.T) {
+	tests := []struct {
+		in     string
+		rename func(string) string
+		want   string
+	}{
+		{"/createdAt", camelToSnake, "/created_at"},
+		{"/attributes/fooBar", camelToSnake, "/attributes/fooBar"},
+		{"/names/myName/isTaken", camelToSnake, "/names/myName/is_taken"},
+		{"/tags/0", camelToSnake, "/tags/0"},
+		{"/created_at", snakeToCamel, "/createdAt"},
+		{"", camelToSnake, ""},
+		{"createdAt", camelToSnake, "createdAt"},
+	}
+	for _, tt := range tests {
+		if got := renamePointer(tt.in, tt.rename); got != tt.want {
+			t.Errorf("renamePointer(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRenameJSON(t *testing.T) {
+	tests := []struct {
+		in     string
+		rename func(string) string
+		want   string
+		ok     bool
+	}{
+		{`{"createdAt":1,"sortOrder":{"isDefault":true}}`, camelToSnake, `{"created_at":1,"sort_order":{"is_default":true}}`, true},
+		{`[{"updated_at":1},{"deleted_at":null}]`, snakeToCamel, `[{"updatedAt":1},{"deletedAt":null}]`, true},
+		{`{"attributes":{"fooBar":{"bazQux":1}}}`, camelToSnake, `{"attributes":{"fooBar":{"bazQux":1}}}`, true},
+		{`{"names":{"myName":{"isTaken":true}}}`, camelToSnake, `{"names":{"myName":{"is_taken":true}}}`, true},
+		{`{"op":"replace","path":"/sortOrder","value":{"keepMe":1}}`, camelToSnake, `{"op":"replace","path":"/sort_order","value":{"keepMe":1}}`, true},
+		{`{"op":"move","from":"/oldName","path":"/attributes/newName"}`, camelToSnake, `{"from":"/old_name","op":"move","path":"/attributes/newName"}`, true},
+		{`{"path":"/createdAt"}`, camelToSnake, `{"path":"/createdAt"}`, true},
+		{`12345678901234567890`, camelToSnake, `12345678901234567890`, true},
+		{``, camelToSnake, ``, false},
+		{`1,2`, camelToSnake, ``, false},
+		{`{"a":1} {"b":2}`, camelToSnake, ``, false},
+		{`name,description`, camelToSnake, ``, false},
+	}
+	for _, tt := range tests {
+		out, ok := renameJSON([]byte(tt.in), tt.rename)
+		if ok != tt.ok {
+			t.Errorf("renameJSON(%s) ok = %v, want %v", tt.in, ok, tt.ok)
+			continue
+		}
+		if got := string(bytes.TrimSpace(out)); ok && got != tt.want {
+			t.Errorf("renameJSON(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
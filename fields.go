@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// =======================
+// MODEL
+// =======================
+
+// Custom field types.
+const (
+	FieldString  = "string"
+	FieldNumber  = "number"
+	FieldInteger = "integer"
+	FieldBoolean = "boolean"
+	FieldDate    = "date" // YYYY-MM-DD
+	FieldEnum    = "enum" // one of Values
+)
+
+// CustomField declares an attribute with a type, checked on every category
+// write. Attributes without a definition stay free-form.
+type CustomField struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type" enums:"string,number,integer,boolean,date,enum"`
+	Required bool     `json:"required"`
+	Values   []string `json:"values,omitempty"`
+}
+
+// =======================
+// STORAGE (fake DB)
+// =======================
+
+// customFields is keyed by attribute name and guarded by storeMu.
+var customFields = map[string]CustomField{}
+
+// checkCustomFields validates attrs against the registered definitions.
+// Categories written before a field was defined are only checked on their
+// next write.
+func checkCustomFields(attrs map[string]interface{}) error {
+	names := make([]string, 0, len(customFields))
+	for name := range customFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := customFields[name]
+		v, ok := attrs[name]
+		if !ok || v == nil {
+			if f.Required {
+				return fmt.Errorf("attribute %q is required", name)
+			}
+			continue
+		}
+		if !f.accepts(v) {
+			if f.Type == FieldEnum {
+				return fmt.Errorf("attribute %q must be one of %s", name, strings.Join(f.Values, ", "))
+			}
+			return fmt.Errorf("attribute %q must be of type %s", name, f.Type)
+		}
+	}
+	return nil
+}
+
+// accepts reports whether a decoded JSON value fits the field's type.
+func (f CustomField) accepts(v interface{}) bool {
+	switch f.Type {
+	case FieldString:
+		_, ok := v.(string)
+		return ok
+	case FieldNumber:
+		_, ok := v.(float64)
+		return ok
+	case FieldInteger:
+		n, ok := v.(float64)
+		return ok && n == math.Trunc(n)
+	case FieldBoolean:
+		_, ok := v.(bool)
+		return ok
+	case FieldDate:
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		_, err := time.Parse(time.DateOnly, s)
+		return err == nil
+	case FieldEnum:
+		s, ok := v.(string)
+		for _, allowed := range f.Values {
+			if ok && s == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// =======================
+// HANDLER
+// =======================
+
+// GetCustomFields godoc
+// @Summary List custom fields
+// @Description The attribute definitions every category write is validated against, by name and paged:
+// @Description X-Total-Count has the number of fields and Link the next and prev pages.
+// @Tags Admin
+// @Produce json
+// @Param limit query int false "Page size (default PAGE_SIZE_DEFAULT, 20; at most PAGE_SIZE_MAX, 200)"
+// @Param offset query int false "Fields to skip"
+// @Success 200 {array} CustomField
+// @Failure 400 {string} string
+// @Router /admin/fields [get]
+func GetCustomFields(w http.ResponseWriter, r *http.Request) error {
+	page, err := parsePage(r.URL.Query(), defaultPageSize, maxPageSize)
+	if err != nil {
+		return err
+	}
+	result := []CustomField{}
+	for _, f := range customFields {
+		result = append(result, f)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	setPageHeaders(w, r, page, len(result))
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, paginate(result, page))
+	return nil
+}
+
+// PutCustomField godoc
+// @Summary Define a custom field
+// @Description Creates or replaces the definition of attributes.<name>. From then on category creates and updates
+// @Description must satisfy it; existing categories are not rechecked until they are next written.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param name path string true "Attribute name"
+// @Param body body CustomField true "Definition (name is taken from the path)"
+// @Success 200 {object} CustomField
+// @Failure 400 {string} string
+// @Router /admin/fields/{name} [put]
+func PutCustomField(w http.ResponseWriter, r *http.Request) error {
+	var f CustomField
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		return &statusError{CodeInvalidJSON, err.Error()}
+	}
+	f.Name = pathParts(r.URL.Path)[2]
+	if len(f.Name) > maxAttributeKeyLength || !attributeKeyPattern.MatchString(f.Name) {
+		return &statusError{CodeValidationFailed, fmt.Sprintf("invalid field name %q: it must be a valid attribute key", f.Name)}
+	}
+	switch f.Type {
+	case FieldString, FieldNumber, FieldInteger, FieldBoolean, FieldDate:
+		f.Values = nil
+	case FieldEnum:
+		if len(f.Values) == 0 {
+			return &statusError{CodeValidationFailed, "an enum field needs values"}
+		}
+	default:
+		return &statusError{CodeValidationFailed, fmt.Sprintf("invalid type %q: want string, number, integer, boolean, date or enum", f.Type)}
+	}
+	customFields[f.Name] = f
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, f)
+	return nil
+}
+
+// DeleteCustomField godoc
+// @Summary Remove a custom field
+// @Description The attribute becomes free-form again; values already stored are kept.
+// @Tags Admin
+// @Param name path string true "Attribute name"
+// @Success 204
+// @Failure 404 {string} string
+// @Router /admin/fields/{name} [delete]
+func DeleteCustomField(w http.ResponseWriter, r *http.Request) error {
+	name := pathParts(r.URL.Path)[2]
+	if _, ok := customFields[name]; !ok {
+		return &statusError{CodeFieldNotFound, "custom field not found"}
+	}
+	delete(customFields, name)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
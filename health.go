@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// =======================
+// MODEL
+// =======================
+
+// Health check statuses.
+const (
+	HealthOK      = "ok"
+	HealthFailing = "failing"
+)
+
+// HealthCheckResult is the outcome of one named check.
+type HealthCheckResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status" enums:"ok,failing"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthReport is the answer of GET /readyz?verbose=1.
+type HealthReport struct {
+	Status string              `json:"status" enums:"ok,failing"`
+	Checks []HealthCheckResult `json:"checks"`
+}
+
+// =======================
+// CHECKS
+// =======================
+
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+var (
+	healthChecks []healthCheck
+
+	// healthCheckTimeout bounds each readiness check (HEALTH_CHECK_TIMEOUT);
+	// a check that takes longer counts as failing.
+	healthCheckTimeout = 2 * time.Second
+)
+
+// registerHealthCheck adds a named readiness check. Register from init, or
+// from a configureX func for checks that depend on configuration.
+func registerHealthCheck(name string, check func(ctx context.Context) error) {
+	healthChecks = append(healthChecks, healthCheck{name: name, check: check})
+}
+
+// runHealthChecks runs every check concurrently and reports them by name.
+func runHealthChecks(ctx context.Context) HealthReport {
+	report := HealthReport{Status: HealthOK, Checks: make([]HealthCheckResult, len(healthChecks))}
+	var wg sync.WaitGroup
+	for i, hc := range healthChecks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Checks[i] = runHealthCheck(ctx, hc)
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(report.Checks, func(i, j int) bool { return report.Checks[i].Name < report.Checks[j].Name })
+	for _, c := range report.Checks {
+		if c.Status != HealthOK {
+			report.Status = HealthFailing
+		}
+	}
+	return report
+}
+
+// runHealthCheck runs one check, giving up on it after healthCheckTimeout;
+// a check that ignores its context is left to finish in the background.
+func runHealthCheck(ctx context.Context, hc healthCheck) HealthCheckResult {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() { errc <- hc.check(ctx) }()
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", healthCheckTimeout)
+	}
+
+	result := HealthCheckResult{Name: hc.name, Status: HealthOK, LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		result.Status = HealthFailing
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// =======================
+// HANDLER
+// =======================
+
+// Readyz godoc
+// @Summary Readiness probe
+// @Description 200 once the server accepts traffic and every registered check (Redis, event bus, disk space) passes,
+// @Description 503 before that, after SIGTERM or while a check fails. verbose=1 answers JSON with each check's status
+// @Description and latency.
+// @Tags Health
+// @Produce plain,json
+// @Param verbose query bool false "Report per-check status and latency"
+// @Success 200 {object} HealthReport
+// @Failure 503 {object} HealthReport
+// @Router /readyz [get]
+func Readyz(w http.ResponseWriter, r *http.Request) error {
+	if !ready.Load() {
+		return &statusError{CodeNotReady, "not ready"}
+	}
+	report := runHealthChecks(r.Context())
+
+	if verbose := r.URL.Query().Get("verbose"); verbose == "1" || verbose == "true" {
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status != HealthOK {
+			w.Header().Set("X-Error-Code", string(CodeNotReady))
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		encodeJSON(w, report)
+		return nil
+	}
+	for _, c := range report.Checks {
+		if c.Status != HealthOK {
+			return &statusError{CodeNotReady, fmt.Sprintf("%s check failing: %s", c.Name, c.Error)}
+		}
+	}
+	w.Write([]byte("ok"))
+	return nil
+}
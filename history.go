@@ -0,0 +1,225 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// =======================
+// MODEL
+// =======================
+
+// FieldChange is one field that differs between a revision and the one
+// before it.
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// CategoryRevision is the state of a category after one change. Revisions
+// are numbered from 1 per category; unlike Version they also count deletes.
+type CategoryRevision struct {
+	Revision  int           `json:"revision"`
+	Op        string        `json:"op" enums:"created,updated,deleted"`
+	Seq       int64         `json:"seq"`
+	Changes   []FieldChange `json:"changes"`
+	Category  Category      `json:"category"`
+	ChangedAt time.Time     `json:"changed_at"`
+}
+
+// CategoryHistory is the answer of GET /categories/{id}/history, oldest
+// revision first.
+type CategoryHistory struct {
+	CategoryID int                `json:"category_id"`
+	Revisions  []CategoryRevision `json:"revisions"`
+	// Truncated is set when older revisions were dropped (HISTORY_LIMIT).
+	Truncated bool `json:"truncated"`
+}
+
+// =======================
+// STORAGE (fake DB)
+// =======================
+
+var (
+	// categoryHistory holds the revisions of every category, including
+	// deleted ones until they are purged. It is written next to the
+	// changelog, under storeMu.
+	categoryHistory = map[int][]CategoryRevision{}
+
+	// historyLimit caps the revisions kept per category (HISTORY_LIMIT,
+	// 0 keeps all); the oldest are dropped first.
+	historyLimit = 100
+)
+
+// recordRevision appends the category from a change event to its history.
+// Callers must hold storeMu for writing.
+func recordRevision(e ChangeEvent, c Category) {
+	revs := categoryHistory[c.ID]
+	rev := CategoryRevision{Revision: 1, Op: e.Op, Seq: e.Seq, Changes: []FieldChange{}, Category: c, ChangedAt: e.CreatedAt}
+	if n := len(revs); n > 0 {
+		rev.Revision = revs[n-1].Revision + 1
+		rev.Changes = diffCategories(revs[n-1].Category, c)
+	}
+	if historyLimit > 0 && len(revs) >= historyLimit {
+		revs = append([]CategoryRevision{}, revs[len(revs)-historyLimit+1:]...)
+	}
+	categoryHistory[c.ID] = append(revs, rev)
+}
+
+// diffCategories lists the fields a client can observe changing. Version
+// and updated_at change with every revision and are left out.
+func diffCategories(a, b Category) []FieldChange {
+	changes := []FieldChange{}
+	if a.Name != b.Name {
+		changes = append(changes, FieldChange{"name", a.Name, b.Name})
+	}
+	if a.Description != b.Description {
+		changes = append(changes, FieldChange{"description", a.Description, b.Description})
+	}
+	if !equalStrings(a.Tags, b.Tags) {
+		changes = append(changes, FieldChange{"tags", a.Tags, b.Tags})
+	}
+	if !equalAttributes(a.Attributes, b.Attributes) {
+		changes = append(changes, FieldChange{"attributes", a.Attributes, b.Attributes})
+	}
+	if a.Position != b.Position {
+		changes = append(changes, FieldChange{"position", a.Position, b.Position})
+	}
+	if a.Status != b.Status {
+		changes = append(changes, FieldChange{"status", a.Status, b.Status})
+	}
+	if !equalACLs(a.ACL, b.ACL) {
+		changes = append(changes, FieldChange{"acl", a.ACL, b.ACL})
+	}
+	if (a.DeletedAt == nil) != (b.DeletedAt == nil) {
+		changes = append(changes, FieldChange{"deleted_at", a.DeletedAt, b.DeletedAt})
+	}
+	return changes
+}
+
+// findRevision looks up one revision of a category.
+func findRevision(id, revision int) (CategoryRevision, bool) {
+	for _, rev := range categoryHistory[id] {
+		if rev.Revision == revision {
+			return rev, true
+		}
+	}
+	return CategoryRevision{}, false
+}
+
+// =======================
+// HANDLER
+// =======================
+
+// GetCategoryHistory godoc
+// @Summary Get category history
+// @Description Every revision of the category, oldest first, each with the fields that changed since the previous one.
+// @Description Deleted categories keep their history until they are purged. Only the newest HISTORY_LIMIT revisions are kept.
+// @Tags Category
+// @Produce json
+// @Param id path int true "Category ID"
+// @Success 200 {object} CategoryHistory
+// @Failure 404 {string} string
+// @Router /categories/{id}/history [get]
+func GetCategoryHistory(w http.ResponseWriter, r *http.Request) error {
+	id := parseIDAt(r.URL.Path, 1)
+	revs, ok := categoryHistory[id]
+	if !ok {
+		return &statusError{CodeCategoryNotFound, "category not found"}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, CategoryHistory{CategoryID: id, Revisions: revs, Truncated: revs[0].Revision > 1})
+	return nil
+}
+
+// GetCategoryRevision godoc
+// @Summary Get category revision
+// @Description The category as it was after the given revision, with the fields that changed in it.
+// @Tags Category
+// @Produce json
+// @Param id path int true "Category ID"
+// @Param revision path int true "Revision number"
+// @Success 200 {object} CategoryRevision
+// @Failure 404 {string} string
+// @Router /categories/{id}/history/{revision} [get]
+func GetCategoryRevision(w http.ResponseWriter, r *http.Request) error {
+	id := parseIDAt(r.URL.Path, 1)
+	if _, ok := categoryHistory[id]; !ok {
+		return &statusError{CodeCategoryNotFound, "category not found"}
+	}
+	revision, err := strconv.Atoi(pathParts(r.URL.Path)[3])
+	if err != nil {
+		return &statusError{CodeValidationFailed, "revision must be a number"}
+	}
+	rev, ok := findRevision(id, revision)
+	if !ok {
+		return &statusError{CodeRevisionNotFound, "revision not found"}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, rev)
+	return nil
+}
+
+// RevertCategory godoc
+// @Summary Revert category
+// @Description Restores the name, description, tags and attributes the category had at the given revision. This is recorded as a new
+// @Description revision, so a revert can itself be reverted. Status is left alone; use archive/unarchive for it.
+// @Description Honours If-Match and If-Unmodified-Since like PUT.
+// @Tags Category
+// @Produce json
+// @Param id path int true "Category ID"
+// @Param revision query int true "Revision to restore"
+// @Param If-Match header string false "ETag the client last saw"
+// @Param If-Unmodified-Since header string false "Last-Modified the client last saw"
+// @Success 200 {object} Category
+// @Failure 400 {string} string
+// @Failure 404 {string} string
+// @Failure 412 {string} string
+// @Failure 428 {string} string
+// @Router /categories/{id}/revert [post]
+func RevertCategory(w http.ResponseWriter, r *http.Request) error {
+	id := parseIDAt(r.URL.Path, 1)
+	category, ok := findCategory(id)
+	if !ok {
+		return &statusError{CodeCategoryNotFound, "category not found"}
+	}
+	if err := checkPreconditions(r, categoryETag(category), category.UpdatedAt); err != nil {
+		return err
+	}
+	revision, err := strconv.Atoi(r.URL.Query().Get("revision"))
+	if err != nil {
+		return &statusError{CodeValidationFailed, "revision is required and must be a number"}
+	}
+	rev, ok := findRevision(id, revision)
+	if !ok {
+		return &statusError{CodeRevisionNotFound, "revision not found"}
+	}
+
+	input := *category
+	input.Name = rev.Category.Name
+	input.Description = rev.Category.Description
+	input.Tags = append([]string{}, rev.Category.Tags...)
+	if input.Attributes, err = validateAttributes(rev.Category.Attributes); err != nil {
+		return &statusError{CodeValidationFailed, "revision " + strconv.Itoa(revision) + " no longer validates: " + err.Error()}
+	}
+	if err := CategoryHooks.runBefore(hookUpdate, &input); err != nil {
+		return err
+	}
+
+	category.Name = input.Name
+	category.Description = input.Description
+	category.Tags = input.Tags
+	category.Attributes = input.Attributes
+	touchCategory(category)
+	recordAudit("revert", category.ID, map[string]interface{}{"revision": revision})
+	CategoryHooks.runAfter(hookUpdate, category)
+
+	w.Header().Set("ETag", categoryETag(category))
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, category)
+	return nil
+}
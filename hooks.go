@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+)
+
+// =======================
+// LIFECYCLE HOOKS
+// =======================
+
+// Hooks holds the functions run around creates, updates and deletes of one
+// model. Register them from init in a separate file of this package to add
+// validation, enrichment or side effects without touching the handlers:
+//
+//	func init() {
+//		CategoryHooks.BeforeCreate(func(c *Category) error {
+//			if strings.HasPrefix(c.Name, "tmp") {
+//				return &statusError{CodeValidationFailed, "temporary names are not allowed"}
+//			}
+//			return nil
+//		})
+//	}
+//
+// Before hooks see the record as it is about to be stored (built-in
+// validation has passed, but a new record has no ID yet) and may change it;
+// an error rejects the request, with VALIDATION_FAILED unless it is a
+// statusError. After hooks see the stored record once the change has
+// committed, and never run for dry runs; their errors are logged because
+// the change has already been made.
+type Hooks[T any] struct {
+	before map[string][]func(*T) error
+	after  map[string][]func(*T) error
+}
+
+const (
+	hookCreate = "create"
+	hookUpdate = "update"
+	hookDelete = "delete"
+)
+
+// CategoryHooks and ProductHooks apply to the CRUD endpoints of each model.
+var (
+	CategoryHooks = &Hooks[Category]{}
+	ProductHooks  = &Hooks[Product]{}
+)
+
+func (h *Hooks[T]) BeforeCreate(fn func(*T) error) { h.add(&h.before, hookCreate, fn) }
+func (h *Hooks[T]) AfterCreate(fn func(*T) error)  { h.add(&h.after, hookCreate, fn) }
+func (h *Hooks[T]) BeforeUpdate(fn func(*T) error) { h.add(&h.before, hookUpdate, fn) }
+func (h *Hooks[T]) AfterUpdate(fn func(*T) error)  { h.add(&h.after, hookUpdate, fn) }
+func (h *Hooks[T]) BeforeDelete(fn func(*T) error) { h.add(&h.before, hookDelete, fn) }
+func (h *Hooks[T]) AfterDelete(fn func(*T) error)  { h.add(&h.after, hookDelete, fn) }
+
+func (h *Hooks[T]) add(set *map[string][]func(*T) error, op string, fn func(*T) error) {
+	if *set == nil {
+		*set = map[string][]func(*T) error{}
+	}
+	(*set)[op] = append((*set)[op], fn)
+}
+
+// runBefore stops at the first failing hook.
+func (h *Hooks[T]) runBefore(op string, v *T) error {
+	if h == nil {
+		return nil
+	}
+	for _, fn := range h.before[op] {
+		if err := fn(v); err != nil {
+			if _, ok := err.(*statusError); ok {
+				return err
+			}
+			return &statusError{CodeValidationFailed, err.Error()}
+		}
+	}
+	return nil
+}
+
+// runAfter runs the after hooks once the change commits; a dry run or a
+// rolled back transaction skips them.
+func (h *Hooks[T]) runAfter(op string, v *T) {
+	if h == nil {
+		return
+	}
+	afterCommit(func() {
+		for _, fn := range h.after[op] {
+			if err := fn(v); err != nil {
+				log.Printf("after %s hook: %v", op, err)
+			}
+		}
+	})
+}
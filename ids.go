@@ -0,0 +1,120 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =======================
+// ID GENERATION
+// =======================
+
+// ID strategies, selected with ID_STRATEGY.
+const (
+	// IDSequence numbers records 1, 2, 3... per resource. IDs are only unique
+	// within one instance's store.
+	IDSequence = "sequence"
+	// IDSnowflake builds IDs from the time, the instance's node number
+	// (ID_NODE) and a per-second counter, so instances never hand out the
+	// same ID.
+	IDSnowflake = "snowflake"
+)
+
+// IDGenerator hands out record IDs. seq is the resource's sequence counter
+// (autoID, itemAutoID, ...); a generator that doesn't number from it must
+// still keep it above every ID it returned, since transactions and archive
+// restores save and reset it. Callers hold storeMu for writing.
+type IDGenerator interface {
+	Next(seq *int) int
+}
+
+var idGenerator IDGenerator = sequenceIDs{}
+
+// nextID allocates the next ID from seq with the configured strategy.
+func nextID(seq *int) int {
+	return idGenerator.Next(seq)
+}
+
+// configureIDs reads ID_STRATEGY and, for snowflake IDs, ID_NODE. Without
+// ID_NODE the ordinal at the end of a StatefulSet hostname such as
+// "simple-crud-2" is used; anything else must set it explicitly.
+func configureIDs() {
+	switch strategy := os.Getenv("ID_STRATEGY"); strategy {
+	case "", IDSequence:
+	case IDSnowflake:
+		node, err := -1, error(nil)
+		if v := os.Getenv("ID_NODE"); v != "" {
+			node, err = strconv.Atoi(v)
+		} else if host, _ := os.Hostname(); strings.Contains(host, "-") {
+			node, err = strconv.Atoi(host[strings.LastIndex(host, "-")+1:])
+		}
+		if err != nil || node < 0 || node > snowflakeMaxNode {
+			log.Fatalf("ID_STRATEGY=snowflake needs ID_NODE between 0 and %d, unique per instance", snowflakeMaxNode)
+		}
+		idGenerator = &snowflakeIDs{node: node}
+		log.Printf("ids: snowflake, node %d", node)
+	default:
+		log.Fatalf("invalid ID_STRATEGY %q: want %q or %q", strategy, IDSequence, IDSnowflake)
+	}
+}
+
+// sequenceIDs counts up.
+type sequenceIDs struct{}
+
+func (sequenceIDs) Next(seq *int) int {
+	id := *seq
+	*seq++
+	return id
+}
+
+// Snowflake layout, kept within 53 bits so IDs stay exact as JSON numbers
+// in JavaScript: 31 bits of seconds since snowflakeEpoch (good until 2092),
+// 10 bits of node and 12 bits of counter, i.e. 4096 IDs per second per node.
+const (
+	snowflakeNodeBits = 10
+	snowflakeStepBits = 12
+	snowflakeMaxNode  = 1<<snowflakeNodeBits - 1
+	snowflakeMaxStep  = 1<<snowflakeStepBits - 1
+)
+
+var snowflakeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type snowflakeIDs struct {
+	mu   sync.Mutex
+	node int
+	last int64 // second of the last ID
+	step int
+}
+
+// Next never goes back in time: if the clock does, IDs keep using the last
+// second until it catches up. A second whose counter is used up is waited
+// out.
+func (s *snowflakeIDs) Next(seq *int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		sec := int64(time.Since(snowflakeEpoch) / time.Second)
+		if sec < s.last {
+			sec = s.last
+		}
+		if sec > s.last {
+			s.last, s.step = sec, 0
+			break
+		}
+		if s.step < snowflakeMaxStep {
+			s.step++
+			break
+		}
+		time.Sleep(time.Until(snowflakeEpoch.Add(time.Duration(s.last+1) * time.Second)))
+	}
+
+	id := int(s.last<<(snowflakeNodeBits+snowflakeStepBits) | int64(s.node)<<snowflakeStepBits | int64(s.step))
+	if id >= *seq {
+		*seq = id + 1
+	}
+	return id
+}
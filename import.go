@@ -0,0 +1,432 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// =======================
+// MODEL
+// =======================
+
+// Duplicate strategies for imports (on_duplicate).
+const (
+	DuplicateSkip      = "skip"      // keep the existing category, ignore the row
+	DuplicateOverwrite = "overwrite" // update the existing category from the row
+	DuplicateSuffix    = "suffix"    // create the row under a free name, e.g. "Books (2)"
+)
+
+// Import row outcomes.
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportSkipped = "skipped"
+	ImportFailed  = "failed"
+)
+
+// ImportRowResult is what happened to one row of an import. Row counts from
+// 1 for JSON; for CSV it is the line number, so the header is line 1.
+type ImportRowResult struct {
+	Row         int    `json:"row"`
+	Status      string `json:"status" enums:"created,updated,skipped,failed"`
+	ID          int    `json:"id,omitempty"`
+	Name        string `json:"name"`
+	DuplicateOf int    `json:"duplicate_of,omitempty"`
+	// Field is the field a failed row was rejected for, when it is about one.
+	Field string `json:"field,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// ImportSummary is the answer of POST /categories/import.
+type ImportSummary struct {
+	OnDuplicate string            `json:"on_duplicate"`
+	Created     int               `json:"created"`
+	Updated     int               `json:"updated"`
+	Skipped     int               `json:"skipped"`
+	Failed      int               `json:"failed"`
+	Rows        []ImportRowResult `json:"rows"`
+}
+
+// importFieldError ties a row error to the field it is about.
+type importFieldError struct {
+	field string
+	err   error
+}
+
+func (e *importFieldError) Error() string { return e.err.Error() }
+
+func (e *importFieldError) Unwrap() error { return e.err }
+
+// errorField names the field err is about, or "".
+func errorField(err error) string {
+	var fe *importFieldError
+	var te *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &fe):
+		return fe.field
+	case errors.As(err, &te):
+		return te.Field
+	}
+	return ""
+}
+
+// importRow is one parsed row; err is set when it couldn't be parsed.
+type importRow struct {
+	num      int
+	category Category
+	err      error
+}
+
+// =======================
+// IMPORT
+// =======================
+
+// categorySlug is the identity duplicates are detected by: the name
+// lowercased with every run of other characters turned into one "-".
+func categorySlug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// parseImportJSON reads an array of categories.
+func parseImportJSON(body io.Reader) ([]importRow, error) {
+	var raw []json.RawMessage
+	if err := json.NewDecoder(body).Decode(&raw); err != nil {
+		return nil, &statusError{CodeInvalidJSON, "body must be a JSON array of categories: " + err.Error()}
+	}
+	rows := make([]importRow, len(raw))
+	for i, msg := range raw {
+		rows[i].num = i + 1
+		rows[i].err = json.Unmarshal(msg, &rows[i].category)
+	}
+	return rows, nil
+}
+
+// parseImportCSV reads a CSV in the layout of the export; only the Name,
+// Description, Tags and Attributes columns are used, matched by header.
+func parseImportCSV(body io.Reader) ([]importRow, error) {
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, &statusError{CodeValidationFailed, "CSV has no header row"}
+	}
+	col := map[string]int{}
+	for i, h := range header {
+		col[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))] = i
+	}
+	if _, ok := col["name"]; !ok {
+		return nil, &statusError{CodeValidationFailed, "CSV needs a Name column"}
+	}
+
+	rows := []importRow{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := cr.FieldPos(0)
+		row := importRow{num: line}
+		if err != nil {
+			var perr *csv.ParseError
+			if !errors.As(err, &perr) {
+				return nil, err
+			}
+			row.num, row.err = perr.Line, perr.Err
+			rows = append(rows, row)
+			continue
+		}
+		field := func(name string) string {
+			if i, ok := col[name]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		row.category.Name = field("name")
+		row.category.Description = field("description")
+		for _, t := range strings.Split(field("tags"), ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				row.category.Tags = append(row.category.Tags, t)
+			}
+		}
+		if v := strings.TrimSpace(field("attributes")); v != "" {
+			if err := json.Unmarshal([]byte(v), &row.category.Attributes); err != nil {
+				row.err = &importFieldError{"attributes", fmt.Errorf("attributes: %v", err)}
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// importCategories applies the rows in order. A row that fails is reported
+// and doesn't stop the others. Callers must hold storeMu for writing.
+func importCategories(rows []importRow, onDuplicate string, p *Principal) ImportSummary {
+	bySlug := map[string]*Category{}
+	for _, c := range readableCategories(p, sortedCategories()) {
+		if _, ok := bySlug[categorySlug(c.Name)]; !ok {
+			bySlug[categorySlug(c.Name)] = c
+		}
+	}
+
+	summary := ImportSummary{OnDuplicate: onDuplicate, Rows: []ImportRowResult{}}
+	for _, row := range rows {
+		result := ImportRowResult{Row: row.num, Name: row.category.Name}
+		err := row.err
+		if err == nil {
+			err = importRowInto(&row.category, &result, bySlug, onDuplicate, p)
+		}
+		if err != nil {
+			result.Status = ImportFailed
+			result.Field = errorField(err)
+			result.Error = err.Error()
+		}
+		switch result.Status {
+		case ImportCreated:
+			summary.Created++
+		case ImportUpdated:
+			summary.Updated++
+		case ImportSkipped:
+			summary.Skipped++
+		case ImportFailed:
+			summary.Failed++
+		}
+		summary.Rows = append(summary.Rows, result)
+	}
+	return summary
+}
+
+func importRowInto(input *Category, result *ImportRowResult, bySlug map[string]*Category, onDuplicate string, p *Principal) error {
+	if field, err := validateCategoryFields(input); err != nil {
+		return &importFieldError{field, err}
+	}
+	if err := checkACLLockout(p, input.ACL); err != nil {
+		return &importFieldError{"acl", err}
+	}
+	slug := categorySlug(input.Name)
+	if slug == "" {
+		return &importFieldError{"name", errors.New("name is required")}
+	}
+	result.Name = input.Name
+
+	if existing, ok := bySlug[slug]; ok {
+		result.DuplicateOf = existing.ID
+		switch onDuplicate {
+		case DuplicateSkip:
+			result.Status, result.ID = ImportSkipped, existing.ID
+			return nil
+		case DuplicateOverwrite:
+			if !canWriteCategory(p, existing) {
+				return errors.New("you may not change the existing category")
+			}
+			input.ID = existing.ID
+			if err := CategoryHooks.runBefore(hookUpdate, input); err != nil {
+				return err
+			}
+			existing.Name = input.Name
+			existing.Description = input.Description
+			existing.Tags = input.Tags
+			existing.Attributes = input.Attributes
+			if input.ACL != nil {
+				existing.ACL = input.ACL
+				if existing.ACL.open() {
+					existing.ACL = nil
+				}
+			}
+			touchCategory(existing)
+			CategoryHooks.runAfter(hookUpdate, existing)
+			result.Status, result.ID = ImportUpdated, existing.ID
+			return nil
+		case DuplicateSuffix:
+			base := input.Name
+			for n := 2; ; n++ {
+				input.Name = fmt.Sprintf("%s (%d)", base, n)
+				if _, taken := bySlug[categorySlug(input.Name)]; !taken {
+					break
+				}
+			}
+			slug = categorySlug(input.Name)
+			result.Name = input.Name
+		}
+	}
+
+	c := *input
+	if err := insertCategory(&c); err != nil {
+		return err
+	}
+	bySlug[slug] = &c
+	result.Status, result.ID = ImportCreated, c.ID
+	return nil
+}
+
+// =======================
+// HANDLER
+// =======================
+
+// ImportCategories godoc
+// @Summary Import categories
+// @Description Creates categories from a JSON array or a CSV in the export layout (Name, Description, Tags, Attributes).
+// @Description A row is a duplicate when its name matches an existing category or an earlier row, ignoring case and
+// @Description punctuation; on_duplicate decides what happens to it. Each row is validated like POST /categories and
+// @Description reported on its own: a failing row doesn't stop the rest. Supports dry_run and Prefer: respond-async.
+// @Tags Category
+// @Accept json,text/csv
+// @Produce json
+// @Param on_duplicate query string false "What to do with duplicates (default skip)" Enums(skip, overwrite, suffix)
+// @Param dry_run query bool false "Validate and return the would-be response without saving anything"
+// @Param Prefer header string false "respond-async answers 202 at once; poll the Location (GET /operations/{id})"
+// @Param Content-Encoding header string false "gzip to send the body compressed" Enums(gzip)
+// @Param body body []Category true "Categories"
+// @Success 200 {object} ImportSummary
+// @Success 202 {object} Operation
+// @Failure 400 {string} string
+// @Router /categories/import [post]
+func ImportCategories(w http.ResponseWriter, r *http.Request) error {
+	onDuplicate := r.URL.Query().Get("on_duplicate")
+	switch onDuplicate {
+	case "":
+		onDuplicate = DuplicateSkip
+	case DuplicateSkip, DuplicateOverwrite, DuplicateSuffix:
+	default:
+		return &statusError{CodeValidationFailed, "on_duplicate must be skip, overwrite or suffix"}
+	}
+
+	var rows []importRow
+	var err error
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "text/csv" {
+		rows, err = parseImportCSV(r.Body)
+	} else {
+		rows, err = parseImportJSON(r.Body)
+	}
+	if err != nil {
+		return err
+	}
+
+	summary := importCategories(rows, onDuplicate, requestPrincipal(r))
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, summary)
+	return nil
+}
+
+// =======================
+// VALIDATION REPORTS
+// =======================
+
+// importReportName is the file in downloadDir holding the failed rows of the
+// import an operation job ran.
+func importReportName(jobID int) string {
+	return fmt.Sprintf("import-report-%d.json", jobID)
+}
+
+// saveImportReport keeps the failed rows of an async import, from the
+// summary it answered, so they can be fetched from the operation, fixed and
+// sent again. Reports are cleaned up with the export files.
+func saveImportReport(jobID int, body []byte) error {
+	var summary ImportSummary
+	if err := json.Unmarshal(body, &summary); err != nil {
+		return err
+	}
+	failed := []ImportRowResult{}
+	for _, row := range summary.Rows {
+		if row.Status == ImportFailed {
+			failed = append(failed, row)
+		}
+	}
+	data, err := json.Marshal(failed)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(downloadDir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(downloadDir, importReportName(jobID))
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// hasImportReport reports whether a report was kept for an operation.
+func hasImportReport(jobID int) bool {
+	_, err := os.Stat(filepath.Join(downloadDir, importReportName(jobID)))
+	return err == nil
+}
+
+// GetImportReport godoc
+// @Summary Get the validation report of an import
+// @Description Lists the rows an import run with "Prefer: respond-async" rejected: row number (the line for CSV), name,
+// @Description the field at fault when the error is about one, and the error. Fix the rows and import them again.
+// @Description Reports are kept for DOWNLOAD_RETENTION; report_url on the operation links here while one exists.
+// @Tags Admin
+// @Produce json,text/csv
+// @Param id path int true "Operation ID"
+// @Param format query string false "json (default) or csv" Enums(json, csv)
+// @Success 200 {array} ImportRowResult
+// @Failure 400 {string} string
+// @Failure 404 {string} string
+// @Router /operations/{id}/report [get]
+func GetImportReport(w http.ResponseWriter, r *http.Request) error {
+	id := parseIDAt(r.URL.Path, 1)
+	jobsMu.Lock()
+	job, ok := jobList[id]
+	ok = ok && job.Type == "operation"
+	jobsMu.Unlock()
+	if !ok {
+		return &statusError{CodeJobNotFound, "operation not found"}
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		return &statusError{CodeValidationFailed, "format must be json or csv"}
+	}
+
+	data, err := os.ReadFile(filepath.Join(downloadDir, importReportName(id)))
+	if os.IsNotExist(err) {
+		return &statusError{CodeImportReportNotFound, "operation has no import report"}
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	if format != "csv" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+		return nil
+	}
+
+	var rows []ImportRowResult
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="import-report-%d.csv"`, id))
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Row", "Name", "Field", "Error"})
+	for _, row := range rows {
+		cw.Write([]string{strconv.Itoa(row.Row), row.Name, row.Field, row.Error})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// =======================
+// MODEL
+// =======================
+
+// Item is a lightweight record that only exists inside its category.
+type Item struct {
+	ID          int        `json:"id"`
+	CategoryID  int        `json:"category_id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+}
+
+// =======================
+// STORAGE (fake DB)
+// =======================
+
+var (
+	items      = map[int]*Item{}
+	itemAutoID = 1
+)
+
+// =======================
+// HANDLER
+// =======================
+
+// GetItems godoc
+// @Summary Get items in category
+// @Description Ordered by ID and paged: X-Total-Count has the number of items and Link the next and prev pages.
+// @Tags Item
+// @Produce json
+// @Param id path int true "Category ID"
+// @Param limit query int false "Page size (default PAGE_SIZE_DEFAULT, 20; at most PAGE_SIZE_MAX, 200)"
+// @Param offset query int false "Items to skip"
+// @Success 200 {array} Item
+// @Failure 400 {string} string
+// @Failure 404 {string} string
+// @Router /categories/{id}/items [get]
+func GetItems(w http.ResponseWriter, r *http.Request) error {
+	id := parseIDAt(r.URL.Path, 1)
+	if _, ok := findCategory(id); !ok {
+		return &statusError{CodeCategoryNotFound, "category not found"}
+	}
+	page, err := parsePage(r.URL.Query(), defaultPageSize, maxPageSize)
+	if err != nil {
+		return err
+	}
+
+	result := itemsInCategory(id)
+	setPageHeaders(w, r, page, len(result))
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, paginate(result, page))
+	return nil
+}
+
+// CreateItem godoc
+// @Summary Create item in category
+// @Tags Item
+// @Accept json
+// @Produce json
+// @Param id path int true "Category ID"
+// @Param body body Item true "Item"
+// @Param dry_run query bool false "Validate and return the would-be response without saving anything"
+// @Success 201 {object} Item
+// @Failure 400 {string} string
+// @Failure 404 {string} string
+// @Router /categories/{id}/items [post]
+func CreateItem(w http.ResponseWriter, r *http.Request) error {
+	id := parseIDAt(r.URL.Path, 1)
+	if _, ok := findCategory(id); !ok {
+		return &statusError{CodeCategoryNotFound, "category not found"}
+	}
+
+	var input Item
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return &statusError{CodeInvalidJSON, err.Error()}
+	}
+	if err := sanitizeNameAndDescription(&input.Name, &input.Description); err != nil {
+		return err
+	}
+
+	input.ID = nextID(&itemAutoID)
+	input.CategoryID = id
+	input.DeletedAt = nil
+	items[input.ID] = &input
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	encodeJSON(w, input)
+	return nil
+}
+
+// GetItem godoc
+// @Summary Get item detail
+// @Tags Item
+// @Produce json
+// @Param id path int true "Category ID"
+// @Param itemId path int true "Item ID"
+// @Success 200 {object} Item
+// @Failure 404 {string} string
+// @Router /categories/{id}/items/{itemId} [get]
+func GetItem(w http.ResponseWriter, r *http.Request) error {
+	item, ok := findItem(parseIDAt(r.URL.Path, 1), parseIDAt(r.URL.Path, 3))
+	if !ok {
+		return &statusError{CodeItemNotFound, "item not found"}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, item)
+	return nil
+}
+
+// UpdateItem godoc
+// @Summary Update item
+// @Tags Item
+// @Accept json
+// @Produce json
+// @Param id path int true "Category ID"
+// @Param itemId path int true "Item ID"
+// @Param body body Item true "Item"
+// @Param dry_run query bool false "Validate and return the would-be response without saving anything"
+// @Success 200 {object} Item
+// @Failure 400 {string} string
+// @Failure 404 {string} string
+// @Router /categories/{id}/items/{itemId} [put]
+func UpdateItem(w http.ResponseWriter, r *http.Request) error {
+	item, ok := findItem(parseIDAt(r.URL.Path, 1), parseIDAt(r.URL.Path, 3))
+	if !ok {
+		return &statusError{CodeItemNotFound, "item not found"}
+	}
+
+	var input Item
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return &statusError{CodeInvalidJSON, err.Error()}
+	}
+	if err := sanitizeNameAndDescription(&input.Name, &input.Description); err != nil {
+		return err
+	}
+
+	item.Name = input.Name
+	item.Description = input.Description
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, item)
+	return nil
+}
+
+// DeleteItem godoc
+// @Summary Delete item
+// @Tags Item
+// @Param id path int true "Category ID"
+// @Param itemId path int true "Item ID"
+// @Param dry_run query bool false "Validate and return the would-be response without saving anything"
+// @Success 204
+// @Failure 404 {string} string
+// @Router /categories/{id}/items/{itemId} [delete]
+func DeleteItem(w http.ResponseWriter, r *http.Request) error {
+	item, ok := findItem(parseIDAt(r.URL.Path, 1), parseIDAt(r.URL.Path, 3))
+	if !ok {
+		return &statusError{CodeItemNotFound, "item not found"}
+	}
+
+	if softDelete {
+		now := time.Now().UTC()
+		item.DeletedAt = &now
+	} else {
+		delete(items, item.ID)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// =======================
+// RELATIONS
+// =======================
+
+// findItem returns a live item, but only if it belongs to a live category.
+func findItem(categoryID, itemID int) (*Item, bool) {
+	if _, ok := findCategory(categoryID); !ok {
+		return nil, false
+	}
+	item, ok := items[itemID]
+	if !ok || item.CategoryID != categoryID || item.DeletedAt != nil {
+		return nil, false
+	}
+	return item, true
+}
+
+// itemsInCategory returns the live items of a category ordered by ID, which
+// GET /categories/{id}/items pages through.
+func itemsInCategory(categoryID int) []*Item {
+	result := []*Item{}
+	for _, it := range items {
+		if it.CategoryID == categoryID && it.DeletedAt == nil {
+			result = append(result, it)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// deleteItemsInCategory cascades a category delete to its items, soft
+// deleting them with the same timestamp as the parent when deletedAt is set.
+func deleteItemsInCategory(categoryID int, deletedAt *time.Time) {
+	for id, it := range items {
+		if it.CategoryID != categoryID {
+			continue
+		}
+		if deletedAt == nil {
+			delete(items, id)
+		} else if it.DeletedAt == nil {
+			it.DeletedAt = deletedAt
+		}
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// =======================
+// MODEL
+// =======================
+
+// Job statuses. A failed job goes back to queued until it runs out of
+// attempts, then it is parked as dead until someone retries it.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobDead      = "dead"
+)
+
+type Job struct {
+	ID          int             `json:"id"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload,omitempty" swaggertype:"object"`
+	Status      string          `json:"status" enums:"queued,running,succeeded,dead"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	RunAt       time.Time       `json:"run_at"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// JobHandler does the work for one job type. Returning an error schedules a retry.
+type JobHandler func(job *Job) error
+
+// JobPersister is the persistence hook for the queue: it is handed every job
+// state change and asked for the saved jobs on startup.
+type JobPersister interface {
+	SaveJobs(jobs []Job) error
+	LoadJobs() ([]Job, error)
+}
+
+// =======================
+// QUEUE
+// =======================
+
+const maxFinishedJobs = 1000
+
+var (
+	jobsMu      sync.Mutex
+	jobList     = map[int]*Job{}
+	jobAutoID   = 1
+	jobHandlers = map[string]JobHandler{}
+	jobStore    JobPersister
+
+	// jobsReady wakes idle workers when a job may have become due. jobList
+	// is the queue itself, so producers only signal and never wait: jobs
+	// are queued from handlers holding storeMu, and a producer stuck behind
+	// a full queue would stall every worker that needs the store.
+	jobsReady = sync.NewCond(&jobsMu)
+
+	// jobMaxAttempts is how often a job runs before it is dead-lettered (JOB_MAX_ATTEMPTS).
+	jobMaxAttempts = 5
+	// jobWorkers is the number of goroutines draining the queue (JOB_WORKERS).
+	jobWorkers = 2
+	// jobBackoff is the delay before the first retry; it doubles per attempt.
+	jobBackoff    = time.Second
+	jobMaxBackoff = 5 * time.Minute
+
+	// jobsDraining stops workers from starting jobs; jobsRunning counts the
+	// runs in progress. Both are guarded by jobsMu.
+	jobsDraining bool
+	jobsRunning  sync.WaitGroup
+	// jobsReleased is set once the queue has been handed to a replacement
+	// process: nothing more is queued or saved here. Guarded by jobsMu.
+	jobsReleased bool
+)
+
+func init() {
+	registerMetric("jobs_processed_total", "counter", "Job runs by type and result.")
+	registerShutdownHook("jobs", []string{"events"}, drainJobs)
+}
+
+// registerJobHandler makes a job type runnable. Call it before startJobWorkers.
+func registerJobHandler(jobType string, h JobHandler) {
+	jobHandlers[jobType] = h
+}
+
+// enqueueJob queues a job for immediate execution.
+func enqueueJob(jobType string, payload interface{}) (*Job, error) {
+	if _, ok := jobHandlers[jobType]; !ok {
+		return nil, fmt.Errorf("unknown job type %q", jobType)
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	jobsMu.Lock()
+	if jobsReleased {
+		jobsMu.Unlock()
+		return nil, &statusError{CodeNotReady, "restarting; retry the request"}
+	}
+	now := time.Now().UTC()
+	job := &Job{
+		ID:          jobAutoID,
+		Type:        jobType,
+		Payload:     raw,
+		Status:      JobQueued,
+		MaxAttempts: jobMaxAttempts,
+		RunAt:       now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	jobAutoID++
+	jobList[job.ID] = job
+	persistJobsLocked()
+	copied := *job
+	jobsReady.Signal()
+	jobsMu.Unlock()
+
+	return &copied, nil
+}
+
+// startJobWorkers restores persisted jobs and starts the worker pool.
+func startJobWorkers() {
+	if jobStore != nil {
+		saved, err := jobStore.LoadJobs()
+		if err != nil {
+			log.Printf("jobs: load: %v", err)
+		}
+		jobsMu.Lock()
+		for i := range saved {
+			job := saved[i]
+			if job.Status == JobRunning {
+				job.Status = JobQueued
+			}
+			jobList[job.ID] = &job
+			if job.ID >= jobAutoID {
+				jobAutoID = job.ID + 1
+			}
+			if job.Status == JobQueued {
+				wakeJobsAt(job.RunAt)
+			}
+		}
+		jobsMu.Unlock()
+	}
+
+	for i := 0; i < jobWorkers; i++ {
+		go jobWorker()
+	}
+}
+
+// drainJobs lets running jobs finish and saves the queue. Queued jobs stay
+// queued; with JOBS_FILE they run after the restart.
+func drainJobs(ctx context.Context) error {
+	jobsMu.Lock()
+	jobsDraining = true
+	jobsMu.Unlock()
+
+	err := waitContext(ctx, &jobsRunning)
+	jobsMu.Lock()
+	persistJobsLocked()
+	jobsMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("jobs still running: %w", err)
+	}
+	return nil
+}
+
+// releaseJobs hands the queue to a replacement process before it starts:
+// workers stop, running jobs finish and the queue is saved, and from then on
+// this process neither queues nor saves jobs, so the replacement loads
+// JOBS_FILE without a job being lost or run twice. Jobs still running when
+// ctx ends resume here and the error is returned.
+func releaseJobs(ctx context.Context) error {
+	if jobStore == nil {
+		return nil
+	}
+	jobsMu.Lock()
+	jobsDraining = true
+	jobsMu.Unlock()
+
+	if err := waitContext(ctx, &jobsRunning); err != nil {
+		resumeJobs()
+		return fmt.Errorf("jobs still running: %w", err)
+	}
+	jobsMu.Lock()
+	persistJobsLocked()
+	jobsReleased = true
+	jobsMu.Unlock()
+	return nil
+}
+
+// resumeJobs takes the queue back after releaseJobs, when the replacement
+// couldn't be started.
+func resumeJobs() {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	jobsDraining = false
+	jobsReleased = false
+	persistJobsLocked()
+	jobsReady.Broadcast()
+}
+
+// wakeJobsAt wakes a worker once a job queued to run at t is due.
+func wakeJobsAt(t time.Time) {
+	if delay := time.Until(t); delay > 0 {
+		time.AfterFunc(delay, func() {
+			jobsMu.Lock()
+			jobsReady.Signal()
+			jobsMu.Unlock()
+		})
+		return
+	}
+	jobsReady.Signal()
+}
+
+// nextJobLocked picks the queued job that has been due longest, or nil.
+func nextJobLocked() *Job {
+	if jobsDraining {
+		return nil
+	}
+	now := time.Now()
+	var next *Job
+	for _, j := range jobList {
+		if j.Status != JobQueued || j.RunAt.After(now) {
+			continue
+		}
+		if next == nil || j.RunAt.Before(next.RunAt) || (j.RunAt.Equal(next.RunAt) && j.ID < next.ID) {
+			next = j
+		}
+	}
+	return next
+}
+
+func jobWorker() {
+	for {
+		jobsMu.Lock()
+		job := nextJobLocked()
+		for job == nil {
+			jobsReady.Wait()
+			job = nextJobLocked()
+		}
+		// One signal may stand for several due jobs; pass it on.
+		jobsReady.Signal()
+		runJobLocked(job)
+	}
+}
+
+// runJobLocked runs a due job. It is called with jobsMu held and releases
+// it while the handler runs.
+func runJobLocked(job *Job) {
+	jobsRunning.Add(1)
+	defer jobsRunning.Done()
+	job.Status = JobRunning
+	job.Attempts++
+	job.UpdatedAt = time.Now().UTC()
+	handler := jobHandlers[job.Type]
+	copied := *job
+	persistJobsLocked()
+	jobsMu.Unlock()
+
+	err := handler(&copied)
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	job.UpdatedAt = time.Now().UTC()
+	switch {
+	case err == nil:
+		job.Status = JobSucceeded
+		job.LastError = ""
+		addMetric("jobs_processed_total", 1, "type", job.Type, "result", "succeeded")
+		pruneFinishedJobsLocked()
+	case job.Attempts >= job.MaxAttempts:
+		job.Status = JobDead
+		job.LastError = err.Error()
+		addMetric("jobs_processed_total", 1, "type", job.Type, "result", "dead")
+		log.Printf("jobs: %s job %d dead after %d attempts: %v", job.Type, job.ID, job.Attempts, err)
+	default:
+		job.Status = JobQueued
+		job.LastError = err.Error()
+		delay := jobBackoff << (job.Attempts - 1)
+		if delay > jobMaxBackoff || delay <= 0 {
+			delay = jobMaxBackoff
+		}
+		job.RunAt = job.UpdatedAt.Add(delay)
+		addMetric("jobs_processed_total", 1, "type", job.Type, "result", "retry")
+		wakeJobsAt(job.RunAt)
+	}
+	persistJobsLocked()
+}
+
+// pruneFinishedJobsLocked keeps only the newest maxFinishedJobs succeeded
+// jobs. Dead jobs are kept until retried.
+func pruneFinishedJobsLocked() {
+	done := []int{}
+	for id, j := range jobList {
+		if j.Status == JobSucceeded {
+			done = append(done, id)
+		}
+	}
+	if len(done) <= maxFinishedJobs {
+		return
+	}
+	sort.Ints(done)
+	for _, id := range done[:len(done)-maxFinishedJobs] {
+		delete(jobList, id)
+	}
+}
+
+func persistJobsLocked() {
+	if jobStore == nil || jobsReleased {
+		return
+	}
+	if err := jobStore.SaveJobs(snapshotJobsLocked("")); err != nil {
+		log.Printf("jobs: save: %v", err)
+	}
+}
+
+func snapshotJobsLocked(status string) []Job {
+	result := []Job{}
+	for _, j := range jobList {
+		if status == "" || j.Status == status {
+			result = append(result, *j)
+		}
+	}
+	sort.Slice(result, func(a, b int) bool { return result[a].ID < result[b].ID })
+	return result
+}
+
+// fileJobPersister keeps the queue in a JSON file (JOBS_FILE) so queued and
+// dead jobs survive a restart.
+type fileJobPersister struct {
+	path string
+}
+
+func (p fileJobPersister) SaveJobs(jobs []Job) error {
+	data, err := json.Marshal(jobs)
+	if err != nil {
+		return err
+	}
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.path)
+}
+
+func (p fileJobPersister) LoadJobs() ([]Job, error) {
+	data, err := os.ReadFile(p.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var jobs []Job
+	err = json.Unmarshal(data, &jobs)
+	return jobs, err
+}
+
+// =======================
+// HANDLER
+// =======================
+
+// GetJobs godoc
+// @Summary List background jobs
+// @Description Ordered by ID and paged: X-Total-Count has the number of jobs and Link the next and prev pages.
+// @Tags Admin
+// @Produce json
+// @Param status query string false "Only jobs with this status (dead = dead-letter list)" Enums(queued, running, succeeded, dead)
+// @Param limit query int false "Page size (default PAGE_SIZE_DEFAULT, 20; at most PAGE_SIZE_MAX, 200)"
+// @Param offset query int false "Jobs to skip"
+// @Success 200 {array} Job
+// @Failure 400 {string} string
+// @Router /admin/jobs [get]
+func GetJobs(w http.ResponseWriter, r *http.Request) error {
+	page, err := parsePage(r.URL.Query(), defaultPageSize, maxPageSize)
+	if err != nil {
+		return err
+	}
+	jobsMu.Lock()
+	result := snapshotJobsLocked(r.URL.Query().Get("status"))
+	jobsMu.Unlock()
+	setPageHeaders(w, r, page, len(result))
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, paginate(result, page))
+	return nil
+}
+
+// RetryJob godoc
+// @Summary Retry a dead job
+// @Description Moves a job off the dead-letter list and gives it a fresh set of attempts.
+// @Tags Admin
+// @Produce json
+// @Param id path int true "Job ID"
+// @Success 200 {object} Job
+// @Failure 404 {string} string
+// @Failure 409 {string} string
+// @Router /admin/jobs/{id}/retry [post]
+func RetryJob(w http.ResponseWriter, r *http.Request) error {
+	jobsMu.Lock()
+	job, ok := jobList[parseIDAt(r.URL.Path, 2)]
+	if !ok {
+		jobsMu.Unlock()
+		return &statusError{CodeJobNotFound, "job not found"}
+	}
+	if job.Status != JobDead {
+		jobsMu.Unlock()
+		return &statusError{CodeJobNotRetryable, "only dead jobs can be retried"}
+	}
+	job.Status = JobQueued
+	job.Attempts = 0
+	job.RunAt = time.Now().UTC()
+	job.UpdatedAt = job.RunAt
+	persistJobsLocked()
+	copied := *job
+	jobsReady.Signal()
+	jobsMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, copied)
+	return nil
+}
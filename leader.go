@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// =======================
+// LEADER ELECTION
+// =======================
+
+var (
+	// leaderElection makes replicas compete for a lease in the lock store;
+	// only the holder runs scheduled tasks (LEADER_ELECTION). When off, every
+	// instance considers itself leader.
+	leaderElection = false
+	// leaderLease is how long a lease lasts without renewal (LEADER_LEASE).
+	// A dead leader is replaced within roughly this long.
+	leaderLease = 15 * time.Second
+
+	instanceID = newInstanceID()
+	leader     atomic.Bool
+)
+
+func init() {
+	registerMetric("leader", "gauge", "1 if this instance currently holds the scheduler lease.")
+}
+
+func newInstanceID() string {
+	host, _ := os.Hostname()
+	return host + "-" + lockToken()[:8]
+}
+
+// isLeader reports whether this instance should run scheduled tasks.
+func isLeader() bool {
+	return !leaderElection || leader.Load()
+}
+
+// startLeaderElection keeps trying to take or renew the lease, renewing at a
+// third of its length so one missed round doesn't cost leadership.
+func startLeaderElection() {
+	if !leaderElection {
+		setMetric("leader", 1)
+		return
+	}
+	log.Printf("leader election: instance %s, lease %s", instanceID, leaderLease)
+	go func() {
+		for {
+			campaign()
+			time.Sleep(leaderLease / 3)
+		}
+	}()
+}
+
+func campaign() {
+	ok, err := locker.Lease("leader", instanceID, leaderLease)
+	if err != nil {
+		// Without the store we can't tell whether someone else took over,
+		// so stepping down is the safe choice.
+		log.Printf("leader election: %v", err)
+		ok = false
+	}
+	if was := leader.Swap(ok); was != ok {
+		if ok {
+			log.Printf("leader election: %s is now leader", instanceID)
+		} else {
+			log.Printf("leader election: %s lost leadership", instanceID)
+		}
+	}
+	if ok {
+		setMetric("leader", 1)
+	} else {
+		setMetric("leader", 0)
+	}
+}
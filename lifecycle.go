@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// =======================
+// LIFECYCLE
+// =======================
+
+var (
+	// shutdownDelay is how long the instance keeps serving after SIGTERM with
+	// readiness failing, so load balancers stop routing to it before the
+	// listener closes (SHUTDOWN_DELAY).
+	shutdownDelay = 5 * time.Second
+	// shutdownTimeout bounds how long in-flight requests get to finish once
+	// draining starts (SHUTDOWN_TIMEOUT). Keep shutdownDelay plus this and
+	// shutdownHookTimeout under the pod's terminationGracePeriodSeconds.
+	shutdownTimeout = 20 * time.Second
+
+	started atomic.Bool
+	ready   atomic.Bool
+)
+
+// Livez godoc
+// @Summary Liveness probe
+// @Description 200 while the process is up; it stays up during a graceful shutdown.
+// @Tags Health
+// @Produce plain
+// @Success 200 {string} string
+// @Router /livez [get]
+func Livez(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+// Startupz godoc
+// @Summary Startup probe
+// @Description 200 once configuration is loaded and background workers are running.
+// @Tags Health
+// @Produce plain
+// @Success 200 {string} string
+// @Failure 503 {string} string
+// @Router /startupz [get]
+func Startupz(w http.ResponseWriter, r *http.Request) error {
+	if !started.Load() {
+		return &statusError{CodeNotReady, "starting"}
+	}
+	w.Write([]byte("ok"))
+	return nil
+}
+
+// serve runs the HTTP server until SIGTERM or SIGINT, then fails readiness,
+// waits shutdownDelay, drains in-flight requests for up to shutdownTimeout
+// and runs the shutdown hooks. SIGHUP starts a replacement process that
+// takes the listener over. With ADMIN_ADDR the admin listener serves the
+// operational routes of handler and drains along with the public one.
+func serve(addr string, handler http.Handler) {
+	srv := &http.Server{Addr: addr, Handler: hideAdminRoutes(handler), TLSConfig: serverTLS}
+	srv.RegisterOnShutdown(endLongPolls)
+
+	ln, err := listen(addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		if serverTLS != nil {
+			errc <- srv.ServeTLS(ln, tlsCertFile, tlsKeyFile)
+			return
+		}
+		errc <- srv.Serve(ln)
+	}()
+	var adminSrv *http.Server
+	if adminAddr != "" {
+		adminSrv = startAdmin(handler)
+	}
+	started.Store(true)
+	ready.Store(true)
+
+	retireParent()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt, syscall.SIGHUP)
+wait:
+	for {
+		select {
+		case err := <-errc:
+			log.Fatal(err)
+		case s := <-sig:
+			if s == syscall.SIGHUP {
+				ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+				err := releaseJobs(ctx)
+				cancel()
+				if err == nil {
+					if err = startReplacement(ln); err != nil {
+						resumeJobs()
+					}
+				}
+				if err != nil {
+					log.Printf("graceful restart failed, still serving: %v", err)
+				}
+				continue
+			}
+			log.Printf("received %s, failing readiness for %s before draining", s, shutdownDelay)
+			break wait
+		}
+	}
+
+	// Keep-alive clients reconnect, and the balancer sends them elsewhere.
+	ready.Store(false)
+	srv.SetKeepAlivesEnabled(false)
+	time.Sleep(shutdownDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: %v", err)
+	} else {
+		log.Println("server stopped")
+	}
+	if adminSrv != nil {
+		if err := adminSrv.Shutdown(ctx); err != nil {
+			log.Printf("admin shutdown: %v", err)
+		}
+	}
+	runShutdownHooks()
+}
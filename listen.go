@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"strconv"
+)
+
+// =======================
+// LISTENERS
+// =======================
+
+var (
+	// unixSocket, when set, replaces the TCP port with a Unix domain socket
+	// at this path (UNIX_SOCKET), e.g. for a reverse proxy on the same host.
+	unixSocket string
+	// unixSocketMode is the permission of the socket file (UNIX_SOCKET_MODE,
+	// octal); the proxy's user must be able to write to it.
+	unixSocketMode fs.FileMode = 0o660
+)
+
+// sdListenFDsStart is the first file descriptor systemd passes (SD_LISTEN_FDS_START).
+const sdListenFDsStart = 3
+
+// configureListener reads UNIX_SOCKET and UNIX_SOCKET_MODE.
+func configureListener() {
+	unixSocket = os.Getenv("UNIX_SOCKET")
+	if v := os.Getenv("UNIX_SOCKET_MODE"); v != "" {
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil {
+			log.Fatalf("invalid UNIX_SOCKET_MODE %q: want an octal mode such as 660", v)
+		}
+		unixSocketMode = fs.FileMode(mode)
+	}
+}
+
+// listen picks where to accept connections: the listener handed over by a
+// graceful restart, a socket from systemd socket activation, UNIX_SOCKET,
+// or else the TCP addr.
+func listen(addr string) (net.Listener, error) {
+	if ln, err := inheritedListener(); ln != nil || err != nil {
+		return ln, err
+	}
+	if ln, err := systemdListener(); ln != nil || err != nil {
+		return ln, err
+	}
+	if unixSocket != "" {
+		return listenUnix(unixSocket)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err == nil {
+		log.Println("server running at", ln.Addr())
+	}
+	return ln, err
+}
+
+// systemdListener returns the first socket passed by systemd (LISTEN_PID,
+// LISTEN_FDS), or nil if the process wasn't socket-activated. Only one
+// socket is used; list a single ListenStream= in the .socket unit.
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	// Children must not inherit the activation.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if n > 1 {
+		log.Printf("systemd passed %d sockets, using the first", n)
+	}
+
+	f := os.NewFile(sdListenFDsStart, "systemd-socket")
+	ln, err := net.FileListener(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("systemd socket: %w", err)
+	}
+	log.Printf("listening on systemd socket %s", ln.Addr())
+	return ln, nil
+}
+
+// listenUnix creates the socket, replacing one left behind by a crash. The
+// listener removes the file again when it is closed.
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("UNIX_SOCKET %s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("UNIX_SOCKET %s is in use by another process", path)
+		}
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, unixSocketMode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	log.Printf("listening on unix socket %s", path)
+	return ln, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // X-Timezone must work on images without zoneinfo
+)
+
+// =======================
+// LOCALE
+// =======================
+
+// Locale is how generated files (reports, exports) write dates and numbers
+// for one client: Accept-Language picks the layouts, X-Timezone the zone.
+type Locale struct {
+	Tag      string // matched language tag; "" is the default locale
+	Location *time.Location
+	// DateTime and Timestamp are time layouts to the minute and second.
+	DateTime  string
+	Timestamp string
+	// Group separates thousands in counts; "" doesn't group.
+	Group string
+}
+
+// defaultLocale keeps the ISO-like output used before localization, in UTC.
+var defaultLocale = Locale{Location: time.UTC, DateTime: "2006-01-02 15:04", Timestamp: "2006-01-02 15:04:05"}
+
+// locales are the supported languages, by lowercase tag. A request for
+// "de-AT" falls back to "de".
+var locales = map[string]Locale{
+	"en":    {Tag: "en", DateTime: "01/02/2006 3:04 PM", Timestamp: "01/02/2006 3:04:05 PM", Group: ","},
+	"en-gb": {Tag: "en-GB", DateTime: "02/01/2006 15:04", Timestamp: "02/01/2006 15:04:05", Group: ","},
+	"de":    {Tag: "de", DateTime: "02.01.2006 15:04", Timestamp: "02.01.2006 15:04:05", Group: "."},
+	"fr":    {Tag: "fr", DateTime: "02/01/2006 15:04", Timestamp: "02/01/2006 15:04:05", Group: "\u00a0"},
+	"id":    {Tag: "id", DateTime: "02/01/2006 15.04", Timestamp: "02/01/2006 15.04.05", Group: "."},
+}
+
+type localeKey struct{}
+
+// matchLanguage picks the supported locale the Accept-Language header
+// prefers most, or the default locale.
+func matchLanguage(header string) Locale {
+	type choice struct {
+		tag string
+		q   float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" && tag != "*" && q > 0 {
+			choices = append(choices, choice{tag, q})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+
+	for _, c := range choices {
+		if l, ok := locales[c.tag]; ok {
+			return l
+		}
+		primary, _, _ := strings.Cut(c.tag, "-")
+		if l, ok := locales[primary]; ok {
+			return l
+		}
+	}
+	return defaultLocale
+}
+
+// lookupLocale resolves an Accept-Language value and an IANA zone name; an
+// empty zone is UTC.
+func lookupLocale(language, timezone string) (*Locale, error) {
+	l := matchLanguage(language)
+	l.Location = time.UTC
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, &statusError{CodeValidationFailed, "X-Timezone must be an IANA time zone such as Europe/Berlin"}
+		}
+		l.Location = loc
+	}
+	return &l, nil
+}
+
+// localize attaches the request's locale to its context.
+func localize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l, err := lookupLocale(r.Header.Get("Accept-Language"), strings.TrimSpace(r.Header.Get("X-Timezone")))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), localeKey{}, l)))
+	})
+}
+
+// requestLocale is the locale localize attached, or the default.
+func requestLocale(r *http.Request) *Locale {
+	if l, ok := r.Context().Value(localeKey{}).(*Locale); ok {
+		return l
+	}
+	return &defaultLocale
+}
+
+// setLocaleHeaders marks a response as rendered for the request's locale.
+func setLocaleHeaders(w http.ResponseWriter, l *Locale) {
+	w.Header().Add("Vary", "Accept-Language, X-Timezone")
+	if l.Tag != "" {
+		w.Header().Set("Content-Language", l.Tag)
+	}
+}
+
+// formatTime writes t in the locale's zone, to the minute or, with seconds,
+// to the second.
+func (l *Locale) formatTime(t time.Time, seconds bool) string {
+	if seconds {
+		return t.In(l.Location).Format(l.Timestamp)
+	}
+	return t.In(l.Location).Format(l.DateTime)
+}
+
+// exportTime writes an export timestamp: RFC 3339 for the default locale,
+// so files stay machine-readable, the locale's layout otherwise.
+func (l *Locale) exportTime(t time.Time) string {
+	if l.Tag == "" {
+		return t.In(l.Location).Format(time.RFC3339)
+	}
+	return l.formatTime(t, true)
+}
+
+// formatCount writes n with the locale's thousands separator.
+func (l *Locale) formatCount(n int) string {
+	s := strconv.Itoa(n)
+	if l.Group == "" {
+		return s
+	}
+	sign := ""
+	if n < 0 {
+		sign, s = "-", s[1:]
+	}
+	var b strings.Builder
+	for i, d := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteString(l.Group)
+		}
+		b.WriteRune(d)
+	}
+	return sign + b.String()
+}
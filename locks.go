@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =======================
+// LOCKS
+// =======================
+
+// Locker hands out named, expiring locks. With more than one replica
+// running, configure a shared backend (LOCK_REDIS_ADDR) so background work
+// such as scheduled purges runs on one instance at a time.
+type Locker interface {
+	// TryLock takes key for at most ttl without waiting. ok is false if
+	// someone else holds it. Call unlock once the work is done.
+	TryLock(key string, ttl time.Duration) (unlock func(), ok bool, err error)
+
+	// Lease takes key for holder, or extends it if holder already has it,
+	// for ttl. It reports whether holder owns key afterwards.
+	Lease(key, holder string, ttl time.Duration) (bool, error)
+}
+
+// locker is the process-wide Locker; it only coordinates goroutines unless
+// LOCK_REDIS_ADDR is set.
+var locker Locker = newLocalLocker()
+
+// lockTTL bounds how long a crashed holder can block others (LOCK_TTL).
+var lockTTL = 10 * time.Minute
+
+func configureLocks() {
+	lockTTL = envDuration("LOCK_TTL", lockTTL)
+	if addr := os.Getenv("LOCK_REDIS_ADDR"); addr != "" {
+		l := &redisLocker{addr: addr, password: os.Getenv("LOCK_REDIS_PASSWORD"), prefix: "simple-crud:lock:"}
+		locker = l
+		registerHealthCheck("redis", func(ctx context.Context) error {
+			_, err := l.command("PING")
+			return err
+		})
+	}
+}
+
+// localLocker keeps locks in memory.
+type localLocker struct {
+	mu    sync.Mutex
+	locks map[string]localLock
+}
+
+type localLock struct {
+	token   string
+	expires time.Time
+}
+
+func newLocalLocker() *localLocker {
+	return &localLocker{locks: map[string]localLock{}}
+}
+
+func (l *localLocker) TryLock(key string, ttl time.Duration) (func(), bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for k, held := range l.locks {
+		if !now.Before(held.expires) {
+			delete(l.locks, k)
+		}
+	}
+	if _, ok := l.locks[key]; ok {
+		return nil, false, nil
+	}
+	token := lockToken()
+	l.locks[key] = localLock{token: token, expires: now.Add(ttl)}
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.locks[key].token == token {
+			delete(l.locks, key)
+		}
+	}, true, nil
+}
+
+func (l *localLocker) Lease(key, holder string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if held, ok := l.locks[key]; ok && now.Before(held.expires) && held.token != holder {
+		return false, nil
+	}
+	l.locks[key] = localLock{token: holder, expires: now.Add(ttl)}
+	return true, nil
+}
+
+// redisLocker uses SET NX PX with a random token, and releases with a
+// compare-and-delete script so an expired holder can't free a lock someone
+// else has since taken.
+type redisLocker struct {
+	addr     string
+	password string
+	prefix   string
+}
+
+const redisUnlockScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+
+const redisLeaseScript = `local v = redis.call("get", KEYS[1])
+if v == ARGV[1] then redis.call("pexpire", KEYS[1], ARGV[2]) return 1 end
+if not v then redis.call("set", KEYS[1], ARGV[1], "PX", ARGV[2]) return 1 end
+return 0`
+
+func (l *redisLocker) Lease(key, holder string, ttl time.Duration) (bool, error) {
+	reply, err := l.command("EVAL", redisLeaseScript, "1", l.prefix+key, holder, strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return false, err
+	}
+	return reply == int64(1), nil
+}
+
+func (l *redisLocker) TryLock(key string, ttl time.Duration) (func(), bool, error) {
+	token := lockToken()
+	reply, err := l.command("SET", l.prefix+key, token, "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return nil, false, err
+	}
+	if reply == nil {
+		return nil, false, nil
+	}
+	return func() {
+		l.command("EVAL", redisUnlockScript, "1", l.prefix+key, token)
+	}, true, nil
+}
+
+// command runs one Redis command on a fresh connection. Lock traffic is a
+// handful of calls per task run, so there's no pool.
+func (l *redisLocker) command(args ...string) (interface{}, error) {
+	return redisDo(l.addr, l.password, args...)
+}
+
+// redisDo goes through redisBreaker, so while Redis is down lock calls, and
+// the readiness check, fail at once.
+func redisDo(addr, password string, args ...string) (reply interface{}, err error) {
+	err = redisBreaker.call(func() error {
+		reply, err = redisCommand(addr, password, args...)
+		return err
+	})
+	return reply, err
+}
+
+func redisCommand(addr, password string, args ...string) (interface{}, error) {
+	conn, err := net.DialTimeout("tcp", addr, 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+
+	if password != "" {
+		if _, err := redisRoundTrip(conn, r, "AUTH", password); err != nil {
+			return nil, err
+		}
+	}
+	return redisRoundTrip(conn, r, args...)
+}
+
+func redisRoundTrip(w io.Writer, r *bufio.Reader, args ...string) (interface{}, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return nil, err
+	}
+	return redisReadReply(r)
+}
+
+// redisReadReply parses one RESP2 reply. Nil bulk strings come back as nil.
+func redisReadReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New("redis: " + line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = redisReadReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+func lockToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -1,12 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"html"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	_ "simple-crud/docs"
 
@@ -19,9 +28,52 @@ import (
 // =======================
 
 type Category struct {
-	ID          int    `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	ID          int                    `json:"id"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Tags        []string               `json:"tags"`
+	Attributes  map[string]interface{} `json:"attributes,omitempty" swaggertype:"object"`
+	Position    int                    `json:"position"`
+	Status      string                 `json:"status" enums:"active,archived"`
+	Version     int                    `json:"version"`
+	CreatedAt   time.Time              `json:"created_at"`
+	UpdatedAt   time.Time              `json:"updated_at"`
+	DeletedAt   *time.Time             `json:"deleted_at,omitempty"`
+	ACL         *CategoryACL           `json:"acl,omitempty"`
+}
+
+// Category statuses. Archiving hides nothing by itself; it freezes the
+// category so no new products can be added until it is unarchived.
+const (
+	StatusActive   = "active"
+	StatusArchived = "archived"
+)
+
+// MergeRequest lists the categories to fold into the merge target.
+type MergeRequest struct {
+	SourceIDs []int `json:"source_ids"`
+}
+
+// UpdateConflict is returned with 409 when an update based on an older
+// version touches fields that were changed differently on the server since.
+type UpdateConflict struct {
+	Message     string   `json:"message"`
+	Fields      []string `json:"fields"`
+	BaseVersion int      `json:"base_version"`
+	Server      Category `json:"server"`
+	Client      Category `json:"client"`
+}
+
+// ReorderRequest is the desired display order. Categories left out keep
+// their current relative order after the listed ones.
+type ReorderRequest struct {
+	IDs []int `json:"ids"`
+}
+
+// TagCount is a distinct tag together with the number of categories using it.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
 }
 
 // =======================
@@ -33,129 +85,1078 @@ var (
 	autoID     = 1
 )
 
+// storeMu guards every map above (and the ones next to other models) now
+// that background jobs touch them as well as requests.
+var storeMu sync.RWMutex
+
+// storeCtx is the context of the request holding storeMu for writing, if
+// any, so withTx can roll back work whose client has already been answered
+// with a timeout. Guarded by storeMu.
+var storeCtx context.Context
+
+// withStore is middleware that runs a handler holding storeMu: shared for
+// reads, exclusive for anything that may write. A request whose deadline
+// passed while it waited for the lock has already been answered 503 by
+// enforceTimeouts, so it isn't run: a write applied after that answer would
+// be applied again by the client's retry. Writes run as a transaction for
+// the same reason, which withTx rolls back if the deadline passes while the
+// handler runs.
+func withStore(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			storeMu.RLock()
+			defer storeMu.RUnlock()
+		} else {
+			storeMu.Lock()
+			defer storeMu.Unlock()
+			storeCtx = r.Context()
+			defer func() { storeCtx = nil }()
+		}
+		if r.Context().Err() != nil {
+			return
+		}
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		withTx(func() error {
+			next.ServeHTTP(w, r)
+			return nil
+		})
+	})
+}
+
+// softDelete makes DELETE mark records with deleted_at instead of removing
+// them. Enabled with SOFT_DELETE=true.
+var softDelete = false
+
+// sortedCategories returns live categories in display order.
+func sortedCategories() []*Category {
+	result := []*Category{}
+	for _, c := range categories {
+		if c.DeletedAt == nil {
+			result = append(result, c)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Position != result[j].Position {
+			return result[i].Position < result[j].Position
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// nextPosition places a new category after every existing one.
+func nextPosition() int {
+	last := 0
+	for _, c := range categories {
+		if c.DeletedAt == nil && c.Position > last {
+			last = c.Position
+		}
+	}
+	return last + 1
+}
+
+// touchCategory bumps the version of a category that is about to be stored
+// and records the change event for it.
+func touchCategory(c *Category) {
+	c.Version++
+	c.UpdatedAt = time.Now().UTC()
+	recordCategoryChange(ChangeUpdated, c)
+}
+
+// findCategory looks up a category, hiding soft-deleted ones.
+func findCategory(id int) (*Category, bool) {
+	c, ok := categories[id]
+	if !ok || c.DeletedAt != nil {
+		return nil, false
+	}
+	return c, true
+}
+
 // =======================
 // HANDLER
 // =======================
 
 // GetCategories godoc
 // @Summary Get all categories
+// @Description Categories are returned in display order (see PUT /categories/reorder).
+// @Description attr.<key>=<value> filters on an attribute, e.g. ?attr.color=red; repeat for several keys.
+// @Description Categories whose acl the caller isn't in are left out.
+// @Description Results are paged: X-Total-Count has the number of matches and Link the next and prev pages.
 // @Tags Category
-// @Produce json
-// @Success 200 {array} Category
+// @Produce json,application/msgpack,application/cbor,application/x-protobuf
+// @Param limit query int false "Page size (default PAGE_SIZE_DEFAULT, 20; at most PAGE_SIZE_MAX, 200)"
+// @Param offset query int false "Matches to skip"
+// @Param tag query string false "Only categories with this tag"
+// @Param status query string false "Only categories with this status" Enums(active, archived)
+// @Param render query string false "html adds description_html, the description rendered from Markdown" Enums(html)
+// @Param If-Modified-Since header string false "Answer 304 if nothing changed since this HTTP date"
+// @Success 200 {array} RenderedCategory
+// @Success 304
+// @Failure 400 {string} string
 // @Router /categories [get]
-func GetCategories(w http.ResponseWriter, r *http.Request) {
+func GetCategories(w http.ResponseWriter, r *http.Request) error {
+	tag := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("tag")))
+	status := r.URL.Query().Get("status")
+	if status != "" && status != StatusActive && status != StatusArchived {
+		return &statusError{CodeValidationFailed, fmt.Sprintf("invalid status %q", status)}
+	}
+	render := r.URL.Query().Get("render")
+	if err := parseRender(render); err != nil {
+		return err
+	}
+	if checkNotModified(w, r, cacheRouteCategories, storeModified) {
+		return nil
+	}
+
+	page, err := parsePage(r.URL.Query(), defaultPageSize, maxPageSize)
+	if err != nil {
+		return err
+	}
+
+	attrs := attributeFilters(r.URL.Query())
 	result := []*Category{}
-	for _, v := range categories {
+	for _, v := range readableCategories(requestPrincipal(r), sortedCategories()) {
+		if tag != "" && !hasTag(v, tag) {
+			continue
+		}
+		if attrs != nil && !matchAttributes(v, attrs) {
+			continue
+		}
+		if status != "" && v.Status != status {
+			continue
+		}
 		result = append(result, v)
 	}
+	setPageHeaders(w, r, page, len(result))
+	result = paginate(result, page)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
+	if render == "html" {
+		rendered := []RenderedCategory{}
+		for _, c := range result {
+			rendered = append(rendered, renderCategory(c))
+		}
+		encodeJSON(w, rendered)
+		return nil
+	}
+	encodeJSON(w, result)
+	return nil
 }
 
 // CreateCategory godoc
 // @Summary Create category
+// @Description acl restricts who may read and change the category: entries are user:<name> or role:<name>, where
+// @Description the user is the mTLS identity or a trusted proxy's X-Forwarded-User and roles come from ACL_ROLES or
+// @Description X-Forwarded-Groups. The acl must keep write access for the caller unless they are in ACL_ADMINS.
 // @Tags Category
-// @Accept json
-// @Produce json
+// @Accept json,application/msgpack,application/cbor,application/x-protobuf
+// @Produce json,application/msgpack,application/cbor,application/x-protobuf
 // @Param body body Category true "Category"
+// @Param dry_run query bool false "Validate and return the would-be response without saving anything"
 // @Success 201 {object} Category
+// @Failure 400 {string} string
 // @Router /categories [post]
-func CreateCategory(w http.ResponseWriter, r *http.Request) {
+func CreateCategory(w http.ResponseWriter, r *http.Request) error {
 	var input Category
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		return &statusError{CodeInvalidJSON, err.Error()}
 	}
 
-	input.ID = autoID
-	autoID++
-	categories[input.ID] = &input
+	if err := validateCategoryInput(&input); err != nil {
+		return err
+	}
+	if err := checkACLLockout(requestPrincipal(r), input.ACL); err != nil {
+		return err
+	}
+	if err := insertCategory(&input); err != nil {
+		return err
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(input)
+	encodeJSON(w, input)
+	return nil
 }
 
 // GetCategory godoc
 // @Summary Get category detail
+// @Description Descriptions may be Markdown; render=html adds description_html, rendered and safe to display.
 // @Tags Category
-// @Produce json
+// @Produce json,application/msgpack,application/cbor,application/x-protobuf
 // @Param id path int true "Category ID"
-// @Success 200 {object} Category
+// @Param render query string false "html adds description_html" Enums(html)
+// @Param If-Modified-Since header string false "Answer 304 if unchanged since this HTTP date"
+// @Success 200 {object} RenderedCategory
+// @Failure 400 {string} string
+// @Success 304
 // @Failure 404 {string} string
 // @Router /categories/{id} [get]
-func GetCategory(w http.ResponseWriter, r *http.Request) {
+func GetCategory(w http.ResponseWriter, r *http.Request) error {
 	id := parseID(r.URL.Path)
-	category, ok := categories[id]
+	category, ok := findCategory(id)
 	if !ok {
-		http.Error(w, "category not found", http.StatusNotFound)
-		return
+		return &statusError{CodeCategoryNotFound, "category not found"}
+	}
+	render := r.URL.Query().Get("render")
+	if err := parseRender(render); err != nil {
+		return err
+	}
+	w.Header().Set("ETag", categoryETag(category))
+	if checkNotModified(w, r, cacheRouteCategory, category.UpdatedAt) {
+		return nil
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(category)
+	if render == "html" {
+		encodeJSON(w, renderCategory(category))
+		return nil
+	}
+	encodeJSON(w, category)
+	return nil
 }
 
-// UpdateCategory godoc
-// @Summary Update category
+// ExistsQuery is the body of POST /categories/exists.
+type ExistsQuery struct {
+	IDs   []int    `json:"ids,omitempty"`
+	Names []string `json:"names,omitempty"`
+}
+
+// ExistsResult maps each ID and name asked about to whether it exists.
+type ExistsResult struct {
+	IDs   map[string]bool `json:"ids"`
+	Names map[string]bool `json:"names"`
+}
+
+// maxExistsChecks caps the IDs plus names of one existence check.
+const maxExistsChecks = 1000
+
+// CheckCategoriesExist godoc
+// @Summary Check which categories exist
+// @Description Answers, for each ID and name, whether a live category has it, without fetching the categories. Names
+// @Description match the way import finds duplicates, ignoring case and punctuation. Categories the caller's ACLs hide
+// @Description count as missing. At most 1000 IDs and names per request.
 // @Tags Category
 // @Accept json
 // @Produce json
+// @Param body body ExistsQuery true "IDs and names to look up"
+// @Success 200 {object} ExistsResult
+// @Failure 400 {string} string
+// @Router /categories/exists [post]
+func CheckCategoriesExist(w http.ResponseWriter, r *http.Request) error {
+	var input ExistsQuery
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return &statusError{CodeInvalidJSON, err.Error()}
+	}
+	if len(input.IDs)+len(input.Names) > maxExistsChecks {
+		return &statusError{CodeValidationFailed, fmt.Sprintf("at most %d ids and names per request", maxExistsChecks)}
+	}
+
+	p := requestPrincipal(r)
+	result := ExistsResult{IDs: map[string]bool{}, Names: map[string]bool{}}
+	storeMu.RLock()
+	for _, id := range input.IDs {
+		c, ok := findCategory(id)
+		result.IDs[strconv.Itoa(id)] = ok && canReadCategory(p, c)
+	}
+	if len(input.Names) > 0 {
+		slugs := map[string]bool{}
+		for _, c := range readableCategories(p, sortedCategories()) {
+			slugs[categorySlug(c.Name)] = true
+		}
+		for _, name := range input.Names {
+			result.Names[name] = slugs[categorySlug(name)]
+		}
+	}
+	storeMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, result)
+	return nil
+}
+
+// validateCategoryInput sanitizes and normalizes the fields a client sets on
+// create and update.
+func validateCategoryInput(c *Category) error {
+	_, err := validateCategoryFields(c)
+	return err
+}
+
+// validateCategoryFields is validateCategoryInput that also names the field
+// that failed, for import reports.
+func validateCategoryFields(c *Category) (field string, err error) {
+	if err := sanitizeText("name", &c.Name); err != nil {
+		return "name", err
+	}
+	if err := sanitizeText("description", &c.Description); err != nil {
+		return "description", err
+	}
+	tags, err := normalizeTags(c.Tags)
+	if err != nil {
+		return "tags", &statusError{CodeValidationFailed, err.Error()}
+	}
+	c.Tags = tags
+	if c.Attributes, err = validateAttributes(c.Attributes); err != nil {
+		return "attributes", &statusError{CodeValidationFailed, err.Error()}
+	}
+	if err := validateACL(c.ACL); err != nil {
+		return "acl", &statusError{CodeValidationFailed, err.Error()}
+	}
+	return "", nil
+}
+
+// insertCategory stores a validated category as a new, active one at the
+// end of the display order, running the create hooks.
+func insertCategory(c *Category) error {
+	c.DeletedAt = nil
+	if c.ACL.open() {
+		c.ACL = nil
+	}
+	c.Position = nextPosition()
+	c.Status = StatusActive
+	c.Version = 1
+	c.CreatedAt = time.Now().UTC()
+	c.UpdatedAt = c.CreatedAt
+	c.ID = 0
+	if err := CategoryHooks.runBefore(hookCreate, c); err != nil {
+		return err
+	}
+
+	c.ID = nextID(&autoID)
+	categories[c.ID] = c
+	recordCategoryChange(ChangeCreated, c)
+	CategoryHooks.runAfter(hookCreate, c)
+	return nil
+}
+
+// UpdateCategory godoc
+// @Summary Update category
+// @Description Send the version you edited to get per-field merging: fields you didn't change keep the
+// @Description server's value, and fields that both sides changed differently are rejected with 409.
+// @Description Without a version the update simply overwrites.
+// @Description Leaving out acl keeps the current one; "acl": {} removes it. Callers who may only read get 403.
+// @Description If-Match (the ETag from GET) or If-Unmodified-Since reject the update with 412 when the category
+// @Description changed since; with REQUIRE_PRECONDITIONS=true one of them must be sent.
+// @Tags Category
+// @Accept json,application/msgpack,application/cbor,application/x-protobuf
+// @Produce json,application/msgpack,application/cbor,application/x-protobuf
 // @Param id path int true "Category ID"
+// @Param If-Match header string false "ETag the client last saw"
+// @Param If-Unmodified-Since header string false "Last-Modified the client last saw"
 // @Param body body Category true "Category"
+// @Param dry_run query bool false "Validate and return the would-be response without saving anything"
 // @Success 200 {object} Category
+// @Failure 400 {string} string
+// @Failure 403 {string} string
 // @Failure 404 {string} string
+// @Failure 409 {object} UpdateConflict
+// @Failure 412 {string} string
+// @Failure 428 {string} string
 // @Router /categories/{id} [put]
-func UpdateCategory(w http.ResponseWriter, r *http.Request) {
+func UpdateCategory(w http.ResponseWriter, r *http.Request) error {
 	id := parseID(r.URL.Path)
-	category, ok := categories[id]
+	category, ok := findCategory(id)
 	if !ok {
-		http.Error(w, "category not found", http.StatusNotFound)
-		return
+		return &statusError{CodeCategoryNotFound, "category not found"}
+	}
+	if err := checkPreconditions(r, categoryETag(category), category.UpdatedAt); err != nil {
+		return err
 	}
 
 	var input Category
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		return &statusError{CodeInvalidJSON, err.Error()}
+	}
+	if bodyOmits(r, "attributes") {
+		input.Attributes = category.Attributes
+	}
+
+	if err := validateCategoryInput(&input); err != nil {
+		return err
+	}
+	if err := checkACLLockout(requestPrincipal(r), input.ACL); err != nil {
+		return err
+	}
+	input.ID = id
+
+	if input.Version != 0 && input.Version != category.Version {
+		submitted := input
+		base, ok := categoryAtVersion(id, input.Version)
+		if !ok {
+			writeConflict(w, "version is too old to merge; re-fetch and retry", nil, submitted, category)
+			return nil
+		}
+		if fields := mergeCategory(&input, base, category); len(fields) > 0 {
+			writeConflict(w, "conflicting concurrent edits", fields, submitted, category)
+			return nil
+		}
+	}
+
+	if err := CategoryHooks.runBefore(hookUpdate, &input); err != nil {
+		return err
 	}
 
 	category.Name = input.Name
 	category.Description = input.Description
+	category.Tags = input.Tags
+	category.Attributes = input.Attributes
+	if input.ACL != nil {
+		category.ACL = input.ACL
+		if category.ACL.open() {
+			category.ACL = nil
+		}
+	}
+	touchCategory(category)
+	CategoryHooks.runAfter(hookUpdate, category)
 
+	w.Header().Set("ETag", categoryETag(category))
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(category)
+	encodeJSON(w, category)
+	return nil
 }
 
 // DeleteCategory godoc
 // @Summary Delete category
 // @Tags Category
 // @Param id path int true "Category ID"
+// @Description Items are deleted along with the category (soft deleted when SOFT_DELETE=true).
+// @Description Linked products are unlinked, or the delete is refused with 409 when CATEGORY_DELETE_MODE=block.
+// @Description Honours If-Match and If-Unmodified-Since like PUT.
+// @Param If-Match header string false "ETag the client last saw"
+// @Param If-Unmodified-Since header string false "Last-Modified the client last saw"
+// @Param dry_run query bool false "Validate and return the would-be response without saving anything"
 // @Success 204
 // @Failure 404 {string} string
+// @Failure 409 {string} string
+// @Failure 412 {string} string
+// @Failure 428 {string} string
 // @Router /categories/{id} [delete]
-func DeleteCategory(w http.ResponseWriter, r *http.Request) {
+func DeleteCategory(w http.ResponseWriter, r *http.Request) error {
 	id := parseID(r.URL.Path)
-	if _, ok := categories[id]; !ok {
-		http.Error(w, "category not found", http.StatusNotFound)
-		return
+	category, ok := findCategory(id)
+	if !ok {
+		return &statusError{CodeCategoryNotFound, "category not found"}
+	}
+	if err := checkPreconditions(r, categoryETag(category), category.UpdatedAt); err != nil {
+		return err
 	}
 
-	delete(categories, id)
+	if categoryDeleteMode == DeleteModeBlock && len(productsInCategory(id)) > 0 {
+		return &statusError{CodeCategoryHasProducts, "category still has products"}
+	}
+	if err := CategoryHooks.runBefore(hookDelete, category); err != nil {
+		return err
+	}
+
+	unlinkCategory(id)
+	if softDelete {
+		now := time.Now().UTC()
+		category.DeletedAt = &now
+		deleteItemsInCategory(id, &now)
+	} else {
+		deleteItemsInCategory(id, nil)
+		delete(categories, id)
+	}
+	recordCategoryChange(ChangeDeleted, category)
+	notify(EventCategoryDeleted, map[string]interface{}{"id": category.ID, "name": category.Name})
+	CategoryHooks.runAfter(hookDelete, category)
 	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// CloneCategory godoc
+// @Summary Clone category
+// @Description Creates a copy named "Copy of <name>", with the same description, tags, attributes and acl, validated
+// @Description like POST /categories. Pass items=true to copy its items as well.
+// @Tags Category
+// @Produce json
+// @Param id path int true "Category ID"
+// @Param items query bool false "Also copy the category's items"
+// @Success 201 {object} Category
+// @Failure 404 {string} string
+// @Router /categories/{id}/clone [post]
+func CloneCategory(w http.ResponseWriter, r *http.Request) error {
+	id := parseIDAt(r.URL.Path, 1)
+	source, ok := findCategory(id)
+	if !ok {
+		return &statusError{CodeCategoryNotFound, "category not found"}
+	}
+
+	withItems, _ := strconv.ParseBool(r.URL.Query().Get("items"))
+
+	// The copy is created like POST /categories would create it, so hooks,
+	// custom fields and the ACL lockout check apply to it too.
+	clone := &Category{
+		Name:        "Copy of " + source.Name,
+		Description: source.Description,
+		Tags:        append([]string{}, source.Tags...),
+		Attributes:  map[string]interface{}{},
+	}
+	for k, v := range source.Attributes {
+		clone.Attributes[k] = v
+	}
+	if source.ACL != nil {
+		acl := *source.ACL
+		clone.ACL = &acl
+	}
+	err := withTx(func() error {
+		if err := validateCategoryInput(clone); err != nil {
+			return err
+		}
+		if err := checkACLLockout(requestPrincipal(r), clone.ACL); err != nil {
+			return err
+		}
+		if err := insertCategory(clone); err != nil {
+			return err
+		}
+		if !withItems {
+			return nil
+		}
+		for _, it := range itemsInCategory(source.ID) {
+			copied := Item{CategoryID: clone.ID, Name: it.Name, Description: it.Description}
+			if err := sanitizeNameAndDescription(&copied.Name, &copied.Description); err != nil {
+				return err
+			}
+			copied.ID = nextID(&itemAutoID)
+			items[copied.ID] = &copied
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	encodeJSON(w, clone)
+	return nil
+}
+
+// MergeCategories godoc
+// @Summary Merge categories
+// @Description Moves the items, products and tags of every source category into the target and removes the sources.
+// @Description This runs as one transaction: if any source is invalid nothing is changed.
+// @Tags Category
+// @Accept json
+// @Produce json
+// @Param id path int true "Target category ID"
+// @Param body body MergeRequest true "Source categories"
+// @Param dry_run query bool false "Validate and return the would-be response without saving anything"
+// @Param Prefer header string false "respond-async answers 202 at once; poll the Location (GET /operations/{id})"
+// @Success 200 {object} Category
+// @Success 202 {object} Operation
+// @Failure 400 {string} string
+// @Failure 404 {string} string
+// @Failure 409 {string} string
+// @Router /categories/{id}/merge [post]
+func MergeCategories(w http.ResponseWriter, r *http.Request) error {
+	id := parseIDAt(r.URL.Path, 1)
+	target, ok := findCategory(id)
+	if !ok {
+		return &statusError{CodeCategoryNotFound, "category not found"}
+	}
+	if target.Status == StatusArchived {
+		return &statusError{CodeCategoryArchived, "category is archived"}
+	}
+
+	var input MergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return &statusError{CodeInvalidJSON, err.Error()}
+	}
+	if len(input.SourceIDs) == 0 {
+		return &statusError{CodeValidationFailed, "source_ids is required"}
+	}
+
+	p := requestPrincipal(r)
+	now := time.Now().UTC()
+	err := withTx(func() error {
+		for _, sid := range input.SourceIDs {
+			if sid == id {
+				return &statusError{CodeValidationFailed, "cannot merge a category into itself"}
+			}
+			source, ok := findCategory(sid)
+			if !ok || !canReadCategory(p, source) {
+				return &statusError{CodeCategoryNotFound, fmt.Sprintf("source category %d not found", sid)}
+			}
+			if !canWriteCategory(p, source) {
+				return &statusError{CodeAccessDenied, fmt.Sprintf("you may not change source category %d", sid)}
+			}
+
+			for _, it := range items {
+				if it.CategoryID == source.ID {
+					it.CategoryID = target.ID
+				}
+			}
+			for _, p := range products {
+				if containsID(p.CategoryIDs, source.ID) {
+					p.CategoryIDs = removeID(p.CategoryIDs, source.ID)
+					if !containsID(p.CategoryIDs, target.ID) {
+						p.CategoryIDs = append(p.CategoryIDs, target.ID)
+					}
+				}
+			}
+			for _, t := range source.Tags {
+				if !hasTag(target, t) {
+					target.Tags = append(target.Tags, t)
+				}
+			}
+
+			if softDelete {
+				source.DeletedAt = &now
+			} else {
+				delete(categories, source.ID)
+			}
+			recordCategoryChange(ChangeDeleted, source)
+		}
+
+		touchCategory(target)
+		recordAudit("merge", target.ID, map[string]interface{}{"source_ids": input.SourceIDs})
+		notify(EventCategoryMerged, map[string]interface{}{"id": target.ID, "name": target.Name, "source_ids": input.SourceIDs})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, target)
+	return nil
+}
+
+// categoryAtVersion finds the snapshot of a category at a given version in
+// the changelog.
+func categoryAtVersion(id, version int) (Category, bool) {
+	for i := len(changelog) - 1; i >= 0; i-- {
+		e := changelog[i]
+		if e.Resource != "category" || e.ResourceID != id {
+			continue
+		}
+		if c, ok := e.Data.(Category); ok && c.Version == version {
+			return c, true
+		}
+	}
+	return Category{}, false
+}
+
+// mergeCategory does a three-way merge of the editable fields: a field the
+// client left as it was in base takes the server's current value. It returns
+// the fields both sides changed to different values.
+func mergeCategory(client *Category, base Category, server *Category) []string {
+	conflicts := []string{}
+	if client.Name == base.Name {
+		client.Name = server.Name
+	} else if server.Name != base.Name && server.Name != client.Name {
+		conflicts = append(conflicts, "name")
+	}
+	if client.Description == base.Description {
+		client.Description = server.Description
+	} else if server.Description != base.Description && server.Description != client.Description {
+		conflicts = append(conflicts, "description")
+	}
+	if equalStrings(client.Tags, base.Tags) {
+		client.Tags = server.Tags
+	} else if !equalStrings(server.Tags, base.Tags) && !equalStrings(server.Tags, client.Tags) {
+		conflicts = append(conflicts, "tags")
+	}
+	if equalAttributes(client.Attributes, base.Attributes) {
+		client.Attributes = server.Attributes
+	} else if !equalAttributes(server.Attributes, base.Attributes) && !equalAttributes(server.Attributes, client.Attributes) {
+		conflicts = append(conflicts, "attributes")
+	}
+	return conflicts
+}
+
+func writeConflict(w http.ResponseWriter, message string, fields []string, client Category, server *Category) {
+	if fields == nil {
+		fields = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Error-Code", string(CodeVersionConflict))
+	w.WriteHeader(http.StatusConflict)
+	encodeJSON(w, UpdateConflict{
+		Message:     message,
+		Fields:      fields,
+		BaseVersion: client.Version,
+		Server:      *server,
+		Client:      client,
+	})
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ReorderCategories godoc
+// @Summary Reorder categories
+// @Description Sets the display order; the listed IDs get positions 1..n in the order given.
+// @Tags Category
+// @Accept json
+// @Produce json
+// @Param body body ReorderRequest true "Ordered category IDs"
+// @Success 200 {array} Category
+// @Failure 400 {string} string
+// @Failure 404 {string} string
+// @Router /categories/reorder [put]
+func ReorderCategories(w http.ResponseWriter, r *http.Request) error {
+	var input ReorderRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return &statusError{CodeInvalidJSON, err.Error()}
+	}
+
+	p := requestPrincipal(r)
+	seen := map[int]bool{}
+	for _, id := range input.IDs {
+		if seen[id] {
+			return &statusError{CodeValidationFailed, fmt.Sprintf("duplicate category id %d", id)}
+		}
+		seen[id] = true
+		c, ok := findCategory(id)
+		if !ok || !canReadCategory(p, c) {
+			return &statusError{CodeCategoryNotFound, fmt.Sprintf("category %d not found", id)}
+		}
+		if !canWriteCategory(p, c) {
+			return &statusError{CodeAccessDenied, fmt.Sprintf("you may not change category %d", id)}
+		}
+	}
+
+	rest := []*Category{}
+	for _, c := range sortedCategories() {
+		if !seen[c.ID] {
+			rest = append(rest, c)
+		}
+	}
+
+	ordered := []*Category{}
+	for _, id := range input.IDs {
+		ordered = append(ordered, categories[id])
+	}
+	for i, c := range append(ordered, rest...) {
+		if c.Position != i+1 {
+			c.Position = i + 1
+			touchCategory(c)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, readableCategories(p, sortedCategories()))
+	return nil
+}
+
+// ArchiveCategory godoc
+// @Summary Archive category
+// @Description Archived categories stay readable but reject new products.
+// @Tags Category
+// @Produce json
+// @Param id path int true "Category ID"
+// @Success 200 {object} Category
+// @Failure 404 {string} string
+// @Failure 409 {string} string
+// @Router /categories/{id}/archive [post]
+func ArchiveCategory(w http.ResponseWriter, r *http.Request) error {
+	return transitionCategory(w, r, StatusActive, StatusArchived)
+}
+
+// UnarchiveCategory godoc
+// @Summary Unarchive category
+// @Tags Category
+// @Produce json
+// @Param id path int true "Category ID"
+// @Success 200 {object} Category
+// @Failure 404 {string} string
+// @Failure 409 {string} string
+// @Router /categories/{id}/unarchive [post]
+func UnarchiveCategory(w http.ResponseWriter, r *http.Request) error {
+	return transitionCategory(w, r, StatusArchived, StatusActive)
+}
+
+// transitionCategory moves a category from one status to another,
+// refusing with 409 when it is not currently in the from status.
+func transitionCategory(w http.ResponseWriter, r *http.Request, from, to string) error {
+	id := parseIDAt(r.URL.Path, 1)
+	category, ok := findCategory(id)
+	if !ok {
+		return &statusError{CodeCategoryNotFound, "category not found"}
+	}
+	if category.Status != from {
+		return &statusError{CodeInvalidTransition, "category is already " + category.Status}
+	}
+
+	category.Status = to
+	touchCategory(category)
+	recordAudit(to, category.ID, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, category)
+	return nil
+}
+
+// GetTags godoc
+// @Summary Get all tags
+// @Description Lists every distinct tag with the number of categories using it, by name and paged:
+// @Description X-Total-Count has the number of tags and Link the next and prev pages.
+// @Tags Tag
+// @Produce json
+// @Param limit query int false "Page size (default PAGE_SIZE_DEFAULT, 20; at most PAGE_SIZE_MAX, 200)"
+// @Param offset query int false "Tags to skip"
+// @Param If-Modified-Since header string false "Answer 304 if nothing changed since this HTTP date"
+// @Success 200 {array} TagCount
+// @Success 304
+// @Failure 400 {string} string
+// @Router /tags [get]
+func GetTags(w http.ResponseWriter, r *http.Request) error {
+	if checkNotModified(w, r, cacheRouteTags, storeModified) {
+		return nil
+	}
+	page, err := parsePage(r.URL.Query(), defaultPageSize, maxPageSize)
+	if err != nil {
+		return err
+	}
+
+	p := requestPrincipal(r)
+	counts := map[string]int{}
+	for _, c := range categories {
+		if c.DeletedAt != nil || !canReadCategory(p, c) {
+			continue
+		}
+		for _, t := range c.Tags {
+			counts[t]++
+		}
+	}
+
+	result := []TagCount{}
+	for t, n := range counts {
+		result = append(result, TagCount{Tag: t, Count: n})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Tag < result[j].Tag })
+	setPageHeaders(w, r, page, len(result))
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, paginate(result, page))
+	return nil
+}
+
+// =======================
+// VALIDATION
+// =======================
+
+// tagPattern allows lowercase letters, digits and inner hyphens, e.g. "promo" or "back-to-school".
+var tagPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+const maxTagLength = 32
+
+// normalizeTags trims and lowercases tags, drops duplicates and rejects
+// anything that doesn't match tagPattern.
+func normalizeTags(tags []string) ([]string, error) {
+	result := []string{}
+	seen := map[string]bool{}
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if len(t) > maxTagLength || !tagPattern.MatchString(t) {
+			return nil, fmt.Errorf("invalid tag %q: use lowercase letters, digits and hyphens (max %d chars)", t, maxTagLength)
+		}
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		result = append(result, t)
+	}
+	return result, nil
+}
+
+// HTML policies for free-text fields, selected with HTML_POLICY.
+const (
+	// HTMLReject refuses names and descriptions that contain markup.
+	HTMLReject = "reject"
+	// HTMLStrip removes tags, along with the contents of script and style.
+	HTMLStrip = "strip"
+	// HTMLEscape stores markup as escaped text, e.g. "&lt;b&gt;".
+	HTMLEscape = "escape"
+	// HTMLAllow stores values as sent. Only safe if every client escapes on output.
+	HTMLAllow = "allow"
+)
+
+var htmlPolicy = HTMLReject
+
+var (
+	// htmlTagPattern matches tags, comments and doctypes, but not a lone "<"
+	// as in "a < b" or a Markdown autolink such as <https://example.com>.
+	htmlTagPattern = regexp.MustCompile(`<(/?[a-zA-Z][a-zA-Z0-9-]*(\s[^>]*)?/?|!--.*?--|![a-zA-Z][^>]*)>`)
+	// htmlRawPattern matches elements whose content must go with them.
+	htmlRawPattern = regexp.MustCompile(`(?is)<(script|style)\b[^>]*>.*?</(script|style)\s*>`)
+)
+
+// sanitizeText applies htmlPolicy to one free-text field, stored back in
+// place. field names the field in the error.
+func sanitizeText(field string, value *string) error {
+	if !htmlTagPattern.MatchString(*value) {
+		return nil
+	}
+	switch htmlPolicy {
+	case HTMLReject:
+		return &statusError{CodeValidationFailed, field + " must not contain HTML"}
+	case HTMLStrip:
+		stripped := htmlRawPattern.ReplaceAllString(*value, "")
+		*value = strings.TrimSpace(htmlTagPattern.ReplaceAllString(stripped, ""))
+	case HTMLEscape:
+		*value = html.EscapeString(*value)
+	}
+	return nil
+}
+
+// sanitizeNameAndDescription is sanitizeText for the two free-text fields
+// every model has.
+func sanitizeNameAndDescription(name, description *string) error {
+	if err := sanitizeText("name", name); err != nil {
+		return err
+	}
+	return sanitizeText("description", description)
+}
+
+func hasTag(c *Category, tag string) bool {
+	for _, t := range c.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
 }
 
 // =======================
 // ROUTER HELPER
 // =======================
 
+// statusError is an error that knows which error code, and so which HTTP
+// status, it should be answered with.
+type statusError struct {
+	code ErrorCode
+	msg  string
+}
+
+func (e *statusError) Error() string { return e.msg }
+
+// writeError answers with the code carried by err, or INTERNAL for anything else.
+func writeError(w http.ResponseWriter, err error) {
+	if se, ok := err.(*statusError); ok {
+		writeAPIError(w, se.code, se.msg)
+		return
+	}
+	writeAPIError(w, CodeInternal, err.Error())
+}
+
+// jsonBuffers recycles encoding buffers across requests; list endpoints are
+// the hot path and would otherwise allocate a fresh buffer per response.
+var jsonBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// maxPooledBuffer keeps one very large response from pinning its buffer.
+const maxPooledBuffer = 1 << 20
+
+// encodeJSON writes v to w as JSON, encoding into a pooled buffer first so
+// nothing partial goes out on an encoding error and large responses carry a
+// Content-Length instead of being chunked.
+func encodeJSON(w io.Writer, v interface{}) error {
+	buf := jsonBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBuffer {
+			jsonBuffers.Put(buf)
+		}
+	}()
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return err
+	}
+	if rw, ok := w.(http.ResponseWriter); ok {
+		rw.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
 func parseID(path string) int {
 	parts := strings.Split(path, "/")
 	id, _ := strconv.Atoi(parts[len(parts)-1])
 	return id
 }
 
+// pathParts splits "/categories/1/products/2" into ["categories", "1", "products", "2"].
+func pathParts(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// parseIDAt returns the numeric path segment at index, or 0 if there is none.
+func parseIDAt(path string, index int) int {
+	parts := pathParts(path)
+	if index >= len(parts) {
+		return 0
+	}
+	id, _ := strconv.Atoi(parts[index])
+	return id
+}
+
+// =======================
+// CONFIG
+// =======================
+
+// envBool reads a boolean env var, exiting on values strconv can't parse.
+func envBool(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("invalid %s %q: %v", name, v, err)
+	}
+	return b
+}
+
+// envInt reads an integer env var.
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("invalid %s %q: %v", name, v, err)
+	}
+	return n
+}
+
+func envFloat(name string, def float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Fatalf("invalid %s %q: %v", name, v, err)
+	}
+	return n
+}
+
+// envDuration reads a duration env var such as "90s" or "720h".
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("invalid %s %q: %v", name, v, err)
+	}
+	return d
+}
+
 // =======================
 // MAIN
 // =======================
@@ -166,42 +1167,474 @@ func parseID(path string) int {
 // @host localhost:8080
 // @BasePath /
 func main() {
+	launchEnv = os.Environ()
 	_ = godotenv.Load()
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
+	switch mode := os.Getenv("CATEGORY_DELETE_MODE"); mode {
+	case "":
+	case DeleteModeUnlink, DeleteModeBlock:
+		categoryDeleteMode = mode
+	default:
+		log.Fatalf("invalid CATEGORY_DELETE_MODE %q: want %q or %q", mode, DeleteModeUnlink, DeleteModeBlock)
+	}
+	switch policy := os.Getenv("HTML_POLICY"); policy {
+	case "":
+	case HTMLReject, HTMLStrip, HTMLEscape, HTMLAllow:
+		htmlPolicy = policy
+	default:
+		log.Fatalf("invalid HTML_POLICY %q: want %q, %q, %q or %q", policy, HTMLReject, HTMLStrip, HTMLEscape, HTMLAllow)
+	}
+	softDelete = envBool("SOFT_DELETE", softDelete)
+	purgeRetention = envDuration("PURGE_RETENTION", purgeRetention)
+	changelogSize = envInt("CHANGELOG_SIZE", changelogSize)
+	outboxMaxPending = envInt("OUTBOX_MAX_PENDING", outboxMaxPending)
+	historyLimit = envInt("HISTORY_LIMIT", historyLimit)
+	attributesMaxKeys = envInt("ATTRIBUTES_MAX_KEYS", attributesMaxKeys)
+	attributesMaxBytes = envInt("ATTRIBUTES_MAX_BYTES", attributesMaxBytes)
+	jobWorkers = envInt("JOB_WORKERS", jobWorkers)
+	jobMaxAttempts = envInt("JOB_MAX_ATTEMPTS", jobMaxAttempts)
+	if path := os.Getenv("JOBS_FILE"); path != "" {
+		jobStore = fileJobPersister{path: path}
+	}
+	if dir := os.Getenv("REPORT_DIR"); dir != "" {
+		reportDir = dir
+	}
+	if dir := os.Getenv("DOWNLOAD_DIR"); dir != "" {
+		downloadDir = dir
+	}
+	downloadURLTTL = envDuration("DOWNLOAD_URL_TTL", downloadURLTTL)
+	downloadRetention = envDuration("DOWNLOAD_RETENTION", downloadRetention)
+
+	routes := routeGroup{mux: http.DefaultServeMux}
+	storeRoutes := routes.With(withStore, dryRun)
 
 	// health check
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("API is running"))
+	routes.Route("/", Home)
+	routes.Route("/favicon.ico", StaticAsset)
+	routes.Route("/static/", StaticAsset)
+	routes.Route("/ui/", AdminUI)
+	storeRoutes.Route("/categories", func(w http.ResponseWriter, r *http.Request) error {
+		switch r.Method {
+		case http.MethodGet:
+			return GetCategories(w, r)
+		case http.MethodPost:
+			return CreateCategory(w, r)
+		default:
+			return errRouteNotFound
+		}
 	})
-	http.HandleFunc("/categories", func(w http.ResponseWriter, r *http.Request) {
+
+	storeRoutes.Route("/categories/", func(w http.ResponseWriter, r *http.Request) error {
+		parts := pathParts(r.URL.Path)
+		if err := authorizeCategory(r, parts); err != nil {
+			return err
+		}
+		switch {
+		case len(parts) == 2 && parts[1] == "export":
+			switch r.Method {
+			case http.MethodGet:
+				return ExportCategories(w, r)
+			default:
+				return errRouteNotFound
+			}
+		case len(parts) == 2 && parts[1] == "import":
+			switch r.Method {
+			case http.MethodPost:
+				return ImportCategories(w, r)
+			default:
+				return errRouteNotFound
+			}
+		case len(parts) == 2 && parts[1] == "bulk":
+			switch r.Method {
+			case http.MethodPatch:
+				return PatchCategories(w, r)
+			default:
+				return errRouteNotFound
+			}
+		case len(parts) == 2 && parts[1] == "reorder":
+			switch r.Method {
+			case http.MethodPut:
+				return ReorderCategories(w, r)
+			default:
+				return errRouteNotFound
+			}
+		case len(parts) == 2:
+			switch r.Method {
+			case http.MethodGet:
+				return GetCategory(w, r)
+			case http.MethodPut:
+				return UpdateCategory(w, r)
+			case http.MethodDelete:
+				return DeleteCategory(w, r)
+			default:
+				return errRouteNotFound
+			}
+		case len(parts) == 3 && parts[2] == "clone":
+			switch r.Method {
+			case http.MethodPost:
+				return CloneCategory(w, r)
+			default:
+				return errRouteNotFound
+			}
+		case len(parts) == 3 && parts[2] == "archive":
+			switch r.Method {
+			case http.MethodPost:
+				return ArchiveCategory(w, r)
+			default:
+				return errRouteNotFound
+			}
+		case len(parts) == 3 && parts[2] == "unarchive":
+			switch r.Method {
+			case http.MethodPost:
+				return UnarchiveCategory(w, r)
+			default:
+				return errRouteNotFound
+			}
+		case len(parts) == 3 && parts[2] == "merge":
+			switch r.Method {
+			case http.MethodPost:
+				return MergeCategories(w, r)
+			default:
+				return errRouteNotFound
+			}
+		case len(parts) == 3 && parts[2] == "history":
+			switch r.Method {
+			case http.MethodGet:
+				return GetCategoryHistory(w, r)
+			default:
+				return errRouteNotFound
+			}
+		case len(parts) == 4 && parts[2] == "history":
+			switch r.Method {
+			case http.MethodGet:
+				return GetCategoryRevision(w, r)
+			default:
+				return errRouteNotFound
+			}
+		case len(parts) == 3 && parts[2] == "revert":
+			switch r.Method {
+			case http.MethodPost:
+				return RevertCategory(w, r)
+			default:
+				return errRouteNotFound
+			}
+		case len(parts) == 3 && parts[2] == "items":
+			switch r.Method {
+			case http.MethodGet:
+				return GetItems(w, r)
+			case http.MethodPost:
+				return CreateItem(w, r)
+			default:
+				return errRouteNotFound
+			}
+		case len(parts) == 4 && parts[2] == "items":
+			switch r.Method {
+			case http.MethodGet:
+				return GetItem(w, r)
+			case http.MethodPut:
+				return UpdateItem(w, r)
+			case http.MethodDelete:
+				return DeleteItem(w, r)
+			default:
+				return errRouteNotFound
+			}
+		case len(parts) == 3 && parts[2] == "products":
+			switch r.Method {
+			case http.MethodGet:
+				return GetCategoryProducts(w, r)
+			default:
+				return errRouteNotFound
+			}
+		case len(parts) == 4 && parts[2] == "products":
+			switch r.Method {
+			case http.MethodPost:
+				return LinkProduct(w, r)
+			case http.MethodDelete:
+				return UnlinkProduct(w, r)
+			default:
+				return errRouteNotFound
+			}
+		default:
+			return errRouteNotFound
+		}
+	})
+
+	// Changes take the store lock themselves, so it isn't held while long-polling.
+	routes.Route("/categories/changes", func(w http.ResponseWriter, r *http.Request) error {
 		switch r.Method {
 		case http.MethodGet:
-			GetCategories(w, r)
+			return GetCategoryChanges(w, r)
+		default:
+			return errRouteNotFound
+		}
+	})
+
+	// Existence checks only read, though sent as POST, so they take a read lock themselves.
+	routes.Route("/categories/exists", func(w http.ResponseWriter, r *http.Request) error {
+		switch r.Method {
 		case http.MethodPost:
-			CreateCategory(w, r)
+			return CheckCategoriesExist(w, r)
 		default:
-			http.NotFound(w, r)
+			return errRouteNotFound
 		}
 	})
 
-	http.HandleFunc("/categories/", func(w http.ResponseWriter, r *http.Request) {
+	// Search takes the store lock itself, so it isn't held while the cluster answers.
+	routes.Route("/categories/search", func(w http.ResponseWriter, r *http.Request) error {
 		switch r.Method {
 		case http.MethodGet:
-			GetCategory(w, r)
+			return SearchCategories(w, r)
+		default:
+			return errRouteNotFound
+		}
+	})
+
+	registerResourceRoutes(storeRoutes, "/products", GetProducts, CreateProduct, GetProduct, UpdateProduct, DeleteProduct)
+
+	storeRoutes.Route("/tags", func(w http.ResponseWriter, r *http.Request) error {
+		switch r.Method {
+		case http.MethodGet:
+			return GetTags(w, r)
+		default:
+			return errRouteNotFound
+		}
+	})
+
+	storeRoutes.Route("/audit", func(w http.ResponseWriter, r *http.Request) error {
+		switch r.Method {
+		case http.MethodGet:
+			return GetAuditLog(w, r)
+		default:
+			return errRouteNotFound
+		}
+	})
+
+	routes.Route("/exports", func(w http.ResponseWriter, r *http.Request) error {
+		switch r.Method {
+		case http.MethodPost:
+			return CreateExport(w, r)
+		default:
+			return errRouteNotFound
+		}
+	})
+
+	routes.Route("/exports/", func(w http.ResponseWriter, r *http.Request) error {
+		switch {
+		case len(pathParts(r.URL.Path)) == 2 && r.Method == http.MethodGet:
+			return GetExport(w, r)
+		default:
+			return errRouteNotFound
+		}
+	})
+
+	routes.Route("/operations/", func(w http.ResponseWriter, r *http.Request) error {
+		switch {
+		case len(pathParts(r.URL.Path)) == 2 && r.Method == http.MethodGet:
+			return GetOperation(w, r)
+		case len(pathParts(r.URL.Path)) == 3 && pathParts(r.URL.Path)[2] == "report" && r.Method == http.MethodGet:
+			return GetImportReport(w, r)
+		default:
+			return errRouteNotFound
+		}
+	})
+
+	routes.Route("/downloads/", func(w http.ResponseWriter, r *http.Request) error {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			return Download(w, r)
+		default:
+			return errRouteNotFound
+		}
+	})
+
+	storeRoutes.Route("/reports/categories.pdf", func(w http.ResponseWriter, r *http.Request) error {
+		switch r.Method {
+		case http.MethodGet:
+			return GetCategoryReport(w, r)
+		default:
+			return errRouteNotFound
+		}
+	})
+
+	storeRoutes.Route("/admin/purge", func(w http.ResponseWriter, r *http.Request) error {
+		switch r.Method {
+		case http.MethodPost:
+			return PurgeNow(w, r)
+		default:
+			return errRouteNotFound
+		}
+	})
+
+	storeRoutes.Route("/admin/archive", func(w http.ResponseWriter, r *http.Request) error {
+		switch r.Method {
+		case http.MethodGet:
+			return ExportArchive(w, r)
+		case http.MethodPost:
+			return ImportArchive(w, r)
+		default:
+			return errRouteNotFound
+		}
+	})
+
+	storeRoutes.Route("/admin/fields", func(w http.ResponseWriter, r *http.Request) error {
+		switch r.Method {
+		case http.MethodGet:
+			return GetCustomFields(w, r)
+		default:
+			return errRouteNotFound
+		}
+	})
+
+	storeRoutes.Route("/admin/fields/", func(w http.ResponseWriter, r *http.Request) error {
+		if len(pathParts(r.URL.Path)) != 3 {
+			return errRouteNotFound
+		}
+		switch r.Method {
+		case http.MethodPut:
+			return PutCustomField(w, r)
+		case http.MethodDelete:
+			return DeleteCustomField(w, r)
+		default:
+			return errRouteNotFound
+		}
+	})
+
+	// Not a storeRoutes route: a dry run couldn't roll settings back.
+	routes.With(withStore).Route("/admin/runtime", func(w http.ResponseWriter, r *http.Request) error {
+		switch r.Method {
+		case http.MethodGet:
+			return GetRuntimeSettings(w, r)
 		case http.MethodPut:
-			UpdateCategory(w, r)
+			return PutRuntimeSettings(w, r)
+		default:
+			return errRouteNotFound
+		}
+	})
+
+	routes.Route("/admin/recordings", func(w http.ResponseWriter, r *http.Request) error {
+		switch r.Method {
+		case http.MethodGet:
+			return GetRecordings(w, r)
 		case http.MethodDelete:
-			DeleteCategory(w, r)
+			return DeleteRecordings(w, r)
 		default:
-			http.NotFound(w, r)
+			return errRouteNotFound
 		}
 	})
 
-	http.Handle("/swagger/", httpSwagger.WrapHandler)
+	routes.Route("/admin/recordings/", func(w http.ResponseWriter, r *http.Request) error {
+		switch {
+		case len(pathParts(r.URL.Path)) == 3 && r.Method == http.MethodGet:
+			return GetRecording(w, r)
+		default:
+			return errRouteNotFound
+		}
+	})
+
+	routes.Route("/admin/analytics", func(w http.ResponseWriter, r *http.Request) error {
+		switch r.Method {
+		case http.MethodGet:
+			return GetAnalytics(w, r)
+		default:
+			return errRouteNotFound
+		}
+	})
+
+	routes.Route("/admin/search/reindex", func(w http.ResponseWriter, r *http.Request) error {
+		switch r.Method {
+		case http.MethodPost:
+			return ReindexSearch(w, r)
+		default:
+			return errRouteNotFound
+		}
+	})
+
+	routes.Route("/admin/jobs", func(w http.ResponseWriter, r *http.Request) error {
+		switch r.Method {
+		case http.MethodGet:
+			return GetJobs(w, r)
+		default:
+			return errRouteNotFound
+		}
+	})
+
+	routes.Route("/admin/jobs/", func(w http.ResponseWriter, r *http.Request) error {
+		parts := pathParts(r.URL.Path)
+		switch {
+		case len(parts) == 4 && parts[3] == "retry" && r.Method == http.MethodPost:
+			return RetryJob(w, r)
+		default:
+			return errRouteNotFound
+		}
+	})
+
+	routes.HandleFunc("/metrics", GetMetrics)
+	routes.HandleFunc("/errors", GetErrors)
+
+	routes.HandleFunc("/livez", Livez)
+	routes.Route("/readyz", Readyz)
+	routes.Route("/startupz", Startupz)
+	routes.Route("/version", GetVersion)
+	for _, v := range apiVersions() {
+		routes.Route("/"+v+"/openapi.json", GetOpenAPISpec)
+	}
+
+	routes.Handle("/swagger/", httpSwagger.WrapHandler)
+
+	configureCache()
+	requirePreconditions = envBool("REQUIRE_PRECONDITIONS", requirePreconditions)
+	configureLocks()
+	leaderElection = envBool("LEADER_ELECTION", leaderElection)
+	leaderLease = envDuration("LEADER_LEASE", leaderLease)
+	configureEmail()
+	configureChat()
+	configureBreakers()
+	configureSearch()
+	configurePagination()
+	configureExport()
+	configureDownloads()
+	configureTLS()
+	configureListener()
+	configureAdmin()
+	configureReadOnly()
+	configureFieldCase()
+	configureChaos()
+	configureProxies()
+	configureACL()
+	configureIDs()
+	configureRateLimit()
+	configureRecording()
+	serverErrorThreshold = envInt("ALERT_5XX_THRESHOLD", serverErrorThreshold)
+	serverErrorWindow = envDuration("ALERT_5XX_WINDOW", serverErrorWindow)
+	slowRequestThreshold = envDuration("SLOW_REQUEST_THRESHOLD", slowRequestThreshold)
+	configureTimeouts()
+	maxDecompressedBody = envInt("MAX_DECOMPRESSED_BODY", maxDecompressedBody)
+	staticMaxAge = envDuration("STATIC_MAX_AGE", staticMaxAge)
+	shutdownDelay = envDuration("SHUTDOWN_DELAY", shutdownDelay)
+	shutdownTimeout = envDuration("SHUTDOWN_TIMEOUT", shutdownTimeout)
+	shutdownHookTimeout = envDuration("SHUTDOWN_HOOK_TIMEOUT", shutdownHookTimeout)
+	healthCheckTimeout = envDuration("HEALTH_CHECK_TIMEOUT", healthCheckTimeout)
+	if _, err := shutdownOrder(); err != nil {
+		log.Fatal(err)
+	}
+	analyticsResolution = envDuration("ANALYTICS_RESOLUTION", analyticsResolution)
+	if analyticsResolution <= 0 {
+		log.Fatalf("invalid ANALYTICS_RESOLUTION %s: must be positive", analyticsResolution)
+	}
+	analyticsRetention = envDuration("ANALYTICS_RETENTION", analyticsRetention)
+	analyticsMaxClients = envInt("ANALYTICS_MAX_CLIENTS", analyticsMaxClients)
+	if v := os.Getenv("ANALYTICS_CLIENT_HEADER"); v != "" {
+		analyticsClientHeader = v
+	}
+
+	log.Printf("simple-crud %s (commit %s, built %s, %s %s)", buildInfo.Version, buildInfo.Commit, buildInfo.BuildDate, buildInfo.GoVersion, buildInfo.Platform)
+	startJobWorkers()
+	go runOutboxRelay()
+	startLeaderElection()
+	startScheduler()
 
-	log.Println("server running at :", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	serve(":"+port, Chain{observeRequests, clientCertIdentity, recordRequests, limitRate, recordUsage, injectFaults, trackServerErrors, recoverPanics, deprecations, rejectWrites, decompressRequests, enforceTimeouts, negotiateEncoding, convertFieldCase, respondAsync, localize}.Then(http.DefaultServeMux))
 }
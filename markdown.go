@@ -0,0 +1,39 @@
+package main
+
+import (
+	bf "github.com/russross/blackfriday/v2"
+)
+
+// =======================
+// MARKDOWN
+// =======================
+
+// RenderedCategory is a category with its Markdown description rendered,
+// returned for ?render=html.
+type RenderedCategory struct {
+	*Category
+	DescriptionHTML string `json:"description_html"`
+}
+
+// markdownFlags drop any raw HTML in the source and only turn safe URL
+// schemes into links, so the output can be inserted into a page as is.
+const markdownFlags = bf.SkipHTML | bf.Safelink | bf.NofollowLinks | bf.NoreferrerLinks | bf.HrefTargetBlank
+
+// renderMarkdown turns a description into display HTML. Renderers keep
+// state, so each call gets its own.
+func renderMarkdown(src string) string {
+	renderer := bf.NewHTMLRenderer(bf.HTMLRendererParameters{Flags: markdownFlags})
+	return string(bf.Run([]byte(src), bf.WithRenderer(renderer)))
+}
+
+// parseRender validates the render query parameter; "" means raw.
+func parseRender(render string) error {
+	if render != "" && render != "html" {
+		return &statusError{CodeValidationFailed, "render must be html"}
+	}
+	return nil
+}
+
+func renderCategory(c *Category) RenderedCategory {
+	return RenderedCategory{Category: c, DescriptionHTML: renderMarkdown(c.Description)}
+}
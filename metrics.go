@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// =======================
+// METRICS
+// =======================
+
+// metricFamily is one named metric with a value per label set, rendered in
+// the Prometheus text exposition format.
+type metricFamily struct {
+	name   string
+	kind   string // "counter", "gauge" or "histogram"
+	help   string
+	values map[string]float64 // rendered label set, e.g. `{trigger="manual"}`
+
+	buckets    []float64             // histogram upper bounds, ascending
+	histograms map[string]*histogram // per rendered label set
+}
+
+type histogram struct {
+	labels []string
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// latencyBuckets are the default histogram bounds, in seconds.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	metricsMu      sync.Mutex
+	metricFamilies = map[string]*metricFamily{}
+)
+
+func registerMetric(name, kind, help string) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if _, ok := metricFamilies[name]; !ok {
+		metricFamilies[name] = &metricFamily{name: name, kind: kind, help: help, values: map[string]float64{}}
+	}
+}
+
+func registerHistogram(name, help string, buckets []float64) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if _, ok := metricFamilies[name]; !ok {
+		metricFamilies[name] = &metricFamily{
+			name:       name,
+			kind:       "histogram",
+			help:       help,
+			buckets:    buckets,
+			histograms: map[string]*histogram{},
+		}
+	}
+}
+
+// observeMetric records one sample in a registered histogram.
+func observeMetric(name string, value float64, labels ...string) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	m, ok := metricFamilies[name]
+	if !ok || m.kind != "histogram" {
+		return
+	}
+	key := renderLabels(labels)
+	h, ok := m.histograms[key]
+	if !ok {
+		h = &histogram{labels: labels, counts: make([]uint64, len(m.buckets))}
+		m.histograms[key] = h
+	}
+	for i, bound := range m.buckets {
+		if value <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// addMetric adds delta to a registered metric. labels are key/value pairs.
+func addMetric(name string, delta float64, labels ...string) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if m, ok := metricFamilies[name]; ok {
+		m.values[renderLabels(labels)] += delta
+	}
+}
+
+// setMetric overwrites the value of a registered gauge.
+func setMetric(name string, value float64, labels ...string) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if m, ok := metricFamilies[name]; ok {
+		m.values[renderLabels(labels)] = value
+	}
+}
+
+func renderLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := []string{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// GetMetrics godoc
+// @Summary Prometheus metrics
+// @Tags Admin
+// @Produce plain
+// @Success 200 {string} string
+// @Router /metrics [get]
+func GetMetrics(w http.ResponseWriter, r *http.Request) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	names := []string{}
+	for name := range metricFamilies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, name := range names {
+		m := metricFamilies[name]
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+
+		if m.kind == "histogram" {
+			writeHistograms(w, m)
+			continue
+		}
+
+		keys := []string{}
+		for k := range m.values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s%s %g\n", m.name, k, m.values[k])
+		}
+	}
+}
+
+func writeHistograms(w http.ResponseWriter, m *metricFamily) {
+	keys := []string{}
+	for k := range m.histograms {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h := m.histograms[k]
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += h.counts[i]
+			le := append(append([]string{}, h.labels...), "le", fmt.Sprintf("%g", bound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, renderLabels(le), cumulative)
+		}
+		inf := append(append([]string{}, h.labels...), "le", "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, renderLabels(inf), h.count)
+		fmt.Fprintf(w, "%s_sum%s %g\n", m.name, k, h.sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, k, h.count)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =======================
+// MIDDLEWARE
+// =======================
+
+// Middleware wraps a handler with cross-cutting behaviour (locking, logging,
+// recovery, ...).
+type Middleware func(http.Handler) http.Handler
+
+// Chain is a list of middleware; the first one listed runs outermost.
+type Chain []Middleware
+
+// Then wraps h in every middleware of the chain.
+func (c Chain) Then(h http.Handler) http.Handler {
+	for i := len(c) - 1; i >= 0; i-- {
+		h = c[i](h)
+	}
+	return h
+}
+
+// routeGroup registers routes on a mux, each wrapped in the group's chain,
+// so middleware is applied per group rather than by hand on every route.
+type routeGroup struct {
+	mux   *http.ServeMux
+	chain Chain
+}
+
+// With returns a group that adds mw, inside the current chain.
+func (g routeGroup) With(mw ...Middleware) routeGroup {
+	return routeGroup{mux: g.mux, chain: append(append(Chain{}, g.chain...), mw...)}
+}
+
+func (g routeGroup) Handle(pattern string, h http.Handler) {
+	g.mux.Handle(pattern, g.chain.Then(h))
+}
+
+func (g routeGroup) HandleFunc(pattern string, h http.HandlerFunc) {
+	g.Handle(pattern, h)
+}
+
+// Route registers an apiHandler, mapping its errors through writeError.
+func (g routeGroup) Route(pattern string, h apiHandler) {
+	g.Handle(pattern, handle(h))
+}
+
+// recoverPanics answers a panicking handler with a 500 instead of dropping
+// the connection, and logs the stack.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if p := recover(); p != nil {
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				log.Printf("panic serving %s %s: %v\n%s", r.Method, r.URL.Path, p, debug.Stack())
+				writeAPIError(w, CodeInternal, "internal server error")
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder remembers the status code and body size a handler wrote.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// statusOrOK is the status that reached the client; handlers that write
+// nothing at all get an implicit 200.
+func (rec *statusRecorder) statusOrOK() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+// slowRequestThreshold logs requests that take longer than this
+// (SLOW_REQUEST_THRESHOLD, 0 disables the log). Guarded by runtimeMu.
+var slowRequestThreshold = time.Second
+
+func init() {
+	registerHistogram("http_request_duration_seconds", "Request latency by route, method and status.", latencyBuckets)
+	registerRuntimeDuration("slow_request_threshold", "Requests slower than this are logged; lower it to see more, 0 turns the log off.",
+		func() time.Duration { return slowRequestThreshold },
+		func(d time.Duration) { slowRequestThreshold = d })
+}
+
+// observeRequests records latency per route and status, and logs slow requests.
+func observeRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		elapsed := time.Since(start)
+
+		route := routeLabel(r.URL.Path)
+		status := rec.statusOrOK()
+		observeMetric("http_request_duration_seconds", elapsed.Seconds(),
+			"route", route, "method", r.Method, "status", strconv.Itoa(status))
+
+		runtimeMu.RLock()
+		threshold := slowRequestThreshold
+		runtimeMu.RUnlock()
+		if threshold > 0 && elapsed >= threshold {
+			log.Printf("slow request: %s %s route=%s query=%q status=%d duration=%s bytes_in=%d bytes_out=%d remote=%s user_agent=%q",
+				r.Method, r.URL.Path, route, r.URL.RawQuery, status, elapsed, r.ContentLength, rec.bytes, clientIP(r), r.UserAgent())
+		}
+	})
+}
+
+// routeLabel turns a request path into a low-cardinality route name:
+// numeric segments become {id} and unknown paths collapse to "/other".
+func routeLabel(path string) string {
+	parts := pathParts(path)
+	switch parts[0] {
+	case "":
+		return "/"
+	case "swagger":
+		return "/swagger/*"
+	case "static", "ui", "downloads":
+		return "/" + parts[0] + "/*"
+	case "categories", "products", "tags", "audit", "reports", "exports", "operations", "admin", "metrics", "errors", "livez", "readyz", "startupz", "version", "favicon.ico":
+	default:
+		if !isSpecPath(path) {
+			return "/other"
+		}
+	}
+	for i, p := range parts {
+		if _, err := strconv.Atoi(p); err == nil {
+			parts[i] = "{id}"
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+var (
+	// serverErrorThreshold is how many 5xx responses within
+	// serverErrorWindow raise a server.errors notification
+	// (ALERT_5XX_THRESHOLD, ALERT_5XX_WINDOW).
+	serverErrorThreshold = 5
+	serverErrorWindow    = time.Minute
+
+	serverErrorsMu sync.Mutex
+	serverErrors   []time.Time
+)
+
+// trackServerErrors notifies once whenever serverErrorThreshold 5xx
+// responses pile up inside serverErrorWindow.
+func trackServerErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.statusOrOK() < 500 || serverErrorThreshold <= 0 {
+			return
+		}
+
+		now := time.Now()
+		serverErrorsMu.Lock()
+		recent := []time.Time{}
+		for _, t := range serverErrors {
+			if now.Sub(t) < serverErrorWindow {
+				recent = append(recent, t)
+			}
+		}
+		serverErrors = append(recent, now)
+		count := len(serverErrors)
+		if count >= serverErrorThreshold {
+			serverErrors = nil
+		}
+		serverErrorsMu.Unlock()
+
+		if count >= serverErrorThreshold {
+			notify(EventServerErrors, map[string]interface{}{
+				"count":  count,
+				"window": serverErrorWindow.String(),
+				"method": r.Method,
+				"path":   r.URL.Path,
+				"status": rec.status,
+			})
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// =======================
+// NOTIFICATIONS
+// =======================
+
+// Notification events.
+const (
+	EventCategoryDeleted = "category.deleted"
+	EventCategoryMerged  = "category.merged"
+	EventPurgeCompleted  = "purge.completed"
+	EventServerErrors    = "server.errors"
+)
+
+// eventTemplates holds a one-line summary (email subject, chat message) and a
+// longer body for each event. Templates see the event data map.
+var eventTemplates = map[string][2]*template.Template{
+	EventCategoryDeleted: mustEventTemplate(
+		`Category "{{.name}}" was deleted`,
+		"Category #{{.id}} \"{{.name}}\" was deleted at {{.at}}.\n",
+	),
+	EventCategoryMerged: mustEventTemplate(
+		`Categories {{.source_ids}} merged into "{{.name}}"`,
+		"Categories {{.source_ids}} were merged into #{{.id}} \"{{.name}}\" at {{.at}}.\n",
+	),
+	EventPurgeCompleted: mustEventTemplate(
+		`Purged {{.categories}} deleted categories`,
+		"The purge at {{.at}} permanently removed {{.categories}} categories and {{.items}} items.\n",
+	),
+	EventServerErrors: mustEventTemplate(
+		`{{.count}} server errors in the last {{.window}}`,
+		"{{.count}} requests failed with 5xx in the {{.window}} before {{.at}}.\nLatest: {{.method}} {{.path}} -> {{.status}}\n",
+	),
+}
+
+func mustEventTemplate(summary, body string) [2]*template.Template {
+	return [2]*template.Template{
+		template.Must(template.New("summary").Parse(summary)),
+		template.Must(template.New("body").Parse(body)),
+	}
+}
+
+// renderEvent fills in the summary and body templates for event.
+func renderEvent(event string, data map[string]interface{}) (string, string, error) {
+	tmpl, ok := eventTemplates[event]
+	if !ok {
+		return "", "", fmt.Errorf("no template for event %q", event)
+	}
+	if data["at"] == nil {
+		data["at"] = time.Now().UTC().Format(time.RFC1123)
+	}
+
+	var summary, body bytes.Buffer
+	if err := tmpl[0].Execute(&summary, data); err != nil {
+		return "", "", fmt.Errorf("%s summary: %v", event, err)
+	}
+	if err := tmpl[1].Execute(&body, data); err != nil {
+		return "", "", fmt.Errorf("%s body: %v", event, err)
+	}
+	return summary.String(), body.String(), nil
+}
+
+// Notifier delivers events to people. Implementations must not block: hand
+// slow work to the job queue.
+type Notifier interface {
+	Notify(event string, data map[string]interface{})
+}
+
+var notifiers = []Notifier{}
+
+// notify fans an event out to every configured notifier. Inside withTx the
+// event is held back until the transaction commits.
+func notify(event string, data map[string]interface{}) {
+	if txDepth > 0 {
+		afterCommit(func() { notify(event, data) })
+		return
+	}
+	for _, n := range notifiers {
+		n.Notify(event, data)
+	}
+}
+
+// splitList parses comma separated config such as "a@x.com, b@x.com".
+func splitList(v string) []string {
+	result := []string{}
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
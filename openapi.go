@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"simple-crud/docs"
+)
+
+// =======================
+// OPENAPI SPECS
+// =======================
+
+// apiSpecs are the OpenAPI documents of the supported API versions, served at
+// /<version>/openapi.json. Each is generated by swag init at build time and
+// compiled in, so a client gets the contract of the binary it talks to.
+// Only v1 exists today; a v2 gets its own instance (swag init --instanceName
+// v2 with its own output dir) added here next to v1, which keeps the
+// document it was released with.
+var apiSpecs = map[string]func() string{
+	"v1": docs.SwaggerInfo.ReadDoc,
+}
+
+// apiVersions lists the keys of apiSpecs in order.
+func apiVersions() []string {
+	versions := make([]string, 0, len(apiSpecs))
+	for v := range apiSpecs {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// isSpecPath reports whether path is /<version>/openapi.json of a supported
+// version.
+func isSpecPath(path string) bool {
+	parts := pathParts(path)
+	_, ok := apiSpecs[parts[0]]
+	return ok && len(parts) == 2 && parts[1] == "openapi.json"
+}
+
+// GetOpenAPISpec godoc
+// @Summary OpenAPI document of an API version
+// @Description The Swagger 2.0 document of one API version as generated at build time, e.g. /v1/openapi.json, for
+// @Description pinning client generators and contract tests to the version they call. It only changes with a new build
+// @Description and is served with an ETag.
+// @Tags Health
+// @Produce json
+// @Success 200 {object} object
+// @Failure 404 {string} string
+// @Router /v1/openapi.json [get]
+func GetOpenAPISpec(w http.ResponseWriter, r *http.Request) error {
+	version := pathParts(r.URL.Path)[0]
+	spec, ok := apiSpecs[version]
+	if !ok || !isSpecPath(r.URL.Path) {
+		return &statusError{CodeRouteNotFound, fmt.Sprintf("no OpenAPI document for %s; supported versions: %s", version, strings.Join(apiVersions(), ", "))}
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return &statusError{CodeMethodNotAllowed, "Method not allowed"}
+	}
+	doc := []byte(spec())
+	sum := sha256.Sum256(doc)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+	http.ServeContent(w, r, "openapi.json", time.Time{}, bytes.NewReader(doc))
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// =======================
+// PAGINATION
+// =======================
+
+var (
+	// defaultPageSize is the limit of list and search requests that don't
+	// send one (PAGE_SIZE_DEFAULT).
+	defaultPageSize = 20
+	// maxPageSize is the largest limit they accept (PAGE_SIZE_MAX).
+	maxPageSize = 200
+	// maxExportRows caps the rows of one export, and is also its default
+	// limit (EXPORT_MAX_ROWS); bigger stores are exported in pages.
+	maxExportRows = 10000
+)
+
+// configurePagination reads PAGE_SIZE_DEFAULT, PAGE_SIZE_MAX and
+// EXPORT_MAX_ROWS.
+func configurePagination() {
+	defaultPageSize = envInt("PAGE_SIZE_DEFAULT", defaultPageSize)
+	maxPageSize = envInt("PAGE_SIZE_MAX", maxPageSize)
+	maxExportRows = envInt("EXPORT_MAX_ROWS", maxExportRows)
+	if defaultPageSize < 1 || maxPageSize < defaultPageSize {
+		log.Fatalf("PAGE_SIZE_DEFAULT must be at least 1 and at most PAGE_SIZE_MAX (%d), got %d", maxPageSize, defaultPageSize)
+	}
+	if maxExportRows < 1 {
+		log.Fatalf("EXPORT_MAX_ROWS must be at least 1, got %d", maxExportRows)
+	}
+}
+
+// Page is the window of a list a request asked for with limit and offset.
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+// parsePage reads limit and offset, rejecting a limit above maxLimit with 400
+// rather than quietly returning less than was asked for.
+func parsePage(q url.Values, def, maxLimit int) (Page, error) {
+	p := Page{Limit: def}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLimit {
+			return Page{}, &statusError{CodeValidationFailed, fmt.Sprintf("limit must be between 1 and %d", maxLimit)}
+		}
+		p.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return Page{}, &statusError{CodeValidationFailed, "offset must be a non-negative integer"}
+		}
+		p.Offset = n
+	}
+	return p, nil
+}
+
+// paginate cuts the page out of list.
+func paginate[T any](list []T, p Page) []T {
+	start := min(p.Offset, len(list))
+	return list[start:min(start+p.Limit, len(list))]
+}
+
+// setPageHeaders reports the total in X-Total-Count and links the next and
+// previous pages in Link, keeping the other query parameters.
+func setPageHeaders(w http.ResponseWriter, r *http.Request, p Page, total int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	link := func(offset int, rel string) {
+		q := r.URL.Query()
+		q.Set("limit", strconv.Itoa(p.Limit))
+		q.Set("offset", strconv.Itoa(offset))
+		w.Header().Add("Link", fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, q.Encode(), rel))
+	}
+	if p.Offset+p.Limit < total {
+		link(p.Offset+p.Limit, "next")
+	}
+	if p.Offset > 0 {
+		link(max(0, p.Offset-p.Limit), "prev")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestParsePage(t *testing.T) {
+	tests := []struct {
+		query string
+		want  Page
+		err   bool
+	}{
+		{"", Page{Limit: 20}, false},
+		{"limit=5", Page{Limit: 5}, false},
+		{"limit=200&offset=40", Page{Limit: 200, Offset: 40}, false},
+		{"offset=0", Page{Limit: 20}, false},
+		{"limit=0", Page{}, true},
+		{"limit=201", Page{}, true},
+		{"limit=-1", Page{}, true},
+		{"limit=ten", Page{}, true},
+		{"offset=-1", Page{}, true},
+		{"offset=x", Page{}, true},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		got, err := parsePage(q, 20, 200)
+		if (err != nil) != tt.err {
+			t.Errorf("parsePage(%q) error = %v, want error %v", tt.query, err, tt.err)
+			continue
+		}
+		if se, ok := err.(*statusError); err != nil && (!ok || se.code != CodeValidationFailed) {
+			t.Errorf("parsePage(%q) error = %v, want %s", tt.query, err, CodeValidationFailed)
+		}
+		if got != tt.want {
+			t.Errorf("parsePage(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	list := []int{1, 2, 3, 4, 5}
+	tests := []struct {
+		page Page
+		want []int
+	}{
+		{Page{Limit: 2}, []int{1, 2}},
+		{Page{Limit: 2, Offset: 4}, []int{5}},
+		{Page{Limit: 10, Offset: 1}, []int{2, 3, 4, 5}},
+		{Page{Limit: 2, Offset: 5}, []int{}},
+		{Page{Limit: 2, Offset: 50}, []int{}},
+	}
+	for _, tt := range tests {
+		if got := paginate(list, tt.page); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("paginate(%+v) = %v, want %v", tt.page, got, tt.want)
+		}
+	}
+}
+
+func TestSetPageHeaders(t *testing.T) {
+	tests := []struct {
+		page  Page
+		total int
+		links []string
+	}{
+		{Page{Limit: 2}, 2, nil},
+		{Page{Limit: 2}, 5, []string{`</tags?limit=2&offset=2&q=x>; rel="next"`}},
+		{Page{Limit: 2, Offset: 2}, 5, []string{`</tags?limit=2&offset=4&q=x>; rel="next"`, `</tags?limit=2&offset=0&q=x>; rel="prev"`}},
+		{Page{Limit: 2, Offset: 1}, 3, []string{`</tags?limit=2&offset=0&q=x>; rel="prev"`}},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		setPageHeaders(w, httptest.NewRequest("GET", "/tags?q=x", nil), tt.page, tt.total)
+		if got := w.Header().Get("X-Total-Count"); got != strconv.Itoa(tt.total) {
+			t.Errorf("%+v: X-Total-Count = %q, want %d", tt.page, got, tt.total)
+		}
+		if got := w.Header().Values("Link"); !reflect.DeepEqual(got, tt.links) {
+			t.Errorf("%+v of %d: Link = %q, want %q", tt.page, tt.total, got, tt.links)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// =======================
+// MODEL
+// =======================
+
+type Product struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	CategoryIDs []int  `json:"category_ids"`
+}
+
+// =======================
+// STORAGE (fake DB)
+// =======================
+
+var (
+	products      = map[int]*Product{}
+	productAutoID = 1
+)
+
+// Category delete modes, selected with CATEGORY_DELETE_MODE.
+const (
+	// DeleteModeUnlink removes the category from every product linked to it.
+	DeleteModeUnlink = "unlink"
+	// DeleteModeBlock refuses to delete a category that still has products.
+	DeleteModeBlock = "block"
+)
+
+var categoryDeleteMode = DeleteModeUnlink
+
+// =======================
+// HANDLER
+// =======================
+
+// productResource serves the plain CRUD routes; links to categories have
+// their own endpoints below.
+var productResource = &Resource[Product]{
+	Name:     "product",
+	NotFound: CodeProductNotFound,
+	Store:    products,
+	NextID:   &productAutoID,
+	ID:       func(p *Product) int { return p.ID },
+	SetID:    func(p *Product, id int) { p.ID = id },
+	Prepare:  func(p *Product) { p.CategoryIDs = []int{} },
+	Apply: func(dst, src *Product) {
+		dst.Name = src.Name
+		dst.Description = src.Description
+	},
+	Validate: func(p *Product) error {
+		return sanitizeNameAndDescription(&p.Name, &p.Description)
+	},
+	Hooks: ProductHooks,
+}
+
+// GetProducts godoc
+// @Summary Get all products
+// @Description Ordered by ID and paged: X-Total-Count has the number of products and Link the next and prev pages.
+// @Tags Product
+// @Produce json
+// @Param limit query int false "Page size (default PAGE_SIZE_DEFAULT, 20; at most PAGE_SIZE_MAX, 200)"
+// @Param offset query int false "Products to skip"
+// @Success 200 {array} Product
+// @Failure 400 {string} string
+// @Router /products [get]
+func GetProducts(w http.ResponseWriter, r *http.Request) error {
+	return productResource.List(w, r)
+}
+
+// CreateProduct godoc
+// @Summary Create product
+// @Description category_ids is ignored; use the category link endpoints instead.
+// @Tags Product
+// @Accept json
+// @Produce json
+// @Param body body Product true "Product"
+// @Param dry_run query bool false "Validate and return the would-be response without saving anything"
+// @Success 201 {object} Product
+// @Failure 400 {string} string
+// @Router /products [post]
+func CreateProduct(w http.ResponseWriter, r *http.Request) error {
+	return productResource.Create(w, r)
+}
+
+// GetProduct godoc
+// @Summary Get product detail
+// @Tags Product
+// @Produce json
+// @Param id path int true "Product ID"
+// @Success 200 {object} Product
+// @Failure 404 {string} string
+// @Router /products/{id} [get]
+func GetProduct(w http.ResponseWriter, r *http.Request) error {
+	return productResource.Get(w, r)
+}
+
+// UpdateProduct godoc
+// @Summary Update product
+// @Tags Product
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param body body Product true "Product"
+// @Param dry_run query bool false "Validate and return the would-be response without saving anything"
+// @Success 200 {object} Product
+// @Failure 400 {string} string
+// @Failure 404 {string} string
+// @Router /products/{id} [put]
+func UpdateProduct(w http.ResponseWriter, r *http.Request) error {
+	return productResource.Update(w, r)
+}
+
+// DeleteProduct godoc
+// @Summary Delete product
+// @Tags Product
+// @Param id path int true "Product ID"
+// @Param dry_run query bool false "Validate and return the would-be response without saving anything"
+// @Success 204
+// @Failure 404 {string} string
+// @Router /products/{id} [delete]
+func DeleteProduct(w http.ResponseWriter, r *http.Request) error {
+	return productResource.Delete(w, r)
+}
+
+// GetCategoryProducts godoc
+// @Summary Get products in category
+// @Description Ordered by ID and paged: X-Total-Count has the number of products and Link the next and prev pages.
+// @Tags Category
+// @Produce json
+// @Param id path int true "Category ID"
+// @Param limit query int false "Page size (default PAGE_SIZE_DEFAULT, 20; at most PAGE_SIZE_MAX, 200)"
+// @Param offset query int false "Products to skip"
+// @Success 200 {array} Product
+// @Failure 400 {string} string
+// @Failure 404 {string} string
+// @Router /categories/{id}/products [get]
+func GetCategoryProducts(w http.ResponseWriter, r *http.Request) error {
+	id := parseIDAt(r.URL.Path, 1)
+	if _, ok := findCategory(id); !ok {
+		return &statusError{CodeCategoryNotFound, "category not found"}
+	}
+	page, err := parsePage(r.URL.Query(), defaultPageSize, maxPageSize)
+	if err != nil {
+		return err
+	}
+
+	result := productsInCategory(id)
+	setPageHeaders(w, r, page, len(result))
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, paginate(result, page))
+	return nil
+}
+
+// LinkProduct godoc
+// @Summary Add product to category
+// @Tags Category
+// @Produce json
+// @Param id path int true "Category ID"
+// @Param pid path int true "Product ID"
+// @Success 200 {object} Product
+// @Failure 404 {string} string
+// @Failure 409 {string} string
+// @Router /categories/{id}/products/{pid} [post]
+func LinkProduct(w http.ResponseWriter, r *http.Request) error {
+	id := parseIDAt(r.URL.Path, 1)
+	category, ok := findCategory(id)
+	if !ok {
+		return &statusError{CodeCategoryNotFound, "category not found"}
+	}
+	if category.Status == StatusArchived {
+		return &statusError{CodeCategoryArchived, "category is archived"}
+	}
+	product, ok := products[parseIDAt(r.URL.Path, 3)]
+	if !ok {
+		return &statusError{CodeProductNotFound, "product not found"}
+	}
+
+	if !containsID(product.CategoryIDs, id) {
+		product.CategoryIDs = append(product.CategoryIDs, id)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, product)
+	return nil
+}
+
+// UnlinkProduct godoc
+// @Summary Remove product from category
+// @Tags Category
+// @Param id path int true "Category ID"
+// @Param pid path int true "Product ID"
+// @Success 204
+// @Failure 404 {string} string
+// @Router /categories/{id}/products/{pid} [delete]
+func UnlinkProduct(w http.ResponseWriter, r *http.Request) error {
+	id := parseIDAt(r.URL.Path, 1)
+	product, ok := products[parseIDAt(r.URL.Path, 3)]
+	if !ok || !containsID(product.CategoryIDs, id) {
+		return &statusError{CodeProductNotLinked, "product is not in this category"}
+	}
+
+	product.CategoryIDs = removeID(product.CategoryIDs, id)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// =======================
+// RELATIONS
+// =======================
+
+// productsInCategory returns the products linked to a category ordered by
+// ID, which GET /categories/{id}/products pages through.
+func productsInCategory(categoryID int) []*Product {
+	result := []*Product{}
+	for _, p := range products {
+		if containsID(p.CategoryIDs, categoryID) {
+			result = append(result, p)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// unlinkCategory drops categoryID from every product that references it.
+func unlinkCategory(categoryID int) {
+	for _, p := range products {
+		p.CategoryIDs = removeID(p.CategoryIDs, categoryID)
+	}
+}
+
+func containsID(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func removeID(ids []int, id int) []int {
+	result := []int{}
+	for _, v := range ids {
+		if v != id {
+			result = append(result, v)
+		}
+	}
+	return result
+}
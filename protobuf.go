@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// =======================
+// PROTOBUF
+// =======================
+
+// Protobuf needs a schema, so it is only offered on the category endpoints.
+// The messages below must match api/category.proto; field numbers are
+// never reused.
+
+type protoKind int
+
+const (
+	protoInt64 protoKind = iota
+	protoString
+	protoRepeatedString
+	protoTimestamp // google.protobuf.Timestamp, RFC 3339 in JSON
+	protoRepeatedMessage
+)
+
+type protoField struct {
+	Num     int
+	JSON    string
+	Kind    protoKind
+	Message *protoMessage
+}
+
+type protoMessage struct {
+	Name   string
+	Fields []protoField
+}
+
+var categoryProto = &protoMessage{Name: "Category", Fields: []protoField{
+	{Num: 1, JSON: "id", Kind: protoInt64},
+	{Num: 2, JSON: "name", Kind: protoString},
+	{Num: 3, JSON: "description", Kind: protoString},
+	{Num: 4, JSON: "tags", Kind: protoRepeatedString},
+	{Num: 5, JSON: "position", Kind: protoInt64},
+	{Num: 6, JSON: "status", Kind: protoString},
+	{Num: 7, JSON: "version", Kind: protoInt64},
+	{Num: 8, JSON: "created_at", Kind: protoTimestamp},
+	{Num: 9, JSON: "updated_at", Kind: protoTimestamp},
+	{Num: 10, JSON: "deleted_at", Kind: protoTimestamp},
+	{Num: 11, JSON: "description_html", Kind: protoString},
+}}
+
+// categoryListProto wraps JSON arrays of categories.
+var categoryListProto = &protoMessage{Name: "CategoryList", Fields: []protoField{
+	{Num: 1, JSON: "categories", Kind: protoRepeatedMessage, Message: categoryProto},
+}}
+
+// protoRoutes are the routes whose bodies are categories or lists of them.
+var protoRoutes = map[string]bool{
+	"/categories":        true,
+	"/categories/{id}":   true,
+	"/categories/search": true,
+}
+
+func init() {
+	wireCodecs["application/x-protobuf"] = wireCodec{
+		Encode: func(route string, v interface{}) ([]byte, error) {
+			if !protoRoutes[route] {
+				return nil, errCodecRoute
+			}
+			if list, ok := v.([]interface{}); ok {
+				v = map[string]interface{}{"categories": list}
+				return encodeProto(categoryListProto, v)
+			}
+			return encodeProto(categoryProto, v)
+		},
+		Decode: func(route string, data []byte) (interface{}, error) {
+			if !protoRoutes[route] {
+				return nil, errCodecRoute
+			}
+			return decodeProto(categoryProto, data)
+		},
+		Omits:  []string{"attributes", "acl"},
+		Schema: true,
+	}
+}
+
+func encodeProto(msg *protoMessage, v interface{}) ([]byte, error) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("protobuf: %s must be an object", msg.Name)
+	}
+	var out []byte
+	for _, f := range msg.Fields {
+		val, ok := obj[f.JSON]
+		if !ok || val == nil {
+			continue
+		}
+		switch f.Kind {
+		case protoInt64:
+			n, err := protoNumber(val)
+			if err != nil {
+				return nil, fmt.Errorf("protobuf: %s: %v", f.JSON, err)
+			}
+			if n != 0 {
+				out = appendProtoVarint(appendProtoTag(out, f.Num, 0), uint64(n))
+			}
+		case protoString:
+			if s, _ := val.(string); s != "" {
+				out = appendProtoBytes(out, f.Num, []byte(s))
+			}
+		case protoRepeatedString:
+			list, _ := val.([]interface{})
+			for _, item := range list {
+				s, _ := item.(string)
+				out = appendProtoBytes(out, f.Num, []byte(s))
+			}
+		case protoTimestamp:
+			s, _ := val.(string)
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return nil, fmt.Errorf("protobuf: %s: %v", f.JSON, err)
+			}
+			var ts []byte
+			if sec := t.Unix(); sec != 0 {
+				ts = appendProtoVarint(appendProtoTag(ts, 1, 0), uint64(sec))
+			}
+			if nanos := t.Nanosecond(); nanos != 0 {
+				ts = appendProtoVarint(appendProtoTag(ts, 2, 0), uint64(nanos))
+			}
+			out = appendProtoBytes(out, f.Num, ts)
+		case protoRepeatedMessage:
+			list, _ := val.([]interface{})
+			for _, item := range list {
+				data, err := encodeProto(f.Message, item)
+				if err != nil {
+					return nil, err
+				}
+				out = appendProtoBytes(out, f.Num, data)
+			}
+		}
+	}
+	return out, nil
+}
+
+func protoNumber(v interface{}) (int64, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, errors.New("not a number")
+	}
+	return strconv.ParseInt(string(n), 10, 64)
+}
+
+func appendProtoTag(b []byte, num, wireType int) []byte {
+	return appendProtoVarint(b, uint64(num)<<3|uint64(wireType))
+}
+
+func appendProtoVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendProtoBytes(b []byte, num int, data []byte) []byte {
+	b = appendProtoTag(b, num, 2)
+	b = appendProtoVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// decodeProto reads msg into a JSON object, skipping unknown fields as
+// protobuf requires.
+func decodeProto(msg *protoMessage, data []byte) (map[string]interface{}, error) {
+	obj := map[string]interface{}{}
+	fields := map[int]protoField{}
+	for _, f := range msg.Fields {
+		fields[f.Num] = f
+	}
+
+	for pos := 0; pos < len(data); {
+		key, n := protoVarint(data[pos:])
+		if n == 0 {
+			return nil, errors.New("bad field key")
+		}
+		pos += n
+		num, wireType := int(key>>3), int(key&7)
+
+		var value uint64
+		var payload []byte
+		switch wireType {
+		case 0:
+			value, n = protoVarint(data[pos:])
+			if n == 0 {
+				return nil, errors.New("bad varint")
+			}
+			pos += n
+		case 1, 5:
+			size := 8
+			if wireType == 5 {
+				size = 4
+			}
+			if pos+size > len(data) {
+				return nil, errors.New("truncated fixed field")
+			}
+			pos += size
+		case 2:
+			length, n := protoVarint(data[pos:])
+			if n == 0 || length > uint64(len(data)-pos-n) {
+				return nil, errors.New("bad length")
+			}
+			pos += n
+			payload = data[pos : pos+int(length)]
+			pos += int(length)
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d", wireType)
+		}
+
+		f, ok := fields[num]
+		if !ok {
+			continue
+		}
+		wantVarint := f.Kind == protoInt64
+		if wantVarint != (wireType == 0) || (!wantVarint && wireType != 2) {
+			return nil, fmt.Errorf("field %s has wire type %d", f.JSON, wireType)
+		}
+		switch f.Kind {
+		case protoInt64:
+			obj[f.JSON] = json.Number(strconv.FormatInt(int64(value), 10))
+		case protoString:
+			obj[f.JSON] = string(payload)
+		case protoRepeatedString:
+			list, _ := obj[f.JSON].([]interface{})
+			obj[f.JSON] = append(list, string(payload))
+		case protoTimestamp:
+			ts, err := decodeProtoTimestamp(payload)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %v", f.JSON, err)
+			}
+			obj[f.JSON] = ts
+		case protoRepeatedMessage:
+			item, err := decodeProto(f.Message, payload)
+			if err != nil {
+				return nil, err
+			}
+			list, _ := obj[f.JSON].([]interface{})
+			obj[f.JSON] = append(list, item)
+		}
+	}
+	return obj, nil
+}
+
+func decodeProtoTimestamp(data []byte) (string, error) {
+	var sec, nanos int64
+	for pos := 0; pos < len(data); {
+		key, n := protoVarint(data[pos:])
+		if n == 0 || key&7 != 0 {
+			return "", errors.New("bad timestamp")
+		}
+		pos += n
+		v, n := protoVarint(data[pos:])
+		if n == 0 {
+			return "", errors.New("bad timestamp")
+		}
+		pos += n
+		switch key >> 3 {
+		case 1:
+			sec = int64(v)
+		case 2:
+			nanos = int64(v)
+		}
+	}
+	if nanos < 0 || nanos > math.MaxInt32 {
+		return "", errors.New("bad timestamp nanos")
+	}
+	return time.Unix(sec, nanos).UTC().Format(time.RFC3339Nano), nil
+}
+
+// protoVarint decodes a varint, returning 0 bytes read if it is malformed.
+func protoVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * i)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
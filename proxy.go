@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// =======================
+// TRUSTED PROXIES
+// =======================
+
+var (
+	// trustedProxies lists the networks whose forwarding headers are
+	// believed (TRUSTED_PROXIES, comma-separated CIDRs or addresses). Empty
+	// means the direct peer is always the client.
+	trustedProxies []netip.Prefix
+	// trustUnixPeers extends that trust to connections on UNIX_SOCKET,
+	// which have no address (TRUSTED_PROXIES entry "unix").
+	trustUnixPeers bool
+)
+
+// configureProxies reads TRUSTED_PROXIES.
+func configureProxies() {
+	for _, entry := range splitList(os.Getenv("TRUSTED_PROXIES")) {
+		if entry == "unix" {
+			trustUnixPeers = true
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(entry)
+			if aerr != nil {
+				log.Fatalf("invalid TRUSTED_PROXIES entry %q: want a CIDR, an IP address or \"unix\"", entry)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		trustedProxies = append(trustedProxies, prefix.Masked())
+	}
+}
+
+func trustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// fromTrustedProxy reports whether the direct peer is a trusted proxy, so
+// headers it sets on behalf of the client may be believed.
+func fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil {
+		return trustUnixPeers
+	}
+	return trustedProxy(peer)
+}
+
+// clientIP is the address of the client behind any trusted proxies. The
+// forwarding headers are only read when the direct peer is trusted, and
+// X-Forwarded-For is walked from the right, so a client can't spoof its
+// address by sending the header itself: the first untrusted hop wins.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	switch {
+	case err != nil && !trustUnixPeers:
+		return host
+	case err == nil && !trustedProxy(peer):
+		return peer.Unmap().String()
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		client := ""
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break // a malformed hop ends the chain we can trust
+			}
+			client = addr.Unmap().String()
+			if !trustedProxy(addr) {
+				return client
+			}
+		}
+		if client != "" {
+			return client
+		}
+	}
+	if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return addr.Unmap().String()
+	}
+	return host
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// =======================
+// PURGE
+// =======================
+
+// PurgeResult reports how many soft-deleted records were removed for good.
+type PurgeResult struct {
+	Categories int `json:"categories"`
+	Items      int `json:"items"`
+}
+
+// purgeRetention is how long soft-deleted records are kept (PURGE_RETENTION).
+var purgeRetention = 30 * 24 * time.Hour
+
+func init() {
+	registerMetric("purge_runs_total", "counter", "Purge runs by trigger.")
+	registerMetric("purged_categories_total", "counter", "Soft-deleted categories permanently removed.")
+	registerMetric("purged_items_total", "counter", "Soft-deleted items permanently removed.")
+	registerJobHandler("purge", purgeJob)
+	registerScheduledTask("purge", "@hourly", runScheduledPurge)
+}
+
+// purgeSoftDeleted permanently removes categories and items that were soft
+// deleted before cutoff. Callers must hold storeMu.
+func purgeSoftDeleted(cutoff time.Time, trigger string) PurgeResult {
+	var result PurgeResult
+	for id, c := range categories {
+		if c.DeletedAt != nil && c.DeletedAt.Before(cutoff) {
+			delete(categories, id)
+			delete(categoryHistory, id)
+			recordAudit("purge", id, nil)
+			result.Categories++
+		}
+	}
+	for id, it := range items {
+		_, parentExists := categories[it.CategoryID]
+		if (it.DeletedAt != nil && it.DeletedAt.Before(cutoff)) || !parentExists {
+			delete(items, id)
+			result.Items++
+		}
+	}
+
+	addMetric("purge_runs_total", 1, "trigger", trigger)
+	addMetric("purged_categories_total", float64(result.Categories))
+	addMetric("purged_items_total", float64(result.Items))
+	if result.Categories > 0 || result.Items > 0 {
+		notify(EventPurgeCompleted, map[string]interface{}{"categories": result.Categories, "items": result.Items})
+	}
+	return result
+}
+
+func purgeJob(job *Job) error {
+	return purgeWithTrigger("job")
+}
+
+func runScheduledPurge() error {
+	return purgeWithTrigger("scheduled")
+}
+
+func purgeWithTrigger(trigger string) error {
+	if isReadOnly() {
+		log.Printf("purge (%s) skipped: read-only mode", trigger)
+		return nil
+	}
+	storeMu.Lock()
+	result := purgeSoftDeleted(time.Now().UTC().Add(-purgeRetention), trigger)
+	storeMu.Unlock()
+	if result.Categories > 0 || result.Items > 0 {
+		log.Printf("purged %d categories and %d items", result.Categories, result.Items)
+	}
+	return nil
+}
+
+// PurgeNow godoc
+// @Summary Purge soft-deleted data
+// @Description Permanently removes categories and items soft deleted longer ago than the retention period.
+// @Tags Admin
+// @Produce json
+// @Param dry_run query bool false "Validate and return the would-be response without saving anything"
+// @Param Prefer header string false "respond-async answers 202 at once; poll the Location (GET /operations/{id})"
+// @Success 200 {object} PurgeResult
+// @Success 202 {object} Operation
+// @Router /admin/purge [post]
+func PurgeNow(w http.ResponseWriter, r *http.Request) error {
+	result := purgeSoftDeleted(time.Now().UTC().Add(-purgeRetention), "manual")
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, result)
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// =======================
+// RATE LIMITING
+// =======================
+
+var (
+	// rateLimit is the sustained requests per second allowed per client
+	// (RATE_LIMIT); 0 turns limiting off. Clients are told apart by their
+	// mTLS identity, else by clientIP.
+	rateLimit = 0.0
+	// rateBurst is how many requests a client may send at once after being
+	// idle (RATE_LIMIT_BURST).
+	rateBurst = 20
+	// rateQueueWait is how long a request over the limit may wait for a
+	// token instead of being rejected (RATE_LIMIT_QUEUE_WAIT); 0 rejects at
+	// once. Waiting happens before the request timeout starts.
+	rateQueueWait = 0 * time.Second
+	// rateQueueSize caps how many of one client's requests wait at a time
+	// (RATE_LIMIT_QUEUE_SIZE); requests beyond it are rejected.
+	rateQueueSize = 10
+
+	// rateExempt routes are never limited, so probes and scrapes keep
+	// working for a throttled client.
+	rateExempt = map[string]bool{"/livez": true, "/readyz": true, "/startupz": true, "/metrics": true}
+
+	rateMu        sync.Mutex
+	rateBuckets   = map[string]*tokenBucket{}
+	rateLastPrune time.Time
+)
+
+// tokenBucket holds one client's tokens as of last; a negative balance is
+// tokens already promised to queued requests.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	queued int
+}
+
+func init() {
+	registerMetric("rate_limited_total", "counter", "Requests over the rate limit by result (queued, rejected).")
+	registerRuntimeSetting("rate_limit", "Sustained requests per second per client; 0 turns rate limiting off.",
+		func() interface{} { return rateLimit },
+		func(raw json.RawMessage) (func(), error) {
+			var n float64
+			if err := json.Unmarshal(raw, &n); err != nil || n < 0 {
+				return nil, fmt.Errorf("rate_limit must be a non-negative number")
+			}
+			return func() { rateLimit = n }, nil
+		})
+	registerRuntimeInt("rate_limit_burst", "Requests a client may send at once after being idle.", 1,
+		func() int { return rateBurst },
+		func(n int) { rateBurst = n })
+	registerRuntimeDuration("rate_limit_queue_wait", "How long a request over the limit waits for a token; 0 rejects at once.",
+		func() time.Duration { return rateQueueWait },
+		func(d time.Duration) { rateQueueWait = d })
+}
+
+// configureRateLimit reads RATE_LIMIT, RATE_LIMIT_BURST,
+// RATE_LIMIT_QUEUE_WAIT and RATE_LIMIT_QUEUE_SIZE.
+func configureRateLimit() {
+	rateLimit = envFloat("RATE_LIMIT", rateLimit)
+	rateBurst = envInt("RATE_LIMIT_BURST", rateBurst)
+	rateQueueWait = envDuration("RATE_LIMIT_QUEUE_WAIT", rateQueueWait)
+	rateQueueSize = envInt("RATE_LIMIT_QUEUE_SIZE", rateQueueSize)
+}
+
+// reserveToken takes a token for client. It returns how long the caller
+// must wait for it, or ok false, with the wait it would have needed, when
+// the request should be rejected; nothing is taken then.
+func reserveToken(client string, now time.Time, limit float64, burst int, maxWait time.Duration) (wait time.Duration, ok bool) {
+	rateMu.Lock()
+	defer rateMu.Unlock()
+	if now.Sub(rateLastPrune) > time.Minute {
+		pruneBuckets(now, limit, burst)
+	}
+
+	b := rateBuckets[client]
+	if b == nil {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		rateBuckets[client] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*limit)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	wait = time.Duration((1 - b.tokens) / limit * float64(time.Second))
+	if wait > maxWait || b.queued >= rateQueueSize {
+		return wait, false
+	}
+	b.tokens--
+	b.queued++
+	return wait, true
+}
+
+// releaseToken ends a wait; a request that gave up returns its token.
+func releaseToken(client string, refund bool) {
+	rateMu.Lock()
+	defer rateMu.Unlock()
+	if b := rateBuckets[client]; b != nil {
+		b.queued--
+		if refund {
+			b.tokens++
+		}
+	}
+}
+
+// pruneBuckets drops clients whose bucket has refilled: they are
+// indistinguishable from new ones. Callers hold rateMu.
+func pruneBuckets(now time.Time, limit float64, burst int) {
+	for client, b := range rateBuckets {
+		if b.queued == 0 && b.tokens+now.Sub(b.last).Seconds()*limit >= float64(burst) {
+			delete(rateBuckets, client)
+		}
+	}
+	rateLastPrune = now
+}
+
+// limitRate applies the per-client token bucket. A request over the limit
+// waits up to rateQueueWait for its token, which smooths out the bursts of
+// batch clients, and is rejected with 429 and Retry-After otherwise.
+func limitRate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		runtimeMu.RLock()
+		limit, burst, maxWait := rateLimit, rateBurst, rateQueueWait
+		runtimeMu.RUnlock()
+		if limit <= 0 || rateExempt[routeLabel(r.URL.Path)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := requestIdentity(r)
+		if client == "" {
+			client = clientIP(r)
+		}
+		wait, ok := reserveToken(client, time.Now(), limit, burst, maxWait)
+		if !ok {
+			addMetric("rate_limited_total", 1, "result", "rejected")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeAPIError(w, CodeRateLimited, "rate limit exceeded, retry later")
+			return
+		}
+		if wait > 0 {
+			addMetric("rate_limited_total", 1, "result", "queued")
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+				releaseToken(client, false)
+			case <-r.Context().Done():
+				timer.Stop()
+				releaseToken(client, true)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReserveToken(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	savedSize := rateQueueSize
+	rateQueueSize = 1
+	t.Cleanup(func() {
+		rateQueueSize = savedSize
+		rateBuckets = map[string]*tokenBucket{}
+	})
+
+	// One token per second, a burst of two.
+	steps := []struct {
+		name    string
+		release bool // the queued request finishes first
+		at      time.Duration
+		maxWait time.Duration
+		wait    time.Duration
+		ok      bool
+	}{
+		{"burst 1", false, 0, 0, 0, true},
+		{"burst 2", false, 0, 0, 0, true},
+		{"over, no queueing", false, 0, 0, time.Second, false},
+		{"over, queued", false, 0, 2 * time.Second, time.Second, true},
+		{"queue full", false, 0, 5 * time.Second, 2 * time.Second, false},
+		{"refilled past the promised token", true, 2 * time.Second, 0, 0, true},
+		{"empty again", false, 2 * time.Second, 0, time.Second, false},
+		{"refill capped at burst 1", false, time.Hour, 0, 0, true},
+		{"refill capped at burst 2", false, time.Hour, 0, 0, true},
+		{"refill capped at burst 3", false, time.Hour, 0, time.Second, false},
+	}
+	rateBuckets = map[string]*tokenBucket{}
+	rateLastPrune = start
+	for _, s := range steps {
+		if s.release {
+			releaseToken("a", false)
+		}
+		wait, ok := reserveToken("a", start.Add(s.at), 1, 2, s.maxWait)
+		if ok != s.ok || wait != s.wait {
+			t.Errorf("%s: reserveToken = %v, %v; want %v, %v", s.name, wait, ok, s.wait, s.ok)
+		}
+	}
+
+	if _, ok := reserveToken("b", start, 1, 2, 0); !ok {
+		t.Error("another client shares the bucket of the first")
+	}
+}
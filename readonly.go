@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// =======================
+// READ-ONLY MODE
+// =======================
+
+var (
+	// readOnly rejects every request that would change data while reads go
+	// on (READ_ONLY, or read_only on /admin/runtime), e.g. on a DR replica
+	// or during a freeze before a migration. Guarded by runtimeMu.
+	readOnly = false
+
+	// readOnlyExempt are the writes still accepted in read-only mode, keyed
+	// like deprecatedRoutes: lookups sent as POST, exports, which only read
+	// the store, and the admin calls that don't touch data, including the
+	// one that turns read-only mode off again.
+	readOnlyExempt = map[string]bool{
+		"POST /categories/exists":  true,
+		"POST /exports":            true,
+		"PUT /admin/runtime":       true,
+		"DELETE /admin/recordings": true,
+	}
+)
+
+func init() {
+	registerMetric("read_only", "gauge", "1 while the service rejects writes.")
+	registerRuntimeSetting("read_only", "Reject writes with 503 while reads continue.",
+		func() interface{} { return readOnly },
+		func(raw json.RawMessage) (func(), error) {
+			var b bool
+			if err := json.Unmarshal(raw, &b); err != nil {
+				return nil, fmt.Errorf("read_only must be true or false")
+			}
+			return func() { setReadOnly(b) }, nil
+		})
+}
+
+// configureReadOnly reads READ_ONLY.
+func configureReadOnly() {
+	setReadOnly(envBool("READ_ONLY", readOnly))
+	if readOnly {
+		log.Println("read-only mode: writes are rejected")
+	}
+}
+
+// setReadOnly switches the mode; callers other than configureReadOnly hold
+// runtimeMu.
+func setReadOnly(on bool) {
+	readOnly = on
+	gauge := 0.0
+	if on {
+		gauge = 1
+	}
+	setMetric("read_only", gauge)
+}
+
+func isReadOnly() bool {
+	runtimeMu.RLock()
+	defer runtimeMu.RUnlock()
+	return readOnly
+}
+
+// rejectWrites answers 503 to writes while in read-only mode. It also
+// guards async operations on their replay, so those queued before the
+// switch don't slip through.
+func rejectWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if isReadOnly() && !readOnlyExempt[r.Method+" "+routeLabel(r.URL.Path)] {
+				writeAPIError(w, CodeReadOnly, "the service is in read-only mode; reads still work, retry the change later")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
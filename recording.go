@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// =======================
+// MODEL
+// =======================
+
+// Recording is one request and the response it got, as kept by the
+// recording mode. Bodies are text; a binary body is stored as "base64:"
+// followed by its encoding. Truncated is set when a body was longer than
+// RECORDING_MAX_BODY.
+type Recording struct {
+	ID             int         `json:"id"`
+	RecordedAt     time.Time   `json:"recorded_at"`
+	Method         string      `json:"method"`
+	Path           string      `json:"path"`
+	RequestHeader  http.Header `json:"request_header" swaggertype:"object"`
+	RequestBody    string      `json:"request_body,omitempty"`
+	Status         int         `json:"status"`
+	ResponseHeader http.Header `json:"response_header" swaggertype:"object"`
+	ResponseBody   string      `json:"response_body,omitempty"`
+	DurationMS     float64     `json:"duration_ms"`
+	Truncated      bool        `json:"truncated,omitempty"`
+}
+
+// =======================
+// STORAGE
+// =======================
+
+var (
+	recordingsMu    sync.Mutex
+	recordings      = []*Recording{}
+	recordingAutoID = 1
+	recordingFile   *os.File
+
+	// recordingSize is how many recordings the ring buffer keeps
+	// (RECORDING_SIZE); 0 keeps none. Recording is on when this or
+	// RECORDING_FILE is set.
+	recordingSize = 0
+	// recordingMaxBody caps the bytes kept of each body (RECORDING_MAX_BODY).
+	recordingMaxBody = 64 << 10
+
+	// redactedHeaders are never recorded or queued with an async request;
+	// RECORDING_REDACT_HEADERS adds more.
+	redactedHeaders = map[string]bool{
+		"Authorization":       true,
+		"Proxy-Authorization": true,
+		"Cookie":              true,
+		"Set-Cookie":          true,
+		"X-Api-Key":           true,
+		"X-Auth-Token":        true,
+	}
+
+	// unrecordedRoutes are probes, docs and assets, which would only push
+	// real traffic out of the buffer, signed download links and the export
+	// status that mints them, and the recordings themselves.
+	unrecordedRoutes = map[string]bool{
+		"/admin/recordings": true, "/admin/recordings/{id}": true, "/livez": true, "/readyz": true,
+		"/startupz": true, "/metrics": true, "/swagger/*": true, "/static/*": true, "/downloads/*": true,
+		"/exports/{id}": true,
+	}
+)
+
+// configureRecording reads RECORDING_SIZE, RECORDING_FILE (JSON lines,
+// appended), RECORDING_MAX_BODY and RECORDING_REDACT_HEADERS.
+func configureRecording() {
+	recordingSize = envInt("RECORDING_SIZE", recordingSize)
+	recordingMaxBody = envInt("RECORDING_MAX_BODY", recordingMaxBody)
+	for _, h := range splitList(os.Getenv("RECORDING_REDACT_HEADERS")) {
+		redactedHeaders[http.CanonicalHeaderKey(h)] = true
+	}
+	if path := os.Getenv("RECORDING_FILE"); path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			log.Fatalf("RECORDING_FILE: %v", err)
+		}
+		recordingFile = f
+	}
+	if recordingEnabled() {
+		log.Printf("recording requests: keeping %d in memory, file %q", recordingSize, os.Getenv("RECORDING_FILE"))
+	}
+}
+
+func recordingEnabled() bool {
+	return recordingSize > 0 || recordingFile != nil
+}
+
+func saveRecording(rec *Recording) {
+	recordingsMu.Lock()
+	defer recordingsMu.Unlock()
+	rec.ID = recordingAutoID
+	recordingAutoID++
+	if recordingSize > 0 {
+		recordings = append(recordings, rec)
+		if len(recordings) > recordingSize {
+			recordings = recordings[len(recordings)-recordingSize:]
+		}
+	}
+	if recordingFile != nil {
+		line, _ := json.Marshal(rec)
+		if _, err := recordingFile.Write(append(line, '\n')); err != nil {
+			log.Printf("recording: %v", err)
+		}
+	}
+}
+
+// =======================
+// MIDDLEWARE
+// =======================
+
+// recordRequests keeps a sanitized copy of each request and its response
+// while recording is on, so a client-reported bug can be looked at, and
+// replayed with cmd/replay, afterwards.
+func recordRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !recordingEnabled() || unrecordedRoutes[routeLabel(r.URL.Path)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &Recording{
+			RecordedAt:    time.Now().UTC(),
+			Method:        r.Method,
+			Path:          r.URL.RequestURI(),
+			RequestHeader: sanitizeHeader(r.Header),
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			// Only what is kept is buffered; the handler reads the rest of
+			// the body straight from the client.
+			head, err := io.ReadAll(io.LimitReader(r.Body, int64(recordingMaxBody)+1))
+			if err != nil {
+				writeAPIError(w, CodeInvalidJSON, "reading body: "+err.Error())
+				return
+			}
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+			rec.RequestBody, rec.Truncated = recordedBody(head)
+		}
+
+		cw := &capturingWriter{ResponseWriter: w}
+		next.ServeHTTP(cw, r)
+
+		rec.Status = cw.status
+		if rec.Status == 0 {
+			rec.Status = http.StatusOK
+		}
+		rec.ResponseHeader = sanitizeHeader(w.Header())
+		body, truncated := recordedBody(cw.body.Bytes())
+		rec.ResponseBody = body
+		rec.Truncated = rec.Truncated || truncated || cw.overflow
+		rec.DurationMS = float64(time.Since(rec.RecordedAt).Microseconds()) / 1000
+		saveRecording(rec)
+	})
+}
+
+// readCloser reads from one reader and closes another.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// sanitizeHeader copies h without redactedHeaders.
+func sanitizeHeader(h http.Header) http.Header {
+	out := http.Header{}
+	for k, v := range h {
+		if !redactedHeaders[http.CanonicalHeaderKey(k)] {
+			out[k] = append([]string(nil), v...)
+		}
+	}
+	return out
+}
+
+// recordedBody turns up to recordingMaxBody bytes of b into text.
+func recordedBody(b []byte) (string, bool) {
+	truncated := len(b) > recordingMaxBody
+	if truncated {
+		b = b[:recordingMaxBody]
+	}
+	if utf8.Valid(b) {
+		return string(b), truncated
+	}
+	return "base64:" + base64.StdEncoding.EncodeToString(b), truncated
+}
+
+// capturingWriter passes the response through and keeps the first
+// recordingMaxBody bytes of it.
+type capturingWriter struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (cw *capturingWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *capturingWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if room := recordingMaxBody + 1 - cw.body.Len(); room > 0 {
+		cw.body.Write(b[:min(len(b), room)])
+	} else {
+		cw.overflow = true
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *capturingWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// =======================
+// HANDLER
+// =======================
+
+// GetRecordings godoc
+// @Summary List recorded requests
+// @Description The newest requests kept by the recording mode (RECORDING_SIZE), newest first. Authorization, cookies
+// @Description and RECORDING_REDACT_HEADERS are never recorded. Feed the result to cmd/replay to send them again.
+// @Description Paged: X-Total-Count has the number of matches and Link the next and prev pages.
+// @Tags Admin
+// @Produce json
+// @Param path query string false "Only requests whose path starts with this"
+// @Param limit query int false "Page size (default PAGE_SIZE_DEFAULT, 20; at most PAGE_SIZE_MAX, 200)"
+// @Param offset query int false "Recordings to skip"
+// @Success 200 {array} Recording
+// @Failure 400 {string} string
+// @Router /admin/recordings [get]
+func GetRecordings(w http.ResponseWriter, r *http.Request) error {
+	page, err := parsePage(r.URL.Query(), defaultPageSize, maxPageSize)
+	if err != nil {
+		return err
+	}
+	prefix := r.URL.Query().Get("path")
+
+	recordingsMu.Lock()
+	result := []*Recording{}
+	for i := len(recordings) - 1; i >= 0; i-- {
+		if strings.HasPrefix(recordings[i].Path, prefix) {
+			result = append(result, recordings[i])
+		}
+	}
+	recordingsMu.Unlock()
+	setPageHeaders(w, r, page, len(result))
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, paginate(result, page))
+	return nil
+}
+
+// GetRecording godoc
+// @Summary Get a recorded request
+// @Tags Admin
+// @Produce json
+// @Param id path int true "Recording ID"
+// @Success 200 {object} Recording
+// @Failure 404 {string} string
+// @Router /admin/recordings/{id} [get]
+func GetRecording(w http.ResponseWriter, r *http.Request) error {
+	id := parseIDAt(r.URL.Path, 2)
+	recordingsMu.Lock()
+	var found *Recording
+	for _, rec := range recordings {
+		if rec.ID == id {
+			found = rec
+		}
+	}
+	recordingsMu.Unlock()
+	if found == nil {
+		return &statusError{CodeRecordingNotFound, "recording not found"}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, found)
+	return nil
+}
+
+// DeleteRecordings godoc
+// @Summary Clear recorded requests
+// @Description Empties the in-memory buffer; RECORDING_FILE is left alone.
+// @Tags Admin
+// @Success 204
+// @Router /admin/recordings [delete]
+func DeleteRecordings(w http.ResponseWriter, r *http.Request) error {
+	recordingsMu.Lock()
+	recordings = []*Recording{}
+	recordingsMu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// =======================
+// GRACEFUL RESTART
+// =======================
+
+// On SIGHUP the server starts a fresh copy of its executable (re-resolved,
+// so a binary replaced on disk is picked up, and re-reading .env) and hands
+// it the listening socket. Once the new process serves, it sends SIGTERM to
+// the old one, which drains in-flight requests as on any shutdown. The
+// socket is never closed, so no connection is refused in between.
+//
+// The store is in memory: the new process starts with an empty one, exactly
+// as after a plain restart. The job queue is handed over through JOBS_FILE:
+// before the new process starts, the old one lets its running jobs finish,
+// saves the queue and stops queueing, answering 503 for requests that would
+// queue a job until it exits.
+
+// inheritedFDEnv tells a replacement process which descriptor holds the
+// listener. It is internal to the handover.
+const inheritedFDEnv = "SIMPLE_CRUD_INHERITED_FD"
+
+// launchEnv is the environment the process was started with, before .env
+// was loaded into it. A replacement gets this one, since godotenv doesn't
+// override variables that are already set and would otherwise keep the old
+// .env values.
+var launchEnv []string
+
+// inherited is set in a process that took its listener over from a parent.
+var inherited bool
+
+// inheritedListener returns the listener handed over by the previous
+// process, or nil if this process was started normally.
+func inheritedListener() (net.Listener, error) {
+	if os.Getenv(inheritedFDEnv) == "" {
+		return nil, nil
+	}
+	os.Unsetenv(inheritedFDEnv)
+	f := os.NewFile(3, "inherited-listener")
+	ln, err := net.FileListener(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("inherited listener: %w", err)
+	}
+	inherited = true
+	log.Printf("took over listener %s from process %d", ln.Addr(), os.Getppid())
+	return ln, nil
+}
+
+// startReplacement execs the current binary with ln as descriptor 3.
+func startReplacement(ln net.Listener) error {
+	fl, ok := ln.(interface{ File() (*os.File, error) })
+	if !ok {
+		return errors.New("listener can't be handed over")
+	}
+	// The socket file must outlive this process's listener.
+	if ul, ok := ln.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+	f, err := fl.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	env := []string{}
+	for _, kv := range launchEnv {
+		if !strings.HasPrefix(kv, inheritedFDEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	cmd.Env = append(env, inheritedFDEnv+"=3")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{f}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	log.Printf("started replacement process %d", cmd.Process.Pid)
+	return nil
+}
+
+// retireParent asks the process that handed us the listener to drain and
+// exit, now that this one is serving.
+func retireParent() {
+	if !inherited {
+		return
+	}
+	parent, err := os.FindProcess(os.Getppid())
+	if err == nil {
+		err = parent.Signal(syscall.SIGTERM)
+	}
+	if err != nil {
+		log.Printf("graceful restart: signalling previous process: %v", err)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =======================
+// REPORTS
+// =======================
+
+var (
+	// reportDir is where scheduled reports are written (REPORT_DIR). The
+	// "report" task is off unless SCHEDULE_REPORT is set.
+	reportDir = "reports"
+	// reportRecentChanges is how many changelog entries the report lists.
+	reportRecentChanges = 20
+)
+
+func init() {
+	registerScheduledTask("report", "", runScheduledReport)
+}
+
+// buildCategoryReport renders the summary report in the given locale,
+// limited to the categories p may read. Callers must hold storeMu.
+func buildCategoryReport(now time.Time, l *Locale, p *Principal) []byte {
+	live := readableCategories(p, sortedCategories())
+	archived, deleted := 0, 0
+	for _, c := range categories {
+		switch {
+		case !canReadCategory(p, c):
+		case c.DeletedAt != nil:
+			deleted++
+		case c.Status == StatusArchived:
+			archived++
+		}
+	}
+
+	doc := newPDFDoc()
+	doc.text(pdfBold, 18, "Category report")
+	doc.text(pdfRegular, 10, "Generated "+l.formatTime(now, false)+" "+now.In(l.Location).Format("MST"))
+	doc.gap()
+
+	doc.text(pdfBold, 13, "Summary")
+	doc.row(pdfRegular, []pdfCell{{0, "Categories"}, {160, l.formatCount(len(live))}})
+	doc.row(pdfRegular, []pdfCell{{0, "Active"}, {160, l.formatCount(len(live) - archived)}})
+	doc.row(pdfRegular, []pdfCell{{0, "Archived"}, {160, l.formatCount(archived)}})
+	doc.row(pdfRegular, []pdfCell{{0, "Soft-deleted"}, {160, l.formatCount(deleted)}})
+	doc.row(pdfRegular, []pdfCell{{0, "Products"}, {160, l.formatCount(len(products))}})
+	doc.gap()
+
+	doc.text(pdfBold, 13, "Recent changes")
+	recent := []ChangeEvent{}
+	for _, e := range changelog {
+		if e.Resource == "category" && !canReadChange(p, e) {
+			continue
+		}
+		recent = append(recent, e)
+	}
+	if len(recent) > reportRecentChanges {
+		recent = recent[len(recent)-reportRecentChanges:]
+	}
+	if len(recent) == 0 {
+		doc.text(pdfRegular, 10, "No changes recorded.")
+	}
+	for i := len(recent) - 1; i >= 0; i-- {
+		e := recent[i]
+		doc.row(pdfRegular, []pdfCell{
+			{0, l.formatTime(e.CreatedAt, true)},
+			{120, e.Resource + " " + strconv.Itoa(e.ResourceID)},
+			{220, e.Op},
+		})
+	}
+	doc.gap()
+
+	doc.text(pdfBold, 13, "Categories")
+	header := []pdfCell{{0, "ID"}, {40, "Name"}, {220, "Status"}, {280, "Tags"}, {410, "Updated"}}
+	doc.row(pdfBold, header)
+	for _, c := range live {
+		if doc.pageFull() {
+			doc.newPage()
+			doc.row(pdfBold, header)
+		}
+		doc.row(pdfRegular, []pdfCell{
+			{0, strconv.Itoa(c.ID)},
+			{40, truncateText(c.Name, 34)},
+			{220, c.Status},
+			{280, truncateText(strings.Join(c.Tags, ", "), 24)},
+			{410, l.formatTime(c.UpdatedAt, false)},
+		})
+	}
+	return doc.bytes()
+}
+
+func truncateText(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-3]) + "..."
+}
+
+func runScheduledReport() error {
+	now := time.Now().UTC()
+	storeMu.RLock()
+	data := buildCategoryReport(now, &defaultLocale, nil)
+	storeMu.RUnlock()
+
+	if err := os.MkdirAll(reportDir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(reportDir, "categories-"+now.Format("20060102-1504")+".pdf")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return err
+	}
+	log.Printf("report: wrote %s", path)
+	return nil
+}
+
+// =======================
+// PDF
+// =======================
+
+// pdfDoc lays out lines of text top to bottom on A4 pages using the
+// built-in Helvetica fonts, which every viewer has, so no fonts need to be
+// embedded. Text outside Latin-1 is printed as "?".
+type pdfDoc struct {
+	pages []*bytes.Buffer
+	y     float64
+}
+
+const (
+	pdfRegular = "F1"
+	pdfBold    = "F2"
+
+	pdfPageWidth  = 595
+	pdfPageHeight = 842
+	pdfMargin     = 50
+	pdfLineHeight = 14
+)
+
+type pdfCell struct {
+	X    float64
+	Text string
+}
+
+func newPDFDoc() *pdfDoc {
+	d := &pdfDoc{}
+	d.newPage()
+	return d
+}
+
+func (d *pdfDoc) newPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pdfPageHeight - pdfMargin
+}
+
+func (d *pdfDoc) pageFull() bool {
+	return d.y < pdfMargin+pdfLineHeight
+}
+
+func (d *pdfDoc) gap() {
+	d.y -= pdfLineHeight / 2
+}
+
+func (d *pdfDoc) text(font string, size float64, s string) {
+	d.line(font, size, []pdfCell{{0, s}})
+}
+
+func (d *pdfDoc) row(font string, cells []pdfCell) {
+	d.line(font, 10, cells)
+}
+
+func (d *pdfDoc) line(font string, size float64, cells []pdfCell) {
+	height := size + 4
+	if height < pdfLineHeight {
+		height = pdfLineHeight
+	}
+	if d.y-height < pdfMargin {
+		d.newPage()
+	}
+	d.y -= height
+	page := d.pages[len(d.pages)-1]
+	for _, c := range cells {
+		fmt.Fprintf(page, "BT /%s %g Tf %g %g Td (%s) Tj ET\n", font, size, pdfMargin+c.X, d.y, pdfEscape(c.Text))
+	}
+}
+
+// pdfEscape encodes s as a PDF literal string in WinAnsiEncoding.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// bytes assembles the file: catalog, page tree, the two fonts, then one
+// page and content stream per page, followed by the cross-reference table.
+func (d *pdfDoc) bytes() []byte {
+	var out bytes.Buffer
+	offsets := []int{}
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+	kids := []string{}
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 5+2*i))
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// =======================
+// HANDLER
+// =======================
+
+// GetCategoryReport godoc
+// @Summary Category report (PDF)
+// @Description A printable summary: counts, the most recent changes and the full category listing, limited to the
+// @Description categories the caller's ACLs let them read.
+// @Description The same report can be written to REPORT_DIR on a schedule (SCHEDULE_REPORT).
+// @Tags Reports
+// @Produce application/pdf
+// @Param Accept-Language header string false "Writes dates and counts in this language's format (en, en-GB, de, fr, id)"
+// @Param X-Timezone header string false "IANA time zone for dates, e.g. Asia/Jakarta (default UTC)"
+// @Success 200 {file} file
+// @Failure 400 {string} string
+// @Router /reports/categories.pdf [get]
+func GetCategoryReport(w http.ResponseWriter, r *http.Request) error {
+	l := requestLocale(r)
+	data := buildCategoryReport(time.Now().UTC(), l, requestPrincipal(r))
+	setLocaleHeaders(w, l)
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+	return nil
+}
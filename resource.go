@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// =======================
+// GENERIC RESOURCE
+// =======================
+
+// Resource provides the standard list/create/get/update/delete handlers for
+// a model kept in an in-memory map, so a new resource only has to describe
+// its fields. Swagger annotations can't live on generic methods, so each
+// resource still declares thin exported handlers that carry the godoc and
+// call these.
+type Resource[T any] struct {
+	Name     string    // singular, for messages, e.g. "product"
+	NotFound ErrorCode // returned when the id doesn't exist
+
+	Store map[int]*T
+	// NextID is the sequence IDs are drawn from with nextID.
+	NextID *int
+	ID     func(*T) int
+	SetID  func(*T, int)
+
+	// Prepare resets server-managed fields on a new record before it is stored.
+	Prepare func(*T)
+	// Apply copies the updatable fields from the request body onto the stored record.
+	Apply func(dst, src *T)
+	// Validate, if set, runs on the request body of creates and updates; return
+	// a statusError to choose the error code.
+	Validate func(*T) error
+	// Hooks, if set, run around creates, updates and deletes.
+	Hooks *Hooks[T]
+}
+
+func (res *Resource[T]) decode(r *http.Request) (*T, error) {
+	input := new(T)
+	if err := json.NewDecoder(r.Body).Decode(input); err != nil {
+		return nil, &statusError{CodeInvalidJSON, err.Error()}
+	}
+	if res.Validate != nil {
+		if err := res.Validate(input); err != nil {
+			return nil, err
+		}
+	}
+	return input, nil
+}
+
+func (res *Resource[T]) find(r *http.Request) (*T, error) {
+	record, ok := res.Store[parseID(r.URL.Path)]
+	if !ok {
+		return nil, &statusError{res.NotFound, res.Name + " not found"}
+	}
+	return record, nil
+}
+
+// List answers with a page of the records, ordered by id.
+func (res *Resource[T]) List(w http.ResponseWriter, r *http.Request) error {
+	page, err := parsePage(r.URL.Query(), defaultPageSize, maxPageSize)
+	if err != nil {
+		return err
+	}
+	result := []*T{}
+	for _, v := range res.Store {
+		result = append(result, v)
+	}
+	sort.Slice(result, func(i, j int) bool { return res.ID(result[i]) < res.ID(result[j]) })
+	setPageHeaders(w, r, page, len(result))
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, paginate(result, page))
+	return nil
+}
+
+func (res *Resource[T]) Create(w http.ResponseWriter, r *http.Request) error {
+	input, err := res.decode(r)
+	if err != nil {
+		return err
+	}
+
+	res.SetID(input, 0)
+	if res.Prepare != nil {
+		res.Prepare(input)
+	}
+	if err := res.Hooks.runBefore(hookCreate, input); err != nil {
+		return err
+	}
+	res.SetID(input, nextID(res.NextID))
+	res.Store[res.ID(input)] = input
+	res.Hooks.runAfter(hookCreate, input)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	encodeJSON(w, input)
+	return nil
+}
+
+func (res *Resource[T]) Get(w http.ResponseWriter, r *http.Request) error {
+	record, err := res.find(r)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, record)
+	return nil
+}
+
+func (res *Resource[T]) Update(w http.ResponseWriter, r *http.Request) error {
+	record, err := res.find(r)
+	if err != nil {
+		return err
+	}
+	input, err := res.decode(r)
+	if err != nil {
+		return err
+	}
+
+	res.SetID(input, res.ID(record))
+	if err := res.Hooks.runBefore(hookUpdate, input); err != nil {
+		return err
+	}
+	res.Apply(record, input)
+	res.Hooks.runAfter(hookUpdate, record)
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, record)
+	return nil
+}
+
+func (res *Resource[T]) Delete(w http.ResponseWriter, r *http.Request) error {
+	record, err := res.find(r)
+	if err != nil {
+		return err
+	}
+
+	if err := res.Hooks.runBefore(hookDelete, record); err != nil {
+		return err
+	}
+	delete(res.Store, res.ID(record))
+	res.Hooks.runAfter(hookDelete, record)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// registerResourceRoutes registers /<prefix> (GET, POST) and /<prefix>/{id}
+// (GET, PUT, DELETE) on g, which should hold the store lock, using the given
+// handlers so their swagger annotations stay attached.
+func registerResourceRoutes(g routeGroup, prefix string, list, create, get, update, remove apiHandler) {
+	g.Route(prefix, func(w http.ResponseWriter, r *http.Request) error {
+		switch r.Method {
+		case http.MethodGet:
+			return list(w, r)
+		case http.MethodPost:
+			return create(w, r)
+		default:
+			return errRouteNotFound
+		}
+	})
+
+	g.Route(prefix+"/", func(w http.ResponseWriter, r *http.Request) error {
+		if len(pathParts(r.URL.Path)) != 2 {
+			return errRouteNotFound
+		}
+		switch r.Method {
+		case http.MethodGet:
+			return get(w, r)
+		case http.MethodPut:
+			return update(w, r)
+		case http.MethodDelete:
+			return remove(w, r)
+		default:
+			return errRouteNotFound
+		}
+	})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// =======================
+// MODEL
+// =======================
+
+// RuntimeSetting is one knob of GET /admin/runtime. Durations are strings
+// such as "30s"; counts and byte sizes are numbers.
+type RuntimeSetting struct {
+	Name        string      `json:"name"`
+	Value       interface{} `json:"value" swaggertype:"string"`
+	Description string      `json:"description"`
+}
+
+// =======================
+// SETTINGS
+// =======================
+
+// runtimeSetting is a knob operators may change without a restart. parse
+// validates a new value and returns the change to apply, so a PUT with one
+// bad value changes nothing.
+type runtimeSetting struct {
+	name  string
+	help  string
+	get   func() interface{}
+	parse func(raw json.RawMessage) (apply func(), err error)
+}
+
+var (
+	runtimeSettings = map[string]*runtimeSetting{}
+
+	// runtimeMu guards settings read outside storeMu, such as the slow
+	// request threshold; PUT /admin/runtime holds it while applying.
+	runtimeMu sync.RWMutex
+
+	// gcPercent is the last GOGC value set, from the environment at start.
+	gcPercent = 100
+)
+
+func init() {
+	if v := os.Getenv("GOGC"); v == "off" {
+		gcPercent = -1
+	} else if n, err := strconv.Atoi(v); err == nil {
+		gcPercent = n
+	}
+	registerRuntimeInt("gc_percent", "GOGC: heap growth that triggers a collection, in percent; -1 disables the collector.", -1,
+		func() int { return gcPercent },
+		func(n int) { gcPercent = n; debug.SetGCPercent(n) })
+	registerRuntimeSetting("memory_limit", "GOMEMLIMIT in bytes: the soft limit the collector works to stay under; 0 removes it.",
+		func() interface{} {
+			if limit := debug.SetMemoryLimit(-1); limit != math.MaxInt64 {
+				return limit
+			}
+			return 0
+		},
+		func(raw json.RawMessage) (func(), error) {
+			var n int64
+			if err := json.Unmarshal(raw, &n); err != nil || n < 0 {
+				return nil, fmt.Errorf("memory_limit must be a number of bytes, 0 for none")
+			}
+			if n == 0 {
+				n = math.MaxInt64
+			}
+			return func() { debug.SetMemoryLimit(n) }, nil
+		})
+}
+
+// registerRuntimeSetting adds a knob to /admin/runtime. Call it from init.
+func registerRuntimeSetting(name, help string, get func() interface{}, parse func(raw json.RawMessage) (func(), error)) {
+	runtimeSettings[name] = &runtimeSetting{name: name, help: help, get: get, parse: parse}
+}
+
+// registerRuntimeInt adds an integer knob with a lower bound.
+func registerRuntimeInt(name, help string, min int, get func() int, set func(int)) {
+	registerRuntimeSetting(name, help, func() interface{} { return get() }, func(raw json.RawMessage) (func(), error) {
+		var n int
+		if err := json.Unmarshal(raw, &n); err != nil || n < min {
+			return nil, fmt.Errorf("%s must be an integer of at least %d", name, min)
+		}
+		return func() { set(n) }, nil
+	})
+}
+
+// registerRuntimeDuration adds a duration knob written like "30s"; zero is
+// allowed and usually means off.
+func registerRuntimeDuration(name, help string, get func() time.Duration, set func(time.Duration)) {
+	registerRuntimeSetting(name, help, func() interface{} { return get().String() }, func(raw json.RawMessage) (func(), error) {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, fmt.Errorf("%s must be a duration string such as \"30s\"", name)
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("%s must be a duration string such as \"30s\"", name)
+		}
+		return func() { set(d) }, nil
+	})
+}
+
+func listRuntimeSettings() []RuntimeSetting {
+	result := make([]RuntimeSetting, 0, len(runtimeSettings))
+	for _, s := range runtimeSettings {
+		result = append(result, RuntimeSetting{Name: s.name, Value: s.get(), Description: s.help})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// =======================
+// HANDLER
+// =======================
+
+// GetRuntimeSettings godoc
+// @Summary List runtime settings
+// @Description The knobs PUT /admin/runtime can change, with their current values.
+// @Tags Admin
+// @Produce json
+// @Success 200 {array} RuntimeSetting
+// @Router /admin/runtime [get]
+func GetRuntimeSettings(w http.ResponseWriter, r *http.Request) error {
+	runtimeMu.RLock()
+	result := listRuntimeSettings()
+	runtimeMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, result)
+	return nil
+}
+
+// PutRuntimeSettings godoc
+// @Summary Change runtime settings
+// @Description Applies the given settings at once, without a restart, and records the change in the audit log.
+// @Description All values are checked first: an unknown name or a bad value changes nothing. Changes last until
+// @Description the process restarts; make them permanent in the environment.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param body body object true "Settings by name, e.g. {\"gc_percent\": 50, \"cache_max_age\": \"30s\"}"
+// @Success 200 {array} RuntimeSetting
+// @Failure 400 {string} string
+// @Router /admin/runtime [put]
+func PutRuntimeSettings(w http.ResponseWriter, r *http.Request) error {
+	var input map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return &statusError{CodeInvalidJSON, err.Error()}
+	}
+	names := make([]string, 0, len(input))
+	for name := range input {
+		if runtimeSettings[name] == nil {
+			return &statusError{CodeValidationFailed, fmt.Sprintf("unknown runtime setting %q", name)}
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	applies := make([]func(), len(names))
+	for i, name := range names {
+		apply, err := runtimeSettings[name].parse(input[name])
+		if err != nil {
+			return &statusError{CodeValidationFailed, err.Error()}
+		}
+		applies[i] = apply
+	}
+
+	runtimeMu.Lock()
+	changes := map[string]interface{}{}
+	for i, name := range names {
+		s := runtimeSettings[name]
+		from := s.get()
+		applies[i]()
+		to := s.get()
+		if from != to {
+			changes[name] = map[string]interface{}{"from": from, "to": to}
+			log.Printf("runtime: %s changed from %v to %v", name, from, to)
+		}
+	}
+	result := listRuntimeSettings()
+	runtimeMu.Unlock()
+
+	if len(changes) > 0 {
+		recordAudit("runtime_update", 0, map[string]interface{}{"changes": changes})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, result)
+	return nil
+}
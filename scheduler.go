@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// =======================
+// CRON
+// =======================
+
+// cronSchedule is a parsed five-field cron expression
+// (minute hour day-of-month month day-of-week).
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	domAny, dowAny                bool
+}
+
+var cronAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@nightly": "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// parseCron understands *, lists (1,15), ranges (1-5), steps (*/10, 0-30/5)
+// and the @hourly/@daily/@nightly/@weekly/@monthly aliases.
+func parseCron(expr string) (*cronSchedule, error) {
+	if alias, ok := cronAliases[expr]; ok {
+		expr = alias
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron %q: want 5 fields, got %d", expr, len(fields))
+	}
+
+	s := &cronSchedule{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+	sets := [5]*map[int]bool{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, f := range fields {
+		set, err := parseCronField(f, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("cron %q: %v", expr, err)
+		}
+		*sets[i] = set
+	}
+	return s, nil
+}
+
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	set := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("bad step in %q", part)
+			}
+			step = n
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("bad value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("bad value %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// next returns the first minute strictly after t that matches the schedule.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !s.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return limit
+}
+
+// dayMatches follows cron: when both day fields are restricted, either may match.
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom, dow := s.dom[t.Day()], s.dow[int(t.Weekday())]
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// =======================
+// SCHEDULER
+// =======================
+
+// scheduledTask is a recurring task. Its schedule comes from
+// SCHEDULE_<NAME> (e.g. SCHEDULE_PURGE="0 3 * * *"), falling back to
+// defaultCron; "off" disables it.
+type scheduledTask struct {
+	name        string
+	defaultCron string
+	run         func() error
+	running     atomic.Bool
+}
+
+var (
+	scheduledTasks = []*scheduledTask{}
+
+	// schedulerStopped ends the timer loops; scheduledRuns counts the runs
+	// in progress. Both are guarded by schedulerMu.
+	schedulerMu      sync.Mutex
+	schedulerStopped bool
+	scheduledRuns    sync.WaitGroup
+)
+
+func init() {
+	registerMetric("scheduled_task_runs_total", "counter", "Scheduled task runs by task and result (ok, error, skipped, not_leader).")
+	registerMetric("scheduled_task_last_run_timestamp_seconds", "gauge", "Unix time the task last started.")
+	registerMetric("scheduled_task_last_duration_seconds", "gauge", "How long the last run of the task took.")
+	registerMetric("scheduled_task_last_success", "gauge", "1 if the last run of the task succeeded, 0 otherwise.")
+	registerShutdownHook("scheduler", []string{"jobs"}, stopScheduler)
+}
+
+// registerScheduledTask adds a task. Call it before startScheduler.
+func registerScheduledTask(name, defaultCron string, run func() error) {
+	scheduledTasks = append(scheduledTasks, &scheduledTask{name: name, defaultCron: defaultCron, run: run})
+}
+
+// startScheduler starts one timer loop per enabled task, exiting on a bad
+// expression so a typo doesn't silently disable a job.
+func startScheduler() {
+	for _, task := range scheduledTasks {
+		expr := os.Getenv("SCHEDULE_" + strings.ToUpper(task.name))
+		if expr == "" {
+			expr = task.defaultCron
+		}
+		if expr == "" || expr == "off" {
+			continue
+		}
+		schedule, err := parseCron(expr)
+		if err != nil {
+			log.Fatalf("invalid SCHEDULE_%s: %v", strings.ToUpper(task.name), err)
+		}
+		log.Printf("scheduler: %s runs at %q", task.name, expr)
+		go task.loop(schedule)
+	}
+}
+
+func (task *scheduledTask) loop(schedule *cronSchedule) {
+	for {
+		slot := schedule.next(time.Now())
+		time.Sleep(time.Until(slot))
+
+		schedulerMu.Lock()
+		if schedulerStopped {
+			schedulerMu.Unlock()
+			return
+		}
+		scheduledRuns.Add(1)
+		schedulerMu.Unlock()
+		go func() {
+			defer scheduledRuns.Done()
+			task.fire(slot)
+		}()
+	}
+}
+
+// stopScheduler starts no more runs and waits for those in progress.
+func stopScheduler(ctx context.Context) error {
+	schedulerMu.Lock()
+	schedulerStopped = true
+	schedulerMu.Unlock()
+	if err := waitContext(ctx, &scheduledRuns); err != nil {
+		return fmt.Errorf("tasks still running: %w", err)
+	}
+	return nil
+}
+
+// fire runs the task for one schedule slot if this instance is leader, the
+// previous run is done, and no other replica sharing the lock backend
+// already took the slot.
+func (task *scheduledTask) fire(slot time.Time) {
+	if !isLeader() {
+		addMetric("scheduled_task_runs_total", 1, "task", task.name, "result", "not_leader")
+		return
+	}
+	if !task.running.CompareAndSwap(false, true) {
+		addMetric("scheduled_task_runs_total", 1, "task", task.name, "result", "skipped")
+		log.Printf("scheduler: %s still running, skipping", task.name)
+		return
+	}
+	defer task.running.Store(false)
+
+	// The lock is per slot and left to expire rather than released, so a
+	// replica whose clock runs a little behind can't run the slot again.
+	_, ok, err := locker.TryLock(fmt.Sprintf("task:%s:%d", task.name, slot.Unix()), lockTTL)
+	if err != nil {
+		log.Printf("scheduler: %s: lock: %v", task.name, err)
+		addMetric("scheduled_task_runs_total", 1, "task", task.name, "result", "error")
+		return
+	}
+	if !ok {
+		addMetric("scheduled_task_runs_total", 1, "task", task.name, "result", "skipped")
+		return
+	}
+
+	start := time.Now()
+	setMetric("scheduled_task_last_run_timestamp_seconds", float64(start.Unix()), "task", task.name)
+	err = task.run()
+	setMetric("scheduled_task_last_duration_seconds", time.Since(start).Seconds(), "task", task.name)
+
+	if err != nil {
+		log.Printf("scheduler: %s: %v", task.name, err)
+		addMetric("scheduled_task_runs_total", 1, "task", task.name, "result", "error")
+		setMetric("scheduled_task_last_success", 0, "task", task.name)
+		return
+	}
+	addMetric("scheduled_task_runs_total", 1, "task", task.name, "result", "ok")
+	setMetric("scheduled_task_last_success", 1, "task", task.name)
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =======================
+// SEARCH (Elasticsearch / OpenSearch)
+// =======================
+
+var (
+	// searchURL is the base URL of an Elasticsearch or OpenSearch cluster
+	// (SEARCH_URL, e.g. http://localhost:9200). Empty keeps search in memory.
+	searchURL string
+	// searchIndex is the index categories are written to (SEARCH_INDEX).
+	searchIndex = "categories"
+
+	searchClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// SearchIndexTask is the payload of a "search_index" job: one category to
+// write to, or remove from, the index.
+type SearchIndexTask struct {
+	Op       string    `json:"op"`
+	ID       int       `json:"id"`
+	Seq      int64     `json:"seq"`
+	Category *Category `json:"category,omitempty"`
+}
+
+func init() {
+	registerJobHandler("search_index", searchIndexJob)
+	registerJobHandler("search_reindex", searchReindexJob)
+}
+
+// configureSearch turns on indexing when SEARCH_URL is set. Every category
+// change in the outbox becomes a search_index job, so a cluster outage is
+// retried rather than lost.
+func configureSearch() {
+	searchURL = strings.TrimRight(os.Getenv("SEARCH_URL"), "/")
+	if v := os.Getenv("SEARCH_INDEX"); v != "" {
+		searchIndex = v
+	}
+	if searchURL == "" {
+		return
+	}
+	subscribeEvents(func(e ChangeEvent) {
+		if e.Resource != "category" {
+			return
+		}
+		c, ok := e.Data.(Category)
+		if !ok {
+			return
+		}
+		task := SearchIndexTask{Op: e.Op, ID: e.ResourceID, Seq: e.Seq, Category: &c}
+		if e.Op == ChangeDeleted {
+			task.Category = nil
+		}
+		if _, err := enqueueJob("search_index", task); err != nil {
+			log.Printf("search: %v", err)
+		}
+	})
+}
+
+// searchIndexJob writes one document. The change sequence is used as an
+// external version, so when jobs for the same category finish out of order
+// the cluster keeps the newest and answers 409 for the stale one.
+func searchIndexJob(job *Job) error {
+	var task SearchIndexTask
+	if err := json.Unmarshal(job.Payload, &task); err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("/%s/_doc/%d?version=%d&version_type=external", url.PathEscape(searchIndex), task.ID, task.Seq)
+	method, body := http.MethodPut, []byte(nil)
+	if task.Op == ChangeDeleted {
+		method = http.MethodDelete
+	} else {
+		var err error
+		if body, err = json.Marshal(task.Category); err != nil {
+			return err
+		}
+	}
+
+	status, resp, err := searchRequest(method, path, "application/json", body)
+	if err != nil {
+		return err
+	}
+	if status >= 300 && status != http.StatusConflict && status != http.StatusNotFound {
+		return fmt.Errorf("search: %s %s returned %d: %s", method, path, status, resp)
+	}
+	return nil
+}
+
+// searchReindexJob rebuilds the index from the store: the index is dropped
+// and every live category written again in one bulk request. Searches may
+// return partial results while it runs.
+func searchReindexJob(job *Job) error {
+	storeMu.RLock()
+	seq := changeSeq
+	docs := []Category{}
+	for _, c := range sortedCategories() {
+		copied := *c
+		copied.Tags = append([]string{}, c.Tags...)
+		docs = append(docs, copied)
+	}
+	storeMu.RUnlock()
+
+	status, resp, err := searchRequest(http.MethodDelete, "/"+url.PathEscape(searchIndex), "", nil)
+	if err != nil {
+		return err
+	}
+	if status >= 300 && status != http.StatusNotFound {
+		return fmt.Errorf("search: deleting index returned %d: %s", status, resp)
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, c := range docs {
+		enc.Encode(map[string]interface{}{"index": map[string]interface{}{
+			"_index": searchIndex, "_id": strconv.Itoa(c.ID), "version": seq, "version_type": "external",
+		}})
+		enc.Encode(c)
+	}
+	status, resp, err = searchRequest(http.MethodPost, "/_bulk", "application/x-ndjson", buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if status >= 300 {
+		return fmt.Errorf("search: bulk index returned %d: %s", status, resp)
+	}
+	log.Printf("search: reindexed %d categories", len(docs))
+	return nil
+}
+
+func searchRequest(method, path, contentType string, body []byte) (int, []byte, error) {
+	req, err := http.NewRequest(method, searchURL+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if err := searchBreaker.allow(); err != nil {
+		return 0, nil, err
+	}
+	resp, err := searchClient.Do(req)
+	searchBreaker.record(err == nil && resp.StatusCode < 500)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return resp.StatusCode, data, nil
+}
+
+// searchCategoryIDs asks the cluster for a page of the best matches p may
+// read, most relevant first, and the total number of them.
+func searchCategoryIDs(q string, page Page, p *Principal) ([]int, int, error) {
+	match := map[string]interface{}{
+		"multi_match": map[string]interface{}{"query": q, "fields": []string{"name^2", "description", "tags"}},
+	}
+	query, _ := json.Marshal(map[string]interface{}{
+		"from":             page.Offset,
+		"size":             page.Limit,
+		"_source":          false,
+		"track_total_hits": true,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{"must": match, "filter": aclSearchFilter(p)},
+		},
+	})
+	status, resp, err := searchRequest(http.MethodPost, "/"+url.PathEscape(searchIndex)+"/_search", "application/json", query)
+	if err != nil {
+		return nil, 0, err
+	}
+	if status >= 300 {
+		return nil, 0, fmt.Errorf("search returned %d", status)
+	}
+
+	// hits.total is an object from Elasticsearch 7 and OpenSearch on, and a
+	// plain number before that.
+	var result struct {
+		Hits struct {
+			Total json.RawMessage `json:"total"`
+			Hits  []struct {
+				ID string `json:"_id"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, 0, err
+	}
+	var total struct {
+		Value int `json:"value"`
+	}
+	if err := json.Unmarshal(result.Hits.Total, &total); err != nil {
+		json.Unmarshal(result.Hits.Total, &total.Value)
+	}
+	ids := []int{}
+	for _, hit := range result.Hits.Hits {
+		if id, err := strconv.Atoi(hit.ID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, total.Value, nil
+}
+
+// aclSearchFilter is canReadCategory as a cluster query, so the total and
+// the pages only count categories p may read: no read entries, or a read or
+// write entry naming p or one of its roles. ACL entries are matched on the
+// keyword subfield dynamic mapping gives strings.
+func aclSearchFilter(p *Principal) []interface{} {
+	if p == nil || p.Admin {
+		return []interface{}{}
+	}
+	names := []string{}
+	if p.User != "" {
+		names = append(names, "user:"+p.User)
+	}
+	for _, role := range p.Roles {
+		names = append(names, "role:"+role)
+	}
+	return []interface{}{map[string]interface{}{
+		"bool": map[string]interface{}{
+			"minimum_should_match": 1,
+			"should": []interface{}{
+				map[string]interface{}{"bool": map[string]interface{}{"must_not": map[string]interface{}{"exists": map[string]interface{}{"field": "acl.read"}}}},
+				map[string]interface{}{"terms": map[string]interface{}{"acl.read.keyword": names}},
+				map[string]interface{}{"terms": map[string]interface{}{"acl.write.keyword": names}},
+			},
+		},
+	}}
+}
+
+// matchCategory is the in-memory fallback: a case-insensitive substring
+// match on name, description or tag.
+func matchCategory(c *Category, q string) bool {
+	q = strings.ToLower(q)
+	if strings.Contains(strings.ToLower(c.Name), q) || strings.Contains(strings.ToLower(c.Description), q) {
+		return true
+	}
+	for _, t := range c.Tags {
+		if strings.Contains(t, q) {
+			return true
+		}
+	}
+	return false
+}
+
+// SearchCategories godoc
+// @Summary Search categories
+// @Description Full-text search over name, description and tags. Served by Elasticsearch/OpenSearch when
+// @Description SEARCH_URL is set (most relevant first), otherwise by a substring match in display order.
+// @Description Categories the caller's ACLs hide are neither returned nor counted in X-Total-Count.
+// @Description After BREAKER_THRESHOLD cluster failures in a row, searches fail fast with 503 and Retry-After.
+// @Tags Category
+// @Produce json
+// @Param q query string true "Search text"
+// @Param limit query int false "Page size (default PAGE_SIZE_DEFAULT, 20; at most PAGE_SIZE_MAX, 200)"
+// @Param offset query int false "Results to skip"
+// @Success 200 {array} Category
+// @Failure 400 {string} string
+// @Failure 502 {string} string
+// @Failure 503 {string} string
+// @Router /categories/search [get]
+func SearchCategories(w http.ResponseWriter, r *http.Request) error {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		return &statusError{CodeValidationFailed, "q is required"}
+	}
+	page, err := parsePage(r.URL.Query(), defaultPageSize, maxPageSize)
+	if err != nil {
+		return err
+	}
+
+	result := []*Category{}
+	if searchURL == "" {
+		storeMu.RLock()
+		defer storeMu.RUnlock()
+		for _, c := range readableCategories(requestPrincipal(r), sortedCategories()) {
+			if matchCategory(c, q) {
+				result = append(result, c)
+			}
+		}
+		setPageHeaders(w, r, page, len(result))
+		w.Header().Set("Content-Type", "application/json")
+		encodeJSON(w, paginate(result, page))
+		return nil
+	}
+
+	// The cluster is queried without holding the store lock and filters by
+	// ACL itself, so the total doesn't count categories the caller can't
+	// see. Hits are then resolved against the store, so deleted categories,
+	// or ones whose ACL changed since they were indexed, never show up.
+	p := requestPrincipal(r)
+	ids, total, err := searchCategoryIDs(q, page, p)
+	if errors.Is(err, errCircuitOpen) {
+		writeRetryAfter(w, err)
+		return &statusError{CodeBackendUnavailable, "search is unavailable after repeated failures; retry later"}
+	}
+	if err != nil {
+		log.Printf("search: %v", err)
+		return &statusError{CodeSearchUnavailable, "search is unavailable"}
+	}
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+	for _, id := range ids {
+		if c, ok := findCategory(id); ok && canReadCategory(p, c) {
+			result = append(result, c)
+		}
+	}
+	setPageHeaders(w, r, page, total)
+	w.Header().Set("Content-Type", "application/json")
+	encodeJSON(w, result)
+	return nil
+}
+
+// ReindexSearch godoc
+// @Summary Rebuild the search index
+// @Description Queues a search_reindex job that drops the index and writes every live category again.
+// @Tags Admin
+// @Produce json
+// @Success 202 {object} Job
+// @Failure 501 {string} string
+// @Router /admin/search/reindex [post]
+func ReindexSearch(w http.ResponseWriter, r *http.Request) error {
+	if searchURL == "" {
+		return &statusError{CodeSearchNotConfigured, "SEARCH_URL is not set"}
+	}
+	job, err := enqueueJob("search_reindex", struct{}{})
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	encodeJSON(w, job)
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// useCategories replaces the store with cs for the length of a test.
+func useCategories(t *testing.T, cs ...*Category) {
+	storeMu.Lock()
+	saved := categories
+	categories = map[int]*Category{}
+	for _, c := range cs {
+		categories[c.ID] = c
+	}
+	storeMu.Unlock()
+	t.Cleanup(func() {
+		storeMu.Lock()
+		categories = saved
+		storeMu.Unlock()
+	})
+}
+
+func searchFixture(t *testing.T) {
+	useCategories(t,
+		&Category{ID: 1, Name: "apple", Position: 1, Tags: []string{}},
+		&Category{ID: 2, Name: "apple pie", Position: 2, Tags: []string{}, ACL: &CategoryACL{Read: []string{"user:alice"}}},
+		&Category{ID: 3, Name: "apple tart", Position: 3, Tags: []string{}, ACL: &CategoryACL{Read: []string{"role:eng"}, Write: []string{"user:bob"}}},
+	)
+}
+
+var searchCallers = []struct {
+	name   string
+	caller *Principal
+	ids    []int
+}{
+	{"anonymous", &Principal{}, []int{1}},
+	{"read entry", &Principal{User: "alice"}, []int{1, 2}},
+	{"read role", &Principal{User: "carol", Roles: []string{"eng"}}, []int{1, 3}},
+	{"write entry", &Principal{User: "bob"}, []int{1, 3}},
+	{"admin", &Principal{User: "root", Admin: true}, []int{1, 2, 3}},
+}
+
+func runSearch(t *testing.T, caller *Principal, query string) ([]int, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r := withPrincipal(httptest.NewRequest("GET", "/categories/search?"+query, nil), caller)
+	if err := SearchCategories(w, r); err != nil {
+		t.Fatalf("SearchCategories: %v", err)
+	}
+	var result []Category
+	json.Unmarshal(w.Body.Bytes(), &result)
+	ids := []int{}
+	for _, c := range result {
+		ids = append(ids, c.ID)
+	}
+	return ids, w.Header().Get("X-Total-Count")
+}
+
+func TestSearchInMemoryHonoursACL(t *testing.T) {
+	searchFixture(t)
+	saved := searchURL
+	searchURL = ""
+	t.Cleanup(func() { searchURL = saved })
+
+	for _, tt := range searchCallers {
+		ids, total := runSearch(t, tt.caller, "q=apple")
+		if !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("%s: ids = %v, want %v", tt.name, ids, tt.ids)
+		}
+		if want := len(tt.ids); total != strconv.Itoa(want) {
+			t.Errorf("%s: X-Total-Count = %s, want %d", tt.name, total, want)
+		}
+	}
+}
+
+func TestSearchClusterHonoursACL(t *testing.T) {
+	searchFixture(t)
+
+	// The fake cluster applies the ACL filter it is sent, so the test
+	// fails if the query doesn't carry it.
+	cluster := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var query struct {
+			Query struct {
+				Bool struct {
+					Filter []json.RawMessage `json:"filter"`
+				} `json:"bool"`
+			} `json:"query"`
+		}
+		json.Unmarshal(body, &query)
+		ids := []string{"1", "2", "3"}
+		if len(query.Query.Bool.Filter) > 0 {
+			ids = clusterMatches(query.Query.Bool.Filter[0])
+		}
+		hits := []map[string]string{}
+		for _, id := range ids {
+			hits = append(hits, map[string]string{"_id": id})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"hits": map[string]interface{}{"total": map[string]int{"value": len(hits)}, "hits": hits},
+		})
+	}))
+	defer cluster.Close()
+	saved := searchURL
+	searchURL = cluster.URL
+	t.Cleanup(func() { searchURL = saved })
+
+	for _, tt := range searchCallers {
+		ids, total := runSearch(t, tt.caller, "q=apple")
+		if !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("%s: ids = %v, want %v", tt.name, ids, tt.ids)
+		}
+		if want := len(tt.ids); total != strconv.Itoa(want) {
+			t.Errorf("%s: X-Total-Count = %s, want %d", tt.name, total, want)
+		}
+	}
+}
+
+// clusterMatches evaluates the filter from aclSearchFilter against the
+// fixture's ACLs, as the cluster would.
+func clusterMatches(filter json.RawMessage) []string {
+	var f struct {
+		Bool struct {
+			Should []struct {
+				Terms map[string][]string `json:"terms"`
+			} `json:"should"`
+		} `json:"bool"`
+	}
+	json.Unmarshal(filter, &f)
+	names := map[string]bool{}
+	for _, clause := range f.Bool.Should {
+		for _, n := range clause.Terms["acl.read.keyword"] {
+			names[n] = true
+		}
+	}
+	ids := []string{"1"}
+	if names["user:alice"] {
+		ids = append(ids, "2")
+	}
+	if names["role:eng"] || names["user:bob"] {
+		ids = append(ids, "3")
+	}
+	return ids
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// =======================
+// SHUTDOWN HOOKS
+// =======================
+
+// shutdownHook closes or drains one subsystem once the HTTP server has
+// stopped. A hook runs before every hook it depends on, so a subsystem that
+// writes into another stops first: the scheduler before the job queue, the
+// queue before the event bus.
+type shutdownHook struct {
+	name      string
+	dependsOn []string
+	run       func(ctx context.Context) error
+}
+
+var (
+	shutdownHooks []*shutdownHook
+
+	// shutdownHookTimeout bounds all hooks together (SHUTDOWN_HOOK_TIMEOUT).
+	// A hook still running when it ends is abandoned and the rest are
+	// started with an expired context, so they can skip waiting.
+	shutdownHookTimeout = 10 * time.Second
+)
+
+// registerShutdownHook adds a hook for the subsystem name. dependsOn names
+// the subsystems it uses; they are closed after it. Call it from init.
+func registerShutdownHook(name string, dependsOn []string, run func(ctx context.Context) error) {
+	shutdownHooks = append(shutdownHooks, &shutdownHook{name: name, dependsOn: dependsOn, run: run})
+}
+
+// shutdownOrder sorts the hooks so each runs before its dependencies, keeping
+// registration order otherwise. It fails on an unknown dependency or a cycle.
+func shutdownOrder() ([]*shutdownHook, error) {
+	byName := map[string]*shutdownHook{}
+	for _, h := range shutdownHooks {
+		byName[h.name] = h
+	}
+	// dependents counts, per hook, the hooks that must run before it.
+	dependents := map[string]int{}
+	for _, h := range shutdownHooks {
+		for _, dep := range h.dependsOn {
+			if byName[dep] == nil {
+				return nil, fmt.Errorf("shutdown hook %q depends on unknown %q", h.name, dep)
+			}
+			dependents[dep]++
+		}
+	}
+
+	order := make([]*shutdownHook, 0, len(shutdownHooks))
+	done := map[string]bool{}
+	for len(order) < len(shutdownHooks) {
+		progressed := false
+		for _, h := range shutdownHooks {
+			if done[h.name] || dependents[h.name] > 0 {
+				continue
+			}
+			done[h.name] = true
+			order = append(order, h)
+			for _, dep := range h.dependsOn {
+				dependents[dep]--
+			}
+			progressed = true
+		}
+		if !progressed {
+			return nil, fmt.Errorf("shutdown hooks have a dependency cycle")
+		}
+	}
+	return order, nil
+}
+
+// runShutdownHooks runs every hook in order. A failing hook is logged and
+// doesn't stop the others.
+func runShutdownHooks() {
+	order, err := shutdownOrder()
+	if err != nil {
+		log.Printf("shutdown hooks: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownHookTimeout)
+	defer cancel()
+	for _, h := range order {
+		start := time.Now()
+		if err := h.run(ctx); err != nil {
+			log.Printf("shutdown: %s: %v", h.name, err)
+			continue
+		}
+		log.Printf("shutdown: %s closed in %s", h.name, time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// waitContext waits for wg, giving up when ctx ends.
+func waitContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// =======================
+// STATIC ASSETS
+// =======================
+
+//go:embed static
+var staticFiles embed.FS
+
+// staticMaxAge is how long browsers may cache CSS, JS and images
+// (STATIC_MAX_AGE). HTML pages always revalidate so a deploy shows up at once.
+var staticMaxAge = time.Hour
+
+// staticAsset is an embedded file with its ETag, computed once at startup.
+type staticAsset struct {
+	name string
+	data []byte
+	etag string
+}
+
+var staticAssets = map[string]*staticAsset{}
+
+func init() {
+	fs.WalkDir(staticFiles, "static", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := staticFiles.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		name := strings.TrimPrefix(path, "static/")
+		staticAssets[name] = &staticAsset{name: name, data: data, etag: `"` + hex.EncodeToString(sum[:8]) + `"`}
+		return nil
+	})
+}
+
+// serveStatic writes an embedded file, answering If-None-Match with 304.
+// The content type comes from the file extension.
+func serveStatic(w http.ResponseWriter, r *http.Request, name string) error {
+	asset, ok := staticAssets[name]
+	if !ok {
+		return errRouteNotFound
+	}
+	if strings.HasSuffix(name, ".html") {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(staticMaxAge.Seconds())))
+	}
+	w.Header().Set("ETag", asset.etag)
+	http.ServeContent(w, r, asset.name, time.Time{}, bytes.NewReader(asset.data))
+	return nil
+}
+
+// Home serves the landing page to browsers and the plain "API is running"
+// text to everything else, so existing health checks keep working. The spec
+// of an unknown API version answers 404 rather than that text, so tooling
+// pinned to it fails loudly.
+func Home(w http.ResponseWriter, r *http.Request) error {
+	if r.URL.Path == "/" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return serveStatic(w, r, "index.html")
+	}
+	if parts := pathParts(r.URL.Path); len(parts) == 2 && parts[1] == "openapi.json" {
+		return GetOpenAPISpec(w, r)
+	}
+	w.Write([]byte("API is running"))
+	return nil
+}
+
+// StaticAsset serves /static/{path} and /favicon.ico.
+func StaticAsset(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return &statusError{CodeMethodNotAllowed, "Method not allowed"}
+	}
+	return serveStatic(w, r, strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/static/"), "/"))
+}
+
+// AdminUI serves the single-page admin console at /ui/.
+func AdminUI(w http.ResponseWriter, r *http.Request) error {
+	return serveStatic(w, r, "admin/index.html")
+}
@@ -0,0 +1,68 @@
+const rows = document.getElementById("rows");
+const errorBox = document.getElementById("error");
+
+async function request(method, path, body) {
+  const res = await fetch(path, {
+    method,
+    headers: body ? { "Content-Type": "application/json" } : {},
+    body: body ? JSON.stringify(body) : undefined,
+  });
+  if (!res.ok) {
+    throw new Error((await res.text()) || res.statusText);
+  }
+  return res.status === 204 ? null : res.json();
+}
+
+// requestAll fetches every page of a list by following its Link rel="next".
+async function requestAll(path) {
+  const all = [];
+  while (path) {
+    const res = await fetch(path);
+    if (!res.ok) {
+      throw new Error((await res.text()) || res.statusText);
+    }
+    all.push(...(await res.json()));
+    const next = /<([^>]+)>;\s*rel="next"/.exec(res.headers.get("Link") || "");
+    path = next && next[1];
+  }
+  return all;
+}
+
+function cell(text) {
+  const td = document.createElement("td");
+  td.textContent = text;
+  return td;
+}
+
+async function load() {
+  errorBox.textContent = "";
+  try {
+    const categories = await requestAll("/categories");
+    rows.replaceChildren(...categories.map((c) => {
+      const tr = document.createElement("tr");
+      tr.append(cell(c.id), cell(c.name), cell(c.description), cell(c.status));
+      const del = document.createElement("button");
+      del.textContent = "Delete";
+      del.onclick = () => request("DELETE", "/categories/" + c.id).then(load, show);
+      const td = document.createElement("td");
+      td.append(del);
+      tr.append(td);
+      return tr;
+    }));
+  } catch (err) {
+    show(err);
+  }
+}
+
+function show(err) {
+  errorBox.textContent = err.message;
+}
+
+document.getElementById("create").onsubmit = (e) => {
+  e.preventDefault();
+  const form = new FormData(e.target);
+  request("POST", "/categories", { name: form.get("name"), description: form.get("description") })
+    .then(() => { e.target.reset(); load(); }, show);
+};
+
+load();
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// =======================
+// REQUEST TIMEOUTS
+// =======================
+
+var (
+	// requestTimeout is the deadline of every request (REQUEST_TIMEOUT,
+	// 0 disables it).
+	requestTimeout = 30 * time.Second
+	// routeTimeouts overrides requestTimeout per route, keyed like
+	// deprecatedRoutes: "METHOD route" with route as produced by routeLabel,
+	// "*" matching every method. Zero exempts the route. Downloads stream
+	// files from disk and aren't buffered, so they are exempt by default.
+	routeTimeouts = map[string]time.Duration{
+		"* /downloads/*": 0,
+	}
+)
+
+func init() {
+	registerMetric("request_timeouts_total", "counter", "Requests answered with 503 because they ran past their deadline, by route.")
+}
+
+// configureTimeouts reads REQUEST_TIMEOUT and REQUEST_TIMEOUT_ROUTES, a
+// comma-separated list of [METHOD ]route=duration, e.g.
+// "/categories/export=2m,GET /categories/search=5s".
+func configureTimeouts() {
+	requestTimeout = envDuration("REQUEST_TIMEOUT", requestTimeout)
+	for _, entry := range splitList(os.Getenv("REQUEST_TIMEOUT_ROUTES")) {
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			log.Fatalf("invalid REQUEST_TIMEOUT_ROUTES entry %q: want [METHOD ]route=duration", entry)
+		}
+		route, value := strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("invalid REQUEST_TIMEOUT_ROUTES entry %q: %v", entry, err)
+		}
+		if !strings.Contains(route, " ") {
+			route = "* " + route
+		}
+		routeTimeouts[route] = d
+	}
+}
+
+// timeoutFor is the deadline that applies to a request.
+func timeoutFor(r *http.Request) time.Duration {
+	route := routeLabel(r.URL.Path)
+	if d, ok := routeTimeouts[r.Method+" "+route]; ok {
+		return d
+	}
+	if d, ok := routeTimeouts["* "+route]; ok {
+		return d
+	}
+	return requestTimeout
+}
+
+// enforceTimeouts gives each request a context deadline and answers 503
+// REQUEST_TIMEOUT when the handler hasn't finished by then, so a request
+// stuck behind the store lock or a slow backend doesn't hold the connection
+// forever. The handler writes to a buffer and, once abandoned, finishes in
+// the background with its response discarded.
+func enforceTimeouts(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := timeoutFor(r)
+		if timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		rec := &bufferedResponse{header: w.Header().Clone()}
+		done := make(chan struct{})
+		panicked := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			next.ServeHTTP(rec, r)
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			rec.copyTo(w, rec.body.Bytes())
+		case p := <-panicked:
+			// Re-raised here, where recoverPanics can see it.
+			panic(p)
+		case <-ctx.Done():
+			if ctx.Err() != context.DeadlineExceeded {
+				return // the client went away
+			}
+			route := routeLabel(r.URL.Path)
+			addMetric("request_timeouts_total", 1, "route", r.Method+" "+route)
+			log.Printf("request timed out: %s %s route=%s after %s", r.Method, r.URL.Path, route, timeout)
+			writeAPIError(w, CodeRequestTimeout, "request did not complete within "+timeout.String())
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"net/http"
+	"os"
+)
+
+// =======================
+// TLS & CLIENT CERTIFICATES
+// =======================
+
+// mTLS modes (MTLS_MODE).
+const (
+	MTLSOff           = "off"
+	MTLSRequire       = "require"         // every connection must present a certificate signed by the CA
+	MTLSVerifyIfGiven = "verify_if_given" // certificates are optional but verified when sent
+)
+
+var (
+	// tlsCertFile and tlsKeyFile switch the server to HTTPS (TLS_CERT_FILE,
+	// TLS_KEY_FILE).
+	tlsCertFile, tlsKeyFile string
+	// serverTLS is the server's TLS config; nil serves plain HTTP.
+	serverTLS *tls.Config
+	// mtlsAllowed, when non-empty, lists the only client identities let in
+	// (MTLS_ALLOWED_IDENTITIES).
+	mtlsAllowed map[string]bool
+)
+
+type identityKey struct{}
+
+// configureTLS reads TLS_CERT_FILE/TLS_KEY_FILE and, for mutual TLS,
+// MTLS_MODE and MTLS_CLIENT_CA_FILE, the PEM bundle client certificates
+// must chain to.
+func configureTLS() {
+	tlsCertFile, tlsKeyFile = os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	mode := os.Getenv("MTLS_MODE")
+	if mode == "" {
+		mode = MTLSOff
+	}
+	if tlsCertFile == "" && tlsKeyFile == "" {
+		if mode != MTLSOff {
+			log.Fatal("MTLS_MODE requires TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return
+	}
+	if tlsCertFile == "" || tlsKeyFile == "" {
+		log.Fatal("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	serverTLS = &tls.Config{MinVersion: tls.VersionTLS12}
+
+	switch mode {
+	case MTLSOff:
+		return
+	case MTLSRequire:
+		serverTLS.ClientAuth = tls.RequireAndVerifyClientCert
+	case MTLSVerifyIfGiven:
+		serverTLS.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		log.Fatalf("invalid MTLS_MODE %q: want %q, %q or %q", mode, MTLSOff, MTLSRequire, MTLSVerifyIfGiven)
+	}
+
+	caFile := os.Getenv("MTLS_CLIENT_CA_FILE")
+	if caFile == "" {
+		log.Fatal("MTLS_CLIENT_CA_FILE is required when MTLS_MODE is set")
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		log.Fatalf("MTLS_CLIENT_CA_FILE: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		log.Fatalf("MTLS_CLIENT_CA_FILE: no certificates found in %s", caFile)
+	}
+	serverTLS.ClientCAs = pool
+
+	for _, id := range splitList(os.Getenv("MTLS_ALLOWED_IDENTITIES")) {
+		if mtlsAllowed == nil {
+			mtlsAllowed = map[string]bool{}
+		}
+		mtlsAllowed[id] = true
+	}
+}
+
+// certIdentity names the holder of a client certificate: its first URI SAN
+// (e.g. a SPIFFE id), else its first DNS SAN, else its subject CN.
+func certIdentity(cert *x509.Certificate) string {
+	switch {
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	}
+	return cert.Subject.CommonName
+}
+
+// requestIdentity is the verified client certificate identity, or "".
+func requestIdentity(r *http.Request) string {
+	id, _ := r.Context().Value(identityKey{}).(string)
+	return id
+}
+
+// clientCertIdentity attaches the identity of a verified client certificate
+// to the request and enforces MTLS_ALLOWED_IDENTITIES. The TLS handshake has
+// already rejected certificates that don't chain to the CA.
+func clientCertIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id := certIdentity(r.TLS.VerifiedChains[0][0])
+		if mtlsAllowed != nil && !mtlsAllowed[id] {
+			writeAPIError(w, CodeClientNotAllowed, "client certificate "+id+" is not allowed")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	})
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// =======================
+// UNIT OF WORK
+// =======================
+
+// storeSnapshot is a copy of everything a transaction may change, deep
+// enough that mutating the live records afterwards doesn't touch it.
+type storeSnapshot struct {
+	categories    map[int]Category
+	autoID        int
+	products      map[int]Product
+	productAutoID int
+	items         map[int]Item
+	itemAutoID    int
+	auditLen      int
+	auditAutoID   int
+	outboxLen     int
+	changelog     []ChangeEvent
+	changeSeq     int64
+	history       map[int][]CategoryRevision
+	customFields  map[string]CustomField
+}
+
+var (
+	// txDepth > 0 while withTx is running; notifications and other effects
+	// are held until commit.
+	txDepth             int
+	pendingNotification []func()
+)
+
+// afterCommit runs fn once the current transaction commits, or at once
+// outside one. A transaction that is rolled back, as every dry run is,
+// drops it, so nothing outside the store sees a change that didn't happen.
+func afterCommit(fn func()) {
+	if txDepth > 0 {
+		pendingNotification = append(pendingNotification, fn)
+		return
+	}
+	fn()
+}
+
+func takeSnapshot() storeSnapshot {
+	s := storeSnapshot{
+		categories:    map[int]Category{},
+		autoID:        autoID,
+		products:      map[int]Product{},
+		productAutoID: productAutoID,
+		items:         map[int]Item{},
+		itemAutoID:    itemAutoID,
+		auditLen:      len(auditLog),
+		auditAutoID:   auditAutoID,
+		outboxLen:     len(outbox),
+		changelog:     append([]ChangeEvent{}, changelog...),
+		changeSeq:     changeSeq,
+		history:       map[int][]CategoryRevision{},
+		customFields:  map[string]CustomField{},
+	}
+	for id, c := range categories {
+		copied := *c
+		copied.Tags = append([]string{}, c.Tags...)
+		s.categories[id] = copied
+	}
+	for id, p := range products {
+		copied := *p
+		copied.CategoryIDs = append([]int{}, p.CategoryIDs...)
+		s.products[id] = copied
+	}
+	for id, it := range items {
+		s.items[id] = *it
+	}
+	// Revisions are never modified once appended, so the slice headers
+	// are enough to go back to.
+	for id, revs := range categoryHistory {
+		s.history[id] = revs
+	}
+	for name, f := range customFields {
+		s.customFields[name] = f
+	}
+	return s
+}
+
+// restore puts the store back the way it was. Existing records are updated
+// in place so pointers held elsewhere stay valid.
+func (s storeSnapshot) restore() {
+	for id, c := range categories {
+		if saved, ok := s.categories[id]; ok {
+			*c = saved
+		} else {
+			delete(categories, id)
+		}
+	}
+	for id, saved := range s.categories {
+		if _, ok := categories[id]; !ok {
+			restored := saved
+			categories[id] = &restored
+		}
+	}
+	autoID = s.autoID
+
+	for id, p := range products {
+		if saved, ok := s.products[id]; ok {
+			*p = saved
+		} else {
+			delete(products, id)
+		}
+	}
+	for id, saved := range s.products {
+		if _, ok := products[id]; !ok {
+			restored := saved
+			products[id] = &restored
+		}
+	}
+	productAutoID = s.productAutoID
+
+	for id, it := range items {
+		if saved, ok := s.items[id]; ok {
+			*it = saved
+		} else {
+			delete(items, id)
+		}
+	}
+	for id, saved := range s.items {
+		if _, ok := items[id]; !ok {
+			restored := saved
+			items[id] = &restored
+		}
+	}
+	itemAutoID = s.itemAutoID
+
+	auditLog = auditLog[:s.auditLen]
+	auditAutoID = s.auditAutoID
+	outbox = outbox[:s.outboxLen]
+	changelog = s.changelog
+	changeSeq = s.changeSeq
+	categoryHistory = s.history
+	customFields = s.customFields
+}
+
+// withTx runs fn as a unit of work: if it returns an error every change it
+// made to the store (records, IDs, audit entries, change events) is rolled
+// back and notifications it raised are dropped. The outermost transaction
+// is also rolled back when the request holding the store has timed out
+// meanwhile, since its client was told to retry. Callers must hold storeMu
+// for writing. A nested call is a savepoint: its failure undoes only its
+// own changes, and its notifications wait for the outermost commit.
+func withTx(fn func() error) error {
+	snapshot := takeSnapshot()
+	pendingLen := len(pendingNotification)
+	txDepth++
+	defer func() {
+		if p := recover(); p != nil {
+			txDepth--
+			pendingNotification = pendingNotification[:pendingLen]
+			snapshot.restore()
+			panic(p)
+		}
+	}()
+	err := fn()
+	txDepth--
+	if err == nil && txDepth == 0 && storeCtx != nil {
+		err = storeCtx.Err()
+	}
+
+	if err != nil {
+		pendingNotification = pendingNotification[:pendingLen]
+		snapshot.restore()
+		return err
+	}
+	if txDepth > 0 {
+		return nil
+	}
+	pending := pendingNotification
+	pendingNotification = nil
+	for _, send := range pending {
+		send()
+	}
+	return nil
+}
+
+// =======================
+// DRY RUN
+// =======================
+
+// errDryRun makes withTx roll back a dry run that otherwise succeeded.
+var errDryRun = errors.New("dry run")
+
+// dryRun runs a mutating request with ?dry_run=true or "Prefer: dry-run"
+// as a transaction that is always rolled back: the client gets exactly the
+// response, validation errors included, that the request would have
+// produced, while the store, change events and notifications stay as they
+// were. It must run inside withStore, which holds storeMu for writing.
+func dryRun(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		preferred := preferDryRun(r)
+		enabled := preferred
+		if v := r.URL.Query().Get("dry_run"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				writeAPIError(w, CodeValidationFailed, "dry_run must be true or false")
+				return
+			}
+			enabled = b
+		}
+		if !enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("X-Dry-Run", "true")
+		if preferred {
+			w.Header().Add("Preference-Applied", "dry-run")
+		}
+		withTx(func() error {
+			next.ServeHTTP(w, r)
+			return errDryRun
+		})
+	})
+}
+
+// preferDryRun reports whether a Prefer header asks for dry-run.
+func preferDryRun(r *http.Request) bool {
+	for _, v := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(pref), "dry-run") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// =======================
+// BUILD INFO
+// =======================
+
+// Set at build time:
+//
+//	go build -ldflags "-X main.version=v1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+//
+// Without them, commit falls back to the VCS stamp the go tool embeds when
+// building inside a checkout, and buildDate to that commit's time.
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// VersionInfo is the answer of GET /version.
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+var buildInfo = readBuildInfo()
+
+func init() {
+	registerMetric("build_info", "gauge", "Always 1; the labels identify the running build.")
+	setMetric("build_info", 1, "version", buildInfo.Version, "commit", buildInfo.Commit, "build_date", buildInfo.BuildDate, "go_version", buildInfo.GoVersion)
+}
+
+func readBuildInfo() VersionInfo {
+	info := VersionInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = s.Value
+			case s.Key == "vcs.modified" && commit == "":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+	return info
+}
+
+// GetVersion godoc
+// @Summary Build information
+// @Description The version, commit and build date the binary was built with, for checking what a deploy rolled out.
+// @Tags Health
+// @Produce json
+// @Success 200 {object} VersionInfo
+// @Router /version [get]
+func GetVersion(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	encodeJSON(w, buildInfo)
+	return nil
+}